@@ -3,6 +3,7 @@ module github.com/meow-pad/chinchilla
 go 1.20
 
 require (
+	github.com/gobwas/ws v1.3.0
 	github.com/gogo/protobuf v1.3.2
 	github.com/meow-pad/persian v0.1.1
 	github.com/nacos-group/nacos-sdk-go/v2 v2.1.1
@@ -23,7 +24,6 @@ require (
 	github.com/go-spring/spring-core v1.1.3 // indirect
 	github.com/gobwas/httphead v0.1.0 // indirect
 	github.com/gobwas/pool v0.2.1 // indirect
-	github.com/gobwas/ws v1.3.0 // indirect
 	github.com/golang/mock v1.6.0 // indirect
 	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/gomodule/redigo v2.0.0+incompatible // indirect
@@ -43,6 +43,7 @@ require (
 	github.com/prometheus/client_model v0.2.0 // indirect
 	github.com/prometheus/common v0.32.1 // indirect
 	github.com/prometheus/procfs v0.7.3 // indirect
+	github.com/spaolacci/murmur3 v1.1.0 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
 	github.com/valyala/fastrand v1.1.0 // indirect
 	go.uber.org/atomic v1.11.0 // indirect
@@ -6,6 +6,7 @@ import (
 	"github.com/meow-pad/chinchilla/transfer/common"
 	"github.com/meow-pad/chinchilla/transfer/router"
 	"github.com/meow-pad/chinchilla/transfer/selector"
+	"github.com/meow-pad/chinchilla/utils/hashring"
 	netcodec "github.com/meow-pad/persian/frame/pnet/tcp/codec"
 	"github.com/meow-pad/persian/frame/pnet/tcp/session"
 	ws "github.com/meow-pad/persian/frame/pnet/ws/server"
@@ -25,6 +26,9 @@ func NewOptions(opts ...Option) *Options {
 
 		MessageExecutorWorkerNum:   runtime.NumGoroutine() + 1,
 		MessageExecutorQueueLength: 1000,
+		RouterExecutorWorkerNum:    runtime.NumGoroutine() + 1,
+		RouterExecutorQueueLength:  1000,
+		RouterSessionTimeout:       3 * time.Second,
 
 		TransferClientReadBufferCap:    512 * 1024,
 		TransferClientWriteBufferCap:   512 * 1024,
@@ -36,6 +40,23 @@ func NewOptions(opts ...Option) *Options {
 		TransferClientDisableTimeout:   60_000,
 		TransferKeepAliveInterval:      10 * time.Second,
 		TransferMessageWarningSize:     8 * 1024,
+		TransferMaxFrameSize:           4 * 1024 * 1024,
+		TransferSegmentMaxSize:         4 * 1024 * 1024,
+		TransferSegmentTimeout:         30 * time.Second,
+		TransferCompressThreshold:      1024,
+		TransferBatchMaxSize:           16 * 1024,
+
+		TransferOutlierConsecutiveErrors:  5,
+		TransferOutlierFlapThreshold:      3,
+		TransferOutlierFlapWindow:         30 * time.Second,
+		TransferOutlierBaseEjectionTime:   10 * time.Second,
+		TransferOutlierMaxEjectionTime:    5 * time.Minute,
+		TransferOutlierMaxEjectionPercent: 50,
+
+		ServiceHashReplicas: hashring.DefaultReplicas,
+
+		MeshLookupTimeout:  time.Second,
+		MeshLookupCacheTTL: 10 * time.Second,
 
 		NamingServicePort:      8848,
 		NamingServiceTimeoutMs: 10 * 1000,
@@ -56,6 +77,8 @@ type Options struct {
 	ReceiverServerOptions []ws.Option // setting
 	// 编解码字节序
 	ReceiverCodecByteOrder binary.ByteOrder
+	// 是否支持json文本协议，仅供调试，生产环境必须保持关闭（默认关闭）
+	ReceiverJsonDebugMode bool
 
 	// 为登录过期时间，单位毫秒
 	UnregisteredSenderExpiration int64
@@ -68,6 +91,14 @@ type Options struct {
 	MessageExecutorWorkerNum int
 	// 工作队列长度
 	MessageExecutorQueueLength int
+	// 路由消息工作核心数，默认逻辑核心数加1
+	RouterExecutorWorkerNum int
+	// 路由消息工作队列长度
+	RouterExecutorQueueLength int
+	// 路由消息无序处理（提交至协程池），默认按（来源连接，路由目标）保序
+	RouterMessageUnordered bool
+	// 路由至会话时等待会话所在工作协程处理的超时时间
+	RouterSessionTimeout time.Duration
 
 	// 转发读缓冲容量
 	TransferClientReadBufferCap int
@@ -91,6 +122,32 @@ type Options struct {
 	TransferServiceAuthKey string // setting
 	// 转发告警消息大小
 	TransferMessageWarningSize int
+	// 转发可接收的最大帧长度，超过32K时与对端握手协商使用4字节长度帧，否则超长消息分段发送
+	TransferMaxFrameSize int
+	// 分段消息重组后的最大长度
+	TransferSegmentMaxSize int
+	// 分段消息重组超时时间
+	TransferSegmentTimeout time.Duration
+	// 是否开启转发连接压缩（需对端支持）
+	TransferCompression bool
+	// 压缩阈值，消息不小于该长度时压缩
+	TransferCompressThreshold int
+	// 批量发送窗口，大于0且对端支持时，窗口内的 MessageSReq 合并为一帧发送
+	TransferBatchWindow time.Duration
+	// 批量帧达到该长度时立即发送
+	TransferBatchMaxSize int
+	// 连续失败（连接、发送失败）达到该次数时暂时摘除实例，握手被拒绝时直接摘除，<=0 时不摘除
+	TransferOutlierConsecutiveErrors int
+	// 统计窗口内连接断开达到该次数时暂时摘除实例，<=0 时不统计
+	TransferOutlierFlapThreshold int
+	// 连接断开统计窗口
+	TransferOutlierFlapWindow time.Duration
+	// 首次摘除时长，再次摘除时翻倍
+	TransferOutlierBaseEjectionTime time.Duration
+	// 最大摘除时长，恢复后超过该时长未被摘除则重新从首次摘除时长计算
+	TransferOutlierMaxEjectionTime time.Duration
+	// 同一服务同时摘除的实例占比上限，取值 0-100
+	TransferOutlierMaxEjectionPercent int
 
 	// 通过该配置直接配置服务或者通过以下配置创建一个
 	NamingService *name.NacosNaming
@@ -124,12 +181,35 @@ type Options struct {
 	LocalContextBuilder func(session.Session) (session.Context, error) // setting
 	// 本地服务消息处理器
 	LocalMessageHandler map[string]handler.MessageHandler // setting
-	// 服务选择器
-	ServiceSelector selector.Selector // 默认值为cache和wrr的组合
-	// 服务路由
+	// 服务选择器，所有服务共享同一实例
+	//
+	// Deprecated: 关注多个服务时实例列表会相互覆盖，仅在只关注一个服务时可用，使用 ServiceSelectorFactory
+	ServiceSelector selector.Selector
+	// 服务选择器构建，每个服务分别构建，默认为 selector.NewWeightSelector
+	ServiceSelectorFactory selector.Factory
+	// 按服务名指定的选择器构建，优先于 ServiceSelectorFactory
+	ServiceSelectorFactories map[string]selector.Factory
+	// 服务路由，未注册路由类型的处理路由，默认为 CommonRouter
 	ServiceRouter router.Router
+	// 自定义路由类型处理，可覆盖内置路由类型（如 router.RouteTypeSelect）
+	ServiceRoutes map[int16]router.RouteFunc
+	// 一致性哈希路由中权重为1的实例的虚拟节点数
+	ServiceHashReplicas int
 	// 服务实例变化监听
 	ServiceInstListener func(service string, instances []common.Info)
+
+	// 网关组网服务名，为空时不组网；网关以该服务名注册自身并通过注册中心发现其它网关
+	MeshServiceName string // setting
+	// 组网监听及注册的地址
+	MeshServerIP string // setting
+	// 组网监听及注册的端口
+	MeshServerPort uint64 // setting
+	// 服务的组广播及全服广播是否经组网转发至其它网关（开启后服务仅需发往任一网关）
+	MeshRelayBroadcast bool
+	// 向其它网关查询会话的超时时间
+	MeshLookupTimeout time.Duration
+	// 查询到的会话所在网关的缓存时间，<=0 时不缓存；会话迁移至其它网关后，缓存期内的消息可能发往原网关
+	MeshLookupCacheTTL time.Duration
 }
 
 type Option func(*Options)
@@ -152,6 +232,12 @@ func WithReceiverServerOptions(value ...ws.Option) Option {
 	}
 }
 
+func WithReceiverJsonDebugMode(value bool) Option {
+	return func(options *Options) {
+		options.ReceiverJsonDebugMode = value
+	}
+}
+
 func WithUnregisteredSenderExpiration(value int64) Option {
 	return func(options *Options) {
 		options.UnregisteredSenderExpiration = value
@@ -177,6 +263,26 @@ func WithMessageExecutorQueueLength(value int) Option {
 		options.MessageExecutorQueueLength = value
 	}
 }
+func WithRouterExecutorWorkerNum(value int) Option {
+	return func(options *Options) {
+		options.RouterExecutorWorkerNum = value
+	}
+}
+func WithRouterExecutorQueueLength(value int) Option {
+	return func(options *Options) {
+		options.RouterExecutorQueueLength = value
+	}
+}
+func WithRouterMessageUnordered(value bool) Option {
+	return func(options *Options) {
+		options.RouterMessageUnordered = value
+	}
+}
+func WithRouterSessionTimeout(value time.Duration) Option {
+	return func(options *Options) {
+		options.RouterSessionTimeout = value
+	}
+}
 func WithTransferClientReadBufferCap(value int) Option {
 	return func(options *Options) {
 		options.TransferClientReadBufferCap = value
@@ -228,6 +334,78 @@ func WithTransferServiceAuthKey(value string) Option {
 	}
 }
 
+func WithTransferMaxFrameSize(value int) Option {
+	return func(options *Options) {
+		options.TransferMaxFrameSize = value
+	}
+}
+func WithTransferSegmentMaxSize(value int) Option {
+	return func(options *Options) {
+		options.TransferSegmentMaxSize = value
+	}
+}
+func WithTransferSegmentTimeout(value time.Duration) Option {
+	return func(options *Options) {
+		options.TransferSegmentTimeout = value
+	}
+}
+func WithTransferCompression(value bool) Option {
+	return func(options *Options) {
+		options.TransferCompression = value
+	}
+}
+func WithTransferCompressThreshold(value int) Option {
+	return func(options *Options) {
+		options.TransferCompressThreshold = value
+	}
+}
+func WithTransferBatchWindow(value time.Duration) Option {
+	return func(options *Options) {
+		options.TransferBatchWindow = value
+	}
+}
+func WithTransferBatchMaxSize(value int) Option {
+	return func(options *Options) {
+		options.TransferBatchMaxSize = value
+	}
+}
+
+func WithTransferOutlierConsecutiveErrors(value int) Option {
+	return func(options *Options) {
+		options.TransferOutlierConsecutiveErrors = value
+	}
+}
+
+func WithTransferOutlierFlapThreshold(value int) Option {
+	return func(options *Options) {
+		options.TransferOutlierFlapThreshold = value
+	}
+}
+
+func WithTransferOutlierFlapWindow(value time.Duration) Option {
+	return func(options *Options) {
+		options.TransferOutlierFlapWindow = value
+	}
+}
+
+func WithTransferOutlierBaseEjectionTime(value time.Duration) Option {
+	return func(options *Options) {
+		options.TransferOutlierBaseEjectionTime = value
+	}
+}
+
+func WithTransferOutlierMaxEjectionTime(value time.Duration) Option {
+	return func(options *Options) {
+		options.TransferOutlierMaxEjectionTime = value
+	}
+}
+
+func WithTransferOutlierMaxEjectionPercent(value int) Option {
+	return func(options *Options) {
+		options.TransferOutlierMaxEjectionPercent = value
+	}
+}
+
 func WithNamingService(value *name.NacosNaming) Option {
 	return func(options *Options) {
 		options.NamingService = value
@@ -323,14 +501,77 @@ func WithServiceSelector(value selector.Selector) Option {
 	}
 }
 
+func WithServiceSelectorFactory(value selector.Factory) Option {
+	return func(options *Options) {
+		options.ServiceSelectorFactory = value
+	}
+}
+
+func WithServiceSelectorFactories(value map[string]selector.Factory) Option {
+	return func(options *Options) {
+		options.ServiceSelectorFactories = value
+	}
+}
+
 func WithServiceRouter(value router.Router) Option {
 	return func(options *Options) {
 		options.ServiceRouter = value
 	}
 }
 
+func WithServiceRoute(routerType int16, route router.RouteFunc) Option {
+	return func(options *Options) {
+		if options.ServiceRoutes == nil {
+			options.ServiceRoutes = make(map[int16]router.RouteFunc)
+		}
+		options.ServiceRoutes[routerType] = route
+	}
+}
+
+func WithServiceHashReplicas(value int) Option {
+	return func(options *Options) {
+		options.ServiceHashReplicas = value
+	}
+}
+
 func WithServiceInstListener(listener func(service string, instances []common.Info)) Option {
 	return func(options *Options) {
 		options.ServiceInstListener = listener
 	}
 }
+
+func WithMeshServiceName(value string) Option {
+	return func(options *Options) {
+		options.MeshServiceName = value
+	}
+}
+
+func WithMeshServerIP(value string) Option {
+	return func(options *Options) {
+		options.MeshServerIP = value
+	}
+}
+
+func WithMeshServerPort(value uint64) Option {
+	return func(options *Options) {
+		options.MeshServerPort = value
+	}
+}
+
+func WithMeshRelayBroadcast(value bool) Option {
+	return func(options *Options) {
+		options.MeshRelayBroadcast = value
+	}
+}
+
+func WithMeshLookupTimeout(value time.Duration) Option {
+	return func(options *Options) {
+		options.MeshLookupTimeout = value
+	}
+}
+
+func WithMeshLookupCacheTTL(value time.Duration) Option {
+	return func(options *Options) {
+		options.MeshLookupCacheTTL = value
+	}
+}
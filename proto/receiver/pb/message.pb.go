@@ -21,12 +21,13 @@ var _ = math.Inf
 const _ = proto.GoGoProtoPackageIsVersion3 // please upgrade the proto package
 
 type HandshakeReq struct {
-	RouterId             string   `protobuf:"bytes,1,opt,name=routerId,proto3" json:"routerId,omitempty"`
-	AuthKey              string   `protobuf:"bytes,2,opt,name=authKey,proto3" json:"authKey,omitempty"`
-	Service              string   `protobuf:"bytes,3,opt,name=service,proto3" json:"service,omitempty"`
-	XXX_NoUnkeyedLiteral struct{} `json:"-"`
-	XXX_unrecognized     []byte   `json:"-"`
-	XXX_sizecache        int32    `json:"-"`
+	RouterId             string            `protobuf:"bytes,1,opt,name=routerId,proto3" json:"routerId,omitempty"`
+	AuthKey              string            `protobuf:"bytes,2,opt,name=authKey,proto3" json:"authKey,omitempty"`
+	Service              string            `protobuf:"bytes,3,opt,name=service,proto3" json:"service,omitempty"`
+	Labels               map[string]string `protobuf:"bytes,4,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
 }
 
 func (m *HandshakeReq) Reset()         { *m = HandshakeReq{} }
@@ -74,6 +75,13 @@ func (m *HandshakeReq) GetService() string {
 	return ""
 }
 
+func (m *HandshakeReq) GetLabels() map[string]string {
+	if m != nil {
+		return m.Labels
+	}
+	return nil
+}
+
 type HandshakeRes struct {
 	Code                 uint32   `protobuf:"varint,1,opt,name=code,proto3" json:"code,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
@@ -290,6 +298,7 @@ func (m *MessageRes) GetPayload() []byte {
 
 func init() {
 	proto.RegisterType((*HandshakeReq)(nil), "HandshakeReq")
+	proto.RegisterMapType((map[string]string)(nil), "HandshakeReq.LabelsEntry")
 	proto.RegisterType((*HandshakeRes)(nil), "HandshakeRes")
 	proto.RegisterType((*HeartbeatReq)(nil), "HeartbeatReq")
 	proto.RegisterType((*HeartbeatRes)(nil), "HeartbeatRes")
@@ -302,19 +311,23 @@ func init() {
 }
 
 var fileDescriptor_ac3aacdbb230774d = []byte{
-	// 216 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0x52, 0x2a, 0x28, 0xca, 0x2f,
-	0xc9, 0xd7, 0x2f, 0x4a, 0x4d, 0x4e, 0xcd, 0x2c, 0x4b, 0x2d, 0xd2, 0x87, 0x70, 0x73, 0x53, 0x8b,
-	0x8b, 0x13, 0xd3, 0x53, 0xf5, 0xc0, 0x3c, 0xa5, 0x38, 0x2e, 0x1e, 0x8f, 0xc4, 0xbc, 0x94, 0xe2,
-	0x8c, 0xc4, 0xec, 0xd4, 0xa0, 0xd4, 0x42, 0x21, 0x29, 0x2e, 0x8e, 0xa2, 0xfc, 0xd2, 0x92, 0xd4,
-	0x22, 0xcf, 0x14, 0x09, 0x46, 0x05, 0x46, 0x0d, 0xce, 0x20, 0x38, 0x5f, 0x48, 0x82, 0x8b, 0x3d,
-	0xb1, 0xb4, 0x24, 0xc3, 0x3b, 0xb5, 0x52, 0x82, 0x09, 0x2c, 0x05, 0xe3, 0x82, 0x64, 0x8a, 0x53,
-	0x8b, 0xca, 0x32, 0x93, 0x53, 0x25, 0x98, 0x21, 0x32, 0x50, 0xae, 0x92, 0x12, 0x8a, 0xf9, 0xc5,
-	0x42, 0x42, 0x5c, 0x2c, 0xc9, 0xf9, 0x29, 0xa9, 0x60, 0xb3, 0x79, 0x83, 0xc0, 0x6c, 0x25, 0x0d,
-	0x2e, 0x1e, 0x8f, 0xd4, 0xc4, 0xa2, 0x92, 0xa4, 0xd4, 0xc4, 0x12, 0x90, 0x1b, 0x24, 0xb8, 0xd8,
-	0x0b, 0x12, 0x2b, 0x73, 0xf2, 0x13, 0x21, 0x4e, 0xe0, 0x09, 0x82, 0x71, 0x95, 0x6c, 0x50, 0x54,
-	0x62, 0x35, 0x0d, 0x59, 0x37, 0x13, 0xaa, 0x6e, 0x07, 0x2e, 0x2e, 0x5f, 0x88, 0xe7, 0xa1, 0xb6,
-	0xc0, 0xdc, 0xcc, 0x88, 0xe2, 0x66, 0x3c, 0x26, 0x58, 0x21, 0x99, 0x40, 0xa2, 0xed, 0x4e, 0xc2,
-	0x51, 0x82, 0xe8, 0xf1, 0x91, 0x94, 0xc4, 0x06, 0x16, 0x32, 0x06, 0x04, 0x00, 0x00, 0xff, 0xff,
-	0x4a, 0x86, 0xc3, 0x73, 0xab, 0x01, 0x00, 0x00,
+	// 277 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x91, 0x3f, 0x4f, 0xf3, 0x30,
+	0x10, 0xc6, 0x95, 0xa4, 0x6f, 0xfb, 0x72, 0x0d, 0x12, 0x18, 0x06, 0xd3, 0xa9, 0xf2, 0x94, 0x29,
+	0x15, 0xb0, 0x40, 0xc5, 0x80, 0x90, 0x90, 0x8a, 0x80, 0x25, 0x23, 0xdb, 0x25, 0x39, 0xd1, 0xaa,
+	0xa1, 0x29, 0xb6, 0x13, 0x29, 0xdf, 0x91, 0x0f, 0x85, 0x62, 0x3b, 0x28, 0x41, 0x30, 0xb0, 0xf9,
+	0x77, 0xcf, 0xfd, 0x7d, 0x0c, 0x62, 0x2f, 0x4b, 0x5d, 0x2e, 0x24, 0x65, 0xb4, 0xa9, 0x49, 0x2e,
+	0x2c, 0xbe, 0x91, 0x52, 0xf8, 0x4a, 0xb1, 0x21, 0xf1, 0xe1, 0x41, 0xb8, 0xc2, 0x5d, 0xae, 0xd6,
+	0xb8, 0xa5, 0x84, 0xde, 0xd9, 0x0c, 0xfe, 0xcb, 0xb2, 0xd2, 0x24, 0x1f, 0x72, 0xee, 0xcd, 0xbd,
+	0xe8, 0x20, 0xf9, 0x62, 0xc6, 0x61, 0x82, 0x95, 0x5e, 0x3f, 0x52, 0xc3, 0x7d, 0x23, 0x75, 0xd8,
+	0x2a, 0x8a, 0x64, 0xbd, 0xc9, 0x88, 0x07, 0x56, 0x71, 0xc8, 0xce, 0x61, 0x5c, 0x60, 0x4a, 0x85,
+	0xe2, 0xa3, 0x79, 0x10, 0x4d, 0x2f, 0xce, 0xe2, 0xfe, 0xb8, 0xf8, 0xc9, 0x68, 0xf7, 0x3b, 0x2d,
+	0x9b, 0xc4, 0x25, 0xce, 0xae, 0x61, 0xda, 0x0b, 0xb3, 0x23, 0x08, 0xb6, 0xd4, 0xb8, 0x65, 0xda,
+	0x27, 0x3b, 0x85, 0x7f, 0x35, 0x16, 0x15, 0xb9, 0x2d, 0x2c, 0x2c, 0xfd, 0x2b, 0x4f, 0x88, 0xc1,
+	0x35, 0x8a, 0x31, 0x18, 0x65, 0x65, 0x4e, 0xa6, 0xf8, 0x30, 0x31, 0x6f, 0x11, 0x41, 0xb8, 0x22,
+	0x94, 0x3a, 0x25, 0xd4, 0xed, 0xc5, 0x1c, 0x26, 0x7b, 0x6c, 0x8a, 0x12, 0xed, 0xc1, 0x61, 0xd2,
+	0xa1, 0xb8, 0x19, 0x64, 0xfe, 0xd8, 0xad, 0x5f, 0xed, 0x0f, 0xab, 0x6f, 0x01, 0x9e, 0xad, 0xd7,
+	0x6e, 0x4a, 0xe7, 0x90, 0x37, 0x74, 0xe8, 0xf7, 0x0e, 0xcb, 0x5e, 0x87, 0x3f, 0x4e, 0xbf, 0x3b,
+	0x79, 0x39, 0xfe, 0xfe, 0xfd, 0x69, 0x3a, 0x36, 0xa1, 0xcb, 0xcf, 0x01, 0x00, 0xa1, 0x66, 0xea,
+	0xa7, 0x1a, 0x02, 0x00, 0x00,
 }
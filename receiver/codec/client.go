@@ -11,6 +11,8 @@ type ClientCodec struct {
 
 func (cCodec *ClientCodec) Encode(msg any) ([]byte, error) {
 	switch req := msg.(type) {
+	case *JsonMessage:
+		return encodeJsonMessage(req)
 	case Message:
 		size := req.XXX_Size()
 		buf := make([]byte, size+1)
@@ -31,6 +33,9 @@ func (cCodec *ClientCodec) Decode(in []byte) (any, error) {
 	if inLen < 1 {
 		return nil, io.ErrShortBuffer
 	}
+	if IsJsonFrame(in) {
+		return decodeJsonMessage(in, newResMessage)
+	}
 	msgType := in[0]
 	msg, err := newResMessage(msgType)
 	if err != nil {
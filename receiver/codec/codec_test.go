@@ -1,6 +1,8 @@
 package codec
 
 import (
+	"bytes"
+	"github.com/gobwas/ws"
 	"github.com/meow-pad/chinchilla/proto/receiver/pb"
 	"github.com/stretchr/testify/require"
 	"math/rand"
@@ -38,6 +40,7 @@ func TestCodec_Req(t *testing.T) {
 			RouterId: "1234",
 			AuthKey:  "1234567",
 			Service:  "test",
+			Labels:   map[string]string{"version": "2.3"},
 		},
 	}
 	heartbeatReq := &HeartbeatReq{
@@ -107,3 +110,50 @@ func TestCodec_Res(t *testing.T) {
 		should.Equal(_getObjectValue(msg), _getObjectValue(dMsg))
 	}
 }
+
+func TestCodec_Json(t *testing.T) {
+	should := require.New(t)
+	messageReq := &MessageReq{
+		pb.MessageReq{
+			Service: "test001",
+			Payload: []byte{1, 2, 3, 4, 5},
+		},
+	}
+	cCodec := ClientCodec{}
+	sCodec := NewServerCodec(true)
+	data, err := cCodec.Encode(&JsonMessage{Message: messageReq})
+	should.Nil(err)
+	should.True(IsJsonFrame(data))
+	dData, err := sCodec.Decode(data)
+	should.Nil(err)
+	jsonMsg, ok := dData.(*JsonMessage)
+	should.True(ok)
+	should.Equal(_getObjectValue(messageReq), _getObjectValue(jsonMsg.Message))
+
+	heartbeatRes := &HeartbeatRes{}
+	data, err = EncodeJsonTextFrame(heartbeatRes)
+	should.Nil(err)
+	frame, err := ws.ReadFrame(bytes.NewReader(data))
+	should.Nil(err)
+	dData, err = cCodec.Decode(frame.Payload)
+	should.Nil(err)
+	should.Equal(_getObjectValue(heartbeatRes), _getObjectValue(dData.(*JsonMessage).Message))
+	// 服务端 json 回复只通过文本帧发送
+	_, err = sCodec.Encode(&JsonMessage{Message: heartbeatRes})
+	should.NotNil(err)
+
+	// 未开启时拒绝 json 文本帧
+	_, err = NewServerCodec(false).Decode([]byte(`{"type":3,"service":"test001"}`))
+	should.NotNil(err)
+}
+
+func TestCodec_JsonTextFrame(t *testing.T) {
+	should := require.New(t)
+	data, err := EncodeJsonTextFrame(&HeartbeatRes{})
+	should.Nil(err)
+	frame, err := ws.ReadFrame(bytes.NewReader(data))
+	should.Nil(err)
+	should.Equal(ws.OpText, frame.Header.OpCode)
+	should.True(frame.Header.Fin)
+	should.Equal(`{"type":2}`, string(frame.Payload))
+}
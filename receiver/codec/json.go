@@ -0,0 +1,124 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/gogo/protobuf/jsonpb"
+	"github.com/gogo/protobuf/proto"
+	"reflect"
+)
+
+const (
+	// json 文本帧首字节，二进制帧首字节为消息类型，不会与之冲突
+	jsonFrameLeading = '{'
+	jsonTypeField    = "type"
+)
+
+var (
+	jsonMarshaler   = &jsonpb.Marshaler{}
+	jsonUnmarshaler = &jsonpb.Unmarshaler{AllowUnknownFields: true}
+)
+
+// JsonMessage
+//
+//	@Description: 以 json 文本格式编码的消息（仅用于调试）
+type JsonMessage struct {
+	Message
+}
+
+// IsJsonFrame
+//
+//	@Description: 是否为 json 文本帧；websocket 服务器不向消息编解码器提供帧类型，只能按首字节区分，
+//	二进制帧首字节为消息类型，不会是 '{'
+//	@param in
+//	@return bool
+func IsJsonFrame(in []byte) bool {
+	return len(in) > 0 && in[0] == jsonFrameLeading
+}
+
+// innerMessage
+//
+//	@Description: 获取包装的 pb 消息，jsonpb 无法直接处理嵌入结构
+//	@param msg
+//	@return proto.Message
+func innerMessage(msg Message) proto.Message {
+	switch tMsg := msg.(type) {
+	case *HandshakeReq:
+		return &tMsg.HandshakeReq
+	case *HandshakeRes:
+		return &tMsg.HandshakeRes
+	case *HeartbeatReq:
+		return &tMsg.HeartbeatReq
+	case *HeartbeatRes:
+		return &tMsg.HeartbeatRes
+	case *MessageReq:
+		return &tMsg.MessageReq
+	case *MessageRes:
+		return &tMsg.MessageRes
+	default:
+		return msg
+	}
+}
+
+// EncodeJsonTextFrame
+//
+//	@Description: 将消息编码为 websocket 文本帧（含帧头），可直接写入连接；
+//	websocket 服务器发送的消息总是二进制帧，json 模式下需通过该方法发送文本帧
+//	@param msg
+//	@return []byte
+//	@return error
+func EncodeJsonTextFrame(msg Message) ([]byte, error) {
+	body, err := encodeJsonMessage(&JsonMessage{Message: msg})
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, len(body)+ws.MaxHeaderSize))
+	if err = wsutil.WriteServerMessage(buf, ws.OpText, body); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeJsonMessage(msg *JsonMessage) ([]byte, error) {
+	if msg.Message == nil {
+		return nil, errors.New("nil json message")
+	}
+	var buf bytes.Buffer
+	if err := jsonMarshaler.Marshal(&buf, innerMessage(msg.Message)); err != nil {
+		return nil, err
+	}
+	body := buf.Bytes()
+	if len(body) < 2 || body[0] != jsonFrameLeading {
+		return nil, errors.New("invalid json message:" + reflect.TypeOf(msg.Message).String())
+	}
+	// 在首部插入类型字段
+	out := make([]byte, 0, len(body)+16)
+	out = append(out, jsonFrameLeading)
+	out = append(out, fmt.Sprintf("%q:%d", jsonTypeField, msg.Type())...)
+	if len(bytes.TrimSpace(body[1:len(body)-1])) > 0 {
+		out = append(out, ',')
+	}
+	out = append(out, body[1:]...)
+	return out, nil
+}
+
+func decodeJsonMessage(in []byte, newMessage func(uint8) (Message, error)) (*JsonMessage, error) {
+	head := struct {
+		Type uint8 `json:"type"`
+	}{}
+	if err := json.Unmarshal(in, &head); err != nil {
+		return nil, err
+	}
+	msg, err := newMessage(head.Type)
+	if err != nil {
+		return nil, err
+	}
+	if err = jsonUnmarshaler.Unmarshal(bytes.NewReader(in), innerMessage(msg)); err != nil {
+		return nil, err
+	}
+	return &JsonMessage{Message: msg}, nil
+}
@@ -6,11 +6,31 @@ import (
 	"reflect"
 )
 
+// NewServerCodec
+//
+//	@Description: 构建服务端编解码器
+//	@param jsonEnabled 是否接收 json 文本协议，仅供调试，生产环境必须保持关闭
+//	@return *ServerCodec
+func NewServerCodec(jsonEnabled bool) *ServerCodec {
+	return &ServerCodec{jsonEnabled: jsonEnabled}
+}
+
 type ServerCodec struct {
+	// 是否支持 json 文本协议（调试用）
+	jsonEnabled bool
 }
 
+// Encode
+//
+//	@Description: 编码为二进制帧的消息体；json 回复只能通过 EncodeJsonTextFrame 以文本帧发送
+//	@receiver sCodec
+//	@param msg
+//	@return []byte
+//	@return error
 func (sCodec *ServerCodec) Encode(msg any) ([]byte, error) {
 	switch req := msg.(type) {
+	case *JsonMessage:
+		return nil, errors.New("(receiver server) json message must be sent as text frame")
 	case Message:
 		size := req.XXX_Size()
 		buf := make([]byte, size+1)
@@ -31,6 +51,12 @@ func (sCodec *ServerCodec) Decode(in []byte) (any, error) {
 	if inLen < 1 {
 		return nil, io.ErrShortBuffer
 	}
+	if IsJsonFrame(in) {
+		if !sCodec.jsonEnabled {
+			return nil, errors.New("(receiver server) json mode is disabled")
+		}
+		return decodeJsonMessage(in, newReqMessage)
+	}
 	msgType := in[0]
 	msg, err := newReqMessage(msgType)
 	if err != nil {
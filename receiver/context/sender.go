@@ -2,6 +2,12 @@ package context
 
 import (
 	"github.com/meow-pad/chinchilla/transfer/service"
+	"github.com/meow-pad/persian/frame/pnet/tcp/session"
+)
+
+const (
+	// AttrRouterId 注册成功时由 RegisterSRes.RouterId 设置
+	AttrRouterId = "routerId"
 )
 
 type SenderContext interface {
@@ -14,4 +20,50 @@ type SenderContext interface {
 	GetService(srvName string) service.Service
 
 	GetDefaultService() (string, service.Service)
+
+	// SendMessage
+	//  @Description: 按会话协议模式下发消息
+	//  @param msg
+	//
+	SendMessage(msg any)
+
+	// SetAttribute
+	//  @Description: 设置会话属性
+	//  @param key
+	//  @param value
+	//
+	SetAttribute(key, value string)
+
+	// DeleteAttribute
+	//  @Description: 清除会话属性
+	//  @param key
+	//
+	DeleteAttribute(key string)
+
+	// GetAttribute
+	//  @Description: 获取会话属性
+	//  @param key
+	//  @return string
+	//  @return bool
+	//
+	GetAttribute(key string) (string, bool)
+
+	// Attributes
+	//  @Description: 会话属性快照
+	//  @return map[string]string
+	//
+	Attributes() map[string]string
+}
+
+// SendMessage
+//
+//	@Description: 向客户端会话下发消息，有发送者上下文时由其决定编码模式
+//	@param sess
+//	@param msg
+func SendMessage(sess session.Session, msg any) {
+	if senderCtx, ok := sess.Context().(SenderContext); ok && senderCtx != nil {
+		senderCtx.SendMessage(msg)
+		return
+	}
+	sess.SendMessage(msg)
 }
@@ -39,6 +39,14 @@ func (listener *Listener) OnClosed(sess session.Session) {
 	listener.server.Transfer.Forward(int64(sess.Id()), func(local *worker.GoroutineLocal) {
 		// 移除缓存
 		local.Remove(sess.Id())
+		// 移除分组
+		listener.server.Transfer.GetGroupManager().LeaveAll(sess.Id())
+		// 移除路由索引
+		listener.server.Transfer.GetSessionIndex().Unbind(sess.Id())
+		// 解除服务实例绑定
+		if sessCtx, _ := sess.Context().(*SenderContext); sessCtx != nil {
+			sessCtx.ReleaseServices()
+		}
 	})
 }
 
@@ -72,6 +80,16 @@ func (listener *Listener) OnSendMulti(sess session.Session, msg []any, totalLen
 }
 
 func (listener *Listener) handleMessage(sess session.Session, msg any) {
+	jsonMsg, isJson := msg.(*codec.JsonMessage)
+	if sessCtx := coding.Cast[*SenderContext](sess.Context()); sessCtx != nil && !sessCtx.CheckFrameMode(isJson) {
+		// 帧模式由首个消息确定，不允许中途切换
+		plog.Warn("(receiver) frame mode mismatch",
+			pfield.Uint64("sessionId", sess.Id()), pfield.Bool("json", isJson))
+		return
+	}
+	if isJson {
+		msg = jsonMsg.Message
+	}
 	switch req := msg.(type) {
 	case *codec.MessageReq:
 		listener.handleMessageReq(sess, req)
@@ -99,7 +117,7 @@ func (listener *Listener) handleMessageReq(sess session.Session, req *codec.Mess
 		if srvService == nil {
 			res := &codec.MessageRes{}
 			res.Code = codec.ErrCodeHandshakeFirst
-			sess.SendMessage(res)
+			sessCtx.SendMessage(res)
 			return
 		}
 		if srvService.IsStopped() {
@@ -147,7 +165,7 @@ func (listener *Listener) handleHeartbeatReq(sess session.Session, req *codec.He
 			if dfService == nil {
 				res := &codec.HeartbeatRes{}
 				res.Code = codec.ErrCodeHandshakeFirst
-				sess.SendMessage(res)
+				sessCtx.SendMessage(res)
 				return
 			}
 			if dfService.IsStopped() {
@@ -174,7 +192,7 @@ func (listener *Listener) handleHeartbeatReq(sess session.Session, req *codec.He
 		} else {
 			res := &codec.HeartbeatRes{}
 			res.Code = codec.ErrCodeLoginFirst
-			sess.SendMessage(res)
+			sessCtx.SendMessage(res)
 			return
 		}
 	})
@@ -190,13 +208,6 @@ func (listener *Listener) handleHandshakeReq(sess session.Session, req *codec.Ha
 		//	sess.SendMessage(res)
 		//	return
 		//}
-		// 校验
-		if req.AuthKey != options.ReceiverHandshakeAuthKey {
-			res := &codec.HandshakeRes{}
-			res.Code = codec.ErrCodeInvalidAuthKey
-			sess.SendMessage(res)
-			return
-		}
 		sessCtx := coding.Cast[*SenderContext](sess.Context())
 		if sessCtx == nil {
 			if cErr := sess.Close(); cErr != nil {
@@ -204,42 +215,49 @@ func (listener *Listener) handleHandshakeReq(sess session.Session, req *codec.Ha
 			}
 			return
 		}
+		// 校验
+		if req.AuthKey != options.ReceiverHandshakeAuthKey {
+			res := &codec.HandshakeRes{}
+			res.Code = codec.ErrCodeInvalidAuthKey
+			sessCtx.SendMessage(res)
+			return
+		}
 		// 该服务是否已关注成功
 		srvCli := sessCtx.GetService(req.Service)
 		if srvCli != nil {
 			// 又重握手了一遍
 			res := &codec.HandshakeRes{}
 			res.Code = codec.ErrCodeSuccess
-			sess.SendMessage(res)
+			sessCtx.SendMessage(res)
 			return
 		}
-		// 设定需要先到 本地缓存或分布式缓存查询, 有则直接在transfer中找到对应的instanceId的client
-		// 缓存中没有则到transfer去select一个client
+		// 到transfer去select一个client，需按缓存粘性选择时配置 selector.StickySelector，
+		// 握手携带的标签作为选择提示（见 selector.LabelSelector）
 		manager := listener.server.Transfer.GetServiceManager(req.Service)
 		if manager == nil {
 			res := &codec.HandshakeRes{}
 			res.Code = codec.ErrCodeUnknownService
-			sess.SendMessage(res)
+			sessCtx.SendMessage(res)
 			return
 		} else {
 			sErr := listener.server.Transfer.GoPool.Submit(func() {
-				srv, sErr := manager.SelectInstance(req.RouterId())
+				srv, sErr := manager.SelectInstanceWithHints(req.RouterId(), req.GetLabels())
 				if sErr != nil {
 					res := &codec.HandshakeRes{}
 					res.Code = codec.ErrCodeSelectError
-					sess.SendMessage(res)
+					sessCtx.SendMessage(res)
 					return
 				}
 				if srv == nil {
 					res := &codec.HandshakeRes{}
 					res.Code = codec.ErrCodeLessInstance
-					sess.SendMessage(res)
+					sessCtx.SendMessage(res)
 					return
 				}
 				sessCtx.SetService(req.Service, srv)
 				res := &codec.HandshakeRes{}
 				res.Code = codec.ErrCodeSuccess
-				sess.SendMessage(res)
+				sessCtx.SendMessage(res)
 			})
 			if sErr != nil {
 				plog.Error("(receiver) submit SelectInstance task in HandshakeReq error:", pfield.Error(sErr))
@@ -41,7 +41,7 @@ func (srv *Receiver) init(name string) error {
 	switch proto {
 	case utils.ProtoTCP:
 		wsServer, sErr := ws.NewServer(name, srv.Options.ReceiverServerProtoAddr,
-			&codec.ServerCodec{}, NewListener(srv), srv.Options.ReceiverServerOptions...)
+			codec.NewServerCodec(srv.Options.ReceiverJsonDebugMode), NewListener(srv), srv.Options.ReceiverServerOptions...)
 		if sErr != nil {
 			return sErr
 		}
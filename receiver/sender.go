@@ -1,16 +1,26 @@
 package receiver
 
 import (
+	"github.com/meow-pad/chinchilla/receiver/codec"
 	"github.com/meow-pad/chinchilla/transfer/service"
+	"github.com/meow-pad/persian/frame/plog"
+	"github.com/meow-pad/persian/frame/plog/pfield"
 	"github.com/meow-pad/persian/frame/pnet/tcp/session"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
+const (
+	frameModeUndecided = iota
+	frameModeBinary
+	frameModeJson
+)
+
 func newSessionContext(server *Receiver, session session.Session) *SenderContext {
 	ctx := &SenderContext{
 		server:     server,
+		binder:     server.Transfer,
 		session:    session,
 		id:         session.Connection().Hash(),
 		registered: false,
@@ -19,17 +29,32 @@ func newSessionContext(server *Receiver, session session.Session) *SenderContext
 	return ctx
 }
 
+// serviceBinder
+//
+//	@Description: 关注会话绑定服务实例变化（即 transfer.Transfer）
+type serviceBinder interface {
+	OnServiceBind(srvName string, oldSrv, newSrv service.Service)
+}
+
 type SenderContext struct {
 	server     *Receiver
+	binder     serviceBinder
 	session    session.Session
 	id         uint64
 	deadline   atomic.Int64
 	registered bool
+	// 帧模式，由会话的首个消息决定，之后不再改变
+	frameMode atomic.Int32
 	// 默认服务
 	dfSrvName string
 	dfService service.Service
 	services  map[string]service.Service
 	srvMu     sync.RWMutex
+	// 会话关闭后已解除所有实例绑定
+	srvReleased bool
+	// 后端服务设置的会话属性
+	attrs  map[string]string
+	attrMu sync.RWMutex
 }
 
 func (ctx *SenderContext) Id() uint64 {
@@ -60,10 +85,77 @@ func (ctx *SenderContext) IsRegistered() bool {
 	return ctx.registered
 }
 
+// CheckFrameMode
+//
+//	@Description: 以会话的首个消息确定帧模式，之后的消息需与之一致
+//	@receiver ctx
+//	@param json 是否为 json 文本帧
+//	@return bool 是否与会话的帧模式一致
+func (ctx *SenderContext) CheckFrameMode(json bool) bool {
+	mode := int32(frameModeBinary)
+	if json {
+		mode = frameModeJson
+	}
+	if ctx.frameMode.CompareAndSwap(frameModeUndecided, mode) {
+		return true
+	}
+	return ctx.frameMode.Load() == mode
+}
+
+// IsJsonMode
+//
+//	@Description: 是否为 json 文本协议（调试用）
+//	@receiver ctx
+//	@return bool
+func (ctx *SenderContext) IsJsonMode() bool {
+	return ctx.frameMode.Load() == frameModeJson
+}
+
+func (ctx *SenderContext) SendMessage(msg any) {
+	if ctx.IsJsonMode() {
+		if cMsg, ok := msg.(codec.Message); ok {
+			ctx.sendJsonFrame(cMsg)
+			return
+		}
+	}
+	ctx.session.SendMessage(msg)
+}
+
+// sendJsonFrame
+//
+//	@Description: 以 websocket 文本帧发送，发送失败时关闭会话
+//	@receiver ctx
+//	@param msg
+func (ctx *SenderContext) sendJsonFrame(msg codec.Message) {
+	if ctx.session.IsClosed() {
+		return
+	}
+	data, err := codec.EncodeJsonTextFrame(msg)
+	if err != nil {
+		ctx.onSendingError("encode json message error:", err)
+		return
+	}
+	if err = ctx.session.Connection().AsyncWrite(data, func(_ session.Conn, wErr error) error {
+		if wErr != nil {
+			ctx.onSendingError("write json message error:", wErr)
+		}
+		return nil
+	}); err != nil {
+		ctx.onSendingError("async write json message error:", err)
+	}
+}
+
+func (ctx *SenderContext) onSendingError(tip string, err error) {
+	plog.Error(tip, pfield.Error(err))
+	if cErr := ctx.session.Close(); cErr != nil {
+		plog.Error("close session error:", pfield.Error(cErr))
+	}
+}
+
 func (ctx *SenderContext) SetService(srvName string, srv service.Service) {
 	ctx.srvMu.Lock()
-	defer ctx.srvMu.Unlock()
-	if ctx.dfService == nil {
+	oldSrv := ctx.getService(srvName)
+	if ctx.dfService == nil || ctx.dfSrvName == srvName {
 		ctx.dfSrvName = srvName
 		ctx.dfService = srv
 	} else {
@@ -72,11 +164,46 @@ func (ctx *SenderContext) SetService(srvName string, srv service.Service) {
 		}
 		ctx.services[srvName] = srv
 	}
+	released := ctx.srvReleased
+	ctx.srvMu.Unlock()
+	if !released && oldSrv != srv {
+		ctx.binder.OnServiceBind(srvName, oldSrv, srv)
+	}
+}
+
+// ReleaseServices
+//
+//	@Description: 会话关闭时解除与所有服务实例的绑定
+//	@receiver ctx
+func (ctx *SenderContext) ReleaseServices() {
+	ctx.srvMu.Lock()
+	if ctx.srvReleased {
+		ctx.srvMu.Unlock()
+		return
+	}
+	ctx.srvReleased = true
+	bound := make(map[string]service.Service, len(ctx.services)+1)
+	for srvName, srv := range ctx.services {
+		bound[srvName] = srv
+	}
+	if ctx.dfService != nil {
+		bound[ctx.dfSrvName] = ctx.dfService
+	}
+	ctx.srvMu.Unlock()
+	for srvName, srv := range bound {
+		if srv != nil {
+			ctx.binder.OnServiceBind(srvName, srv, nil)
+		}
+	}
 }
 
 func (ctx *SenderContext) GetService(srvName string) service.Service {
 	ctx.srvMu.RLock()
 	defer ctx.srvMu.RUnlock()
+	return ctx.getService(srvName)
+}
+
+func (ctx *SenderContext) getService(srvName string) service.Service {
 	if ctx.dfSrvName == srvName {
 		return ctx.dfService
 	}
@@ -91,3 +218,35 @@ func (ctx *SenderContext) GetDefaultService() (string, service.Service) {
 	defer ctx.srvMu.RUnlock()
 	return ctx.dfSrvName, ctx.dfService
 }
+
+func (ctx *SenderContext) SetAttribute(key, value string) {
+	ctx.attrMu.Lock()
+	defer ctx.attrMu.Unlock()
+	if ctx.attrs == nil {
+		ctx.attrs = make(map[string]string, 4)
+	}
+	ctx.attrs[key] = value
+}
+
+func (ctx *SenderContext) DeleteAttribute(key string) {
+	ctx.attrMu.Lock()
+	defer ctx.attrMu.Unlock()
+	delete(ctx.attrs, key)
+}
+
+func (ctx *SenderContext) GetAttribute(key string) (string, bool) {
+	ctx.attrMu.RLock()
+	defer ctx.attrMu.RUnlock()
+	value, ok := ctx.attrs[key]
+	return value, ok
+}
+
+func (ctx *SenderContext) Attributes() map[string]string {
+	ctx.attrMu.RLock()
+	defer ctx.attrMu.RUnlock()
+	attrs := make(map[string]string, len(ctx.attrs))
+	for key, value := range ctx.attrs {
+		attrs[key] = value
+	}
+	return attrs
+}
@@ -0,0 +1,81 @@
+package receiver
+
+import (
+	"github.com/meow-pad/chinchilla/transfer/common"
+	"github.com/meow-pad/chinchilla/transfer/selector"
+	"github.com/meow-pad/chinchilla/transfer/service"
+	"github.com/nacos-group/nacos-sdk-go/v2/model"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// testService
+//
+//	@Description: 仅提供实例信息的服务
+type testService struct {
+	service.Service
+
+	info common.Info
+}
+
+func newTestService(instId string) *testService {
+	return &testService{info: common.Info(model.Instance{
+		Weight:   1,
+		Enable:   true,
+		Healthy:  true,
+		Metadata: map[string]string{common.MetadataKeyId: instId},
+	})}
+}
+
+func (srv *testService) Info() common.Info {
+	return srv.info
+}
+
+// testBinder
+//
+//	@Description: 与 transfer.Transfer 相同，将绑定变化通知给最少连接选择器
+type testBinder struct {
+	selector *selector.LeastConnSelector
+	calls    int
+}
+
+func (binder *testBinder) OnServiceBind(_ string, oldSrv, newSrv service.Service) {
+	binder.calls++
+	if oldSrv != nil {
+		binder.selector.OnUnbind(oldSrv.Info().ServiceId())
+	}
+	if newSrv != nil {
+		binder.selector.OnBind(newSrv.Info().ServiceId())
+	}
+}
+
+func TestSenderContext_ServiceBind(t *testing.T) {
+	should := require.New(t)
+	binder := &testBinder{selector: selector.NewLeastConnSelector().(*selector.LeastConnSelector)}
+	ctx := &SenderContext{binder: binder}
+	srv1, srv2, srv3 := newTestService("inst1"), newTestService("inst2"), newTestService("inst3")
+	ctx.SetService("srv", srv1)
+	should.Equal(int64(1), binder.selector.Count("inst1"))
+	// 重复设置同一实例不重复计数
+	ctx.SetService("srv", srv1)
+	should.Equal(1, binder.calls)
+	should.Equal(int64(1), binder.selector.Count("inst1"))
+	// 换绑
+	ctx.SetService("srv", srv2)
+	should.Zero(binder.selector.Count("inst1"))
+	should.Equal(int64(1), binder.selector.Count("inst2"))
+	ctx.SetService("other", srv3)
+	should.Equal(int64(1), binder.selector.Count("inst3"))
+	// 关闭时解除所有绑定，且只解除一次
+	ctx.ReleaseServices()
+	calls := binder.calls
+	ctx.ReleaseServices()
+	should.Equal(calls, binder.calls)
+	for _, instId := range []string{"inst1", "inst2", "inst3"} {
+		should.Zero(binder.selector.Count(instId))
+	}
+	// 关闭后不再绑定
+	ctx.SetService("srv", srv1)
+	should.Equal(calls, binder.calls)
+	should.Zero(binder.selector.Count("inst1"))
+}
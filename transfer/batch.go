@@ -0,0 +1,127 @@
+package transfer
+
+import (
+	"github.com/meow-pad/chinchilla/transfer/codec"
+	"github.com/meow-pad/persian/frame/plog"
+	"github.com/meow-pad/persian/frame/plog/pfield"
+	"sync"
+	"time"
+)
+
+func newRemoteBatcher(remote *Remote, window time.Duration, maxSize int) *remoteBatcher {
+	return &remoteBatcher{
+		remote:  remote,
+		window:  window,
+		maxSize: maxSize,
+	}
+}
+
+// remoteBatcher
+//
+//	@Description: 在发送窗口内将多个连接的 MessageSReq 合并为一帧
+type remoteBatcher struct {
+	remote  *Remote
+	window  time.Duration
+	maxSize int
+
+	mu      sync.Mutex
+	entries []codec.BatchEntry
+	size    int
+	timer   *time.Timer
+}
+
+// enabled
+//
+//	@Description: 是否开启批量发送（需对端支持）
+//	@receiver batcher
+//	@return bool
+func (batcher *remoteBatcher) enabled() bool {
+	return batcher.window > 0 && batcher.remote.HasCapability(codec.CapBatch)
+}
+
+// send
+//
+//	@Description: 发送消息，MessageSReq 进入批量缓存，其他消息发送前先发出已缓存的消息以保证顺序
+//	@receiver batcher
+//	@param msg
+//	@return error
+func (batcher *remoteBatcher) send(msg any) error {
+	batcher.mu.Lock()
+	defer batcher.mu.Unlock()
+	req, ok := msg.(*codec.MessageSReq)
+	if !ok {
+		if err := batcher.flushLocked(); err != nil {
+			plog.Error("(transfer client) flush batch error:", pfield.Error(err))
+		}
+		return batcher.remote.writeMessage(msg)
+	}
+	batcher.entries = append(batcher.entries, codec.BatchEntry{ConnId: req.ConnId, Payload: req.Payload})
+	batcher.size += codec.BatchEntrySize(req.Payload)
+	if batcher.size >= batcher.maxSize || len(batcher.entries) >= codec.MaxBatchEntryNum {
+		return batcher.flushLocked()
+	}
+	if batcher.timer == nil {
+		batcher.timer = time.AfterFunc(batcher.window, batcher.onTimer)
+	}
+	return nil
+}
+
+// flush
+//
+//	@Description: 发出已缓存的消息
+//	@receiver batcher
+//	@return error
+func (batcher *remoteBatcher) flush() error {
+	batcher.mu.Lock()
+	defer batcher.mu.Unlock()
+	return batcher.flushLocked()
+}
+
+func (batcher *remoteBatcher) onTimer() {
+	if err := batcher.flush(); err != nil {
+		plog.Error("(transfer client) flush batch error:", pfield.Error(err))
+	}
+}
+
+func (batcher *remoteBatcher) flushLocked() error {
+	if batcher.timer != nil {
+		batcher.timer.Stop()
+		batcher.timer = nil
+	}
+	entries := batcher.entries
+	if len(entries) <= 0 {
+		return nil
+	}
+	var msg any
+	if len(entries) == 1 {
+		msg = &codec.MessageSReq{ConnId: entries[0].ConnId, Payload: entries[0].Payload}
+	} else {
+		msg = &codec.BatchSReq{Entries: entries}
+	}
+	// 消息在写出完成前仍会被引用（如调试日志），交出缓存后另行分配
+	batcher.entries = make([]codec.BatchEntry, 0, cap(entries))
+	batcher.size = 0
+	return batcher.remote.writeMessage(msg)
+}
+
+// reset
+//
+//	@Description: 丢弃已缓存的消息（如连接重建时）
+//	@receiver batcher
+func (batcher *remoteBatcher) reset() {
+	batcher.mu.Lock()
+	defer batcher.mu.Unlock()
+	if batcher.timer != nil {
+		batcher.timer.Stop()
+		batcher.timer = nil
+	}
+	batcher.resetLocked()
+}
+
+func (batcher *remoteBatcher) resetLocked() {
+	for i := range batcher.entries {
+		batcher.entries[i] = codec.BatchEntry{}
+	}
+	batcher.entries = batcher.entries[:0]
+	batcher.size = 0
+}
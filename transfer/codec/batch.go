@@ -0,0 +1,92 @@
+package codec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/meow-pad/chinchilla/utils/codec"
+	"math"
+)
+
+const (
+	// 批量帧中单条消息头 | connId(8) | len(4) |
+	batchEntryHeadSize = 8 + 4
+	// MaxBatchEntryNum 批量帧最大消息数
+	MaxBatchEntryNum = math.MaxUint16
+)
+
+// BatchEntrySize
+//
+//	@Description: 单条消息在批量帧中的长度
+//	@param payload
+//	@return int
+func BatchEntrySize(payload []byte) int {
+	return batchEntryHeadSize + len(payload)
+}
+
+// encodeBatch
+//
+//	@Description: | type(1) | num(2) | [connId(8) | len(4) | payload]... |
+//	@param byteOrder
+//	@param alloc
+//	@param msgType
+//	@param entries
+//	@return []byte
+//	@return error
+func encodeBatch(byteOrder binary.ByteOrder, alloc Allocator, msgType uint8, entries []BatchEntry) ([]byte, error) {
+	if len(entries) > MaxBatchEntryNum {
+		return nil, fmt.Errorf("too many batch entries:%d", len(entries))
+	}
+	size := 1 + 2
+	for _, entry := range entries {
+		size += BatchEntrySize(entry.Payload)
+	}
+	buf := alloc(size)
+	buf[0] = msgType
+	left := buf[1:]
+	err := error(nil)
+	if left, err = codec.WriteUint16(byteOrder, uint16(len(entries)), left); err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if left, err = codec.WriteUint64(byteOrder, entry.ConnId, left); err != nil {
+			return nil, err
+		}
+		if left, err = codec.WriteUint32(byteOrder, uint32(len(entry.Payload)), left); err != nil {
+			return nil, err
+		}
+		left = left[copy(left, entry.Payload):]
+	}
+	return buf, nil
+}
+
+// decodeBatch
+//
+//	@Description: 解码批量帧，所有负载共用一次拷贝
+//	@param byteOrder
+//	@param clone
+//	@param in 不含消息类型
+//	@return []BatchEntry
+//	@return error
+func decodeBatch(byteOrder binary.ByteOrder, clone func([]byte) []byte, in []byte) ([]BatchEntry, error) {
+	num, left, err := codec.ReadUint16(byteOrder, in)
+	if err != nil {
+		return nil, err
+	}
+	left = clone(left)
+	entries := make([]BatchEntry, num)
+	for i := range entries {
+		if entries[i].ConnId, left, err = codec.ReadUint64(byteOrder, left); err != nil {
+			return nil, err
+		}
+		var payloadLen uint32
+		if payloadLen, left, err = codec.ReadUint32(byteOrder, left); err != nil {
+			return nil, err
+		}
+		if uint32(len(left)) < payloadLen {
+			return nil, fmt.Errorf("invalid batch entry length:%d, left:%d", payloadLen, len(left))
+		}
+		entries[i].Payload = left[:payloadLen:payloadLen]
+		left = left[payloadLen:]
+	}
+	return entries, nil
+}
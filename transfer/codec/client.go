@@ -21,9 +21,21 @@ type ClientCodec struct {
 }
 
 func (cCodec *ClientCodec) Encode(msg any) ([]byte, error) {
+	return cCodec.EncodeWith(msg, makeBytes)
+}
+
+// EncodeWith
+//
+//	@Description: 使用指定分配器申请编码缓存（如带预留头部的复用缓存）
+//	@receiver cCodec
+//	@param msg
+//	@param alloc
+//	@return []byte
+//	@return error
+func (cCodec *ClientCodec) EncodeWith(msg any, alloc Allocator) ([]byte, error) {
 	switch cMsg := msg.(type) {
 	case *MessageSReq:
-		buf := make([]byte, len(cMsg.Payload)+8+1)
+		buf := alloc(len(cMsg.Payload) + 8 + 1)
 		buf[0] = TypeMessageS
 		cCodec.byteOrder.PutUint64(buf[1:], cMsg.ConnId)
 		copy(buf[9:], cMsg.Payload)
@@ -31,25 +43,40 @@ func (cCodec *ClientCodec) Encode(msg any) ([]byte, error) {
 	case []byte: // 直接转发的消息数据
 		return cMsg, nil
 	case *RegisterSReq:
-		buf := make([]byte, len(cMsg.Payload)+8+1)
+		buf := alloc(len(cMsg.Payload) + 8 + 1)
 		buf[0] = TypeRegisterS
 		cCodec.byteOrder.PutUint64(buf[1:], cMsg.ConnId)
 		copy(buf[9:], cMsg.Payload)
 		return buf, nil
 	case *UnregisterSReq:
-		buf := make([]byte, 8+1)
+		buf := alloc(8 + 1)
 		buf[0] = TypeUnregisterS
 		cCodec.byteOrder.PutUint64(buf[1:], cMsg.ConnId)
 		return buf, nil
 	case *HeartbeatSReq:
-		buf := make([]byte, len(cMsg.Payload)+8+1)
+		buf := alloc(len(cMsg.Payload) + 8 + 1)
 		buf[0] = TypeHeartbeatS
 		cCodec.byteOrder.PutUint64(buf[1:], cMsg.ConnId)
 		copy(buf[9:], cMsg.Payload)
 		return buf, nil
+	case *RebindSReq:
+		buf := alloc(8 + 2 + len(cMsg.TargetServiceId) + 2 + 1)
+		buf[0] = TypeRebindS
+		left := buf[1:]
+		err := error(nil)
+		if left, err = codec.WriteUint64(cCodec.byteOrder, cMsg.ConnId, left); err != nil {
+			return nil, err
+		}
+		if left, err = codec.WriteString(cCodec.byteOrder, cMsg.TargetServiceId, left); err != nil {
+			return nil, err
+		}
+		if left, err = codec.WriteUint16(cCodec.byteOrder, cMsg.Code, left); err != nil {
+			return nil, err
+		}
+		return buf, nil
 	case *HandshakeReq:
-		buf := make([]byte, 1+8+len(cMsg.Id)+len(cMsg.AuthKey)+len(cMsg.Service)+len(cMsg.ServiceId)+
-			codec.Uint64ArrayLen(cMsg.ConnIds)+codec.StringArrayLen(cMsg.RouterIds))
+		buf := alloc(1 + 8 + len(cMsg.Id) + len(cMsg.AuthKey) + len(cMsg.Service) + len(cMsg.ServiceId) +
+			codec.Uint64ArrayLen(cMsg.ConnIds) + codec.StringArrayLen(cMsg.RouterIds) + 4 + 2 + 8)
 		buf[0] = TypeHandshake
 		left := buf[1:]
 		err := error(nil)
@@ -77,9 +104,21 @@ func (cCodec *ClientCodec) Encode(msg any) ([]byte, error) {
 		if err != nil {
 			return nil, err
 		}
+		left, err = codec.WriteUint32(cCodec.byteOrder, cMsg.MaxFrameSize, left)
+		if err != nil {
+			return nil, err
+		}
+		left, err = codec.WriteUint16(cCodec.byteOrder, cMsg.Version, left)
+		if err != nil {
+			return nil, err
+		}
+		left, err = codec.WriteUint64(cCodec.byteOrder, cMsg.Capabilities, left)
+		if err != nil {
+			return nil, err
+		}
 		return buf, nil
 	case *ServiceInstIRes:
-		buf := make([]byte, 2+2+len(cMsg.ServiceName)+codec.StringArrayLen(cMsg.ServiceInstArr)+1)
+		buf := alloc(2 + 2 + len(cMsg.ServiceName) + codec.StringArrayLen(cMsg.ServiceInstArr) + 1)
 		buf[0] = TypeServiceInstIReS
 		left := buf[1:]
 		err := error(nil)
@@ -96,9 +135,58 @@ func (cCodec *ClientCodec) Encode(msg any) ([]byte, error) {
 			return nil, err
 		}
 		return buf, nil
+	case *BatchSReq:
+		return encodeBatch(cCodec.byteOrder, alloc, TypeBatchS, cMsg.Entries)
 	case *SegmentMsg:
-		return encodeSegmentMsg(cCodec.byteOrder, cMsg)
-	case *MessageRouter, *RpcRReq, *RpcRRes:
+		return encodeSegmentMsg(cCodec.byteOrder, alloc, cMsg, true)
+	case *MessageRouterRes:
+		buf := alloc(1 + 2 + 4 + 2 + len(cMsg.RouterService) + 2 + 2 + len(cMsg.RouterId))
+		buf[0] = TypeMessageRouterRes
+		left := buf[1:]
+		err := error(nil)
+		if left, err = codec.WriteUint16(cCodec.byteOrder, cMsg.Code, left); err != nil {
+			return nil, err
+		}
+		if left, err = codec.WriteUint32(cCodec.byteOrder, cMsg.CorrelationId, left); err != nil {
+			return nil, err
+		}
+		if left, err = codec.WriteString(cCodec.byteOrder, cMsg.RouterService, left); err != nil {
+			return nil, err
+		}
+		if left, err = codec.WriteInt16(cCodec.byteOrder, cMsg.RouterType, left); err != nil {
+			return nil, err
+		}
+		if _, err = codec.WriteString(cCodec.byteOrder, cMsg.RouterId, left); err != nil {
+			return nil, err
+		}
+		return buf, nil
+	case *MeshRelayReq:
+		buf := alloc(1 + 2 + len(cMsg.Service) + 2 + len(cMsg.ServiceId) + len(cMsg.Payload))
+		buf[0] = TypeMeshRelay
+		left := buf[1:]
+		err := error(nil)
+		if left, err = codec.WriteString(cCodec.byteOrder, cMsg.Service, left); err != nil {
+			return nil, err
+		}
+		if left, err = codec.WriteString(cCodec.byteOrder, cMsg.ServiceId, left); err != nil {
+			return nil, err
+		}
+		copy(left, cMsg.Payload)
+		return buf, nil
+	case *MeshLookupReq:
+		buf := alloc(1 + 4 + 2 + len(cMsg.RouterId))
+		buf[0] = TypeMeshLookup
+		left := buf[1:]
+		err := error(nil)
+		if left, err = codec.WriteUint32(cCodec.byteOrder, cMsg.Seq, left); err != nil {
+			return nil, err
+		}
+		if _, err = codec.WriteString(cCodec.byteOrder, cMsg.RouterId, left); err != nil {
+			return nil, err
+		}
+		return buf, nil
+	case *MessageRouter, *RpcRReq, *RpcRRes, *JoinGroupSRes, *LeaveGroupSRes, *GroupBroadcastSRes,
+		*BroadcastAllSRes, *SessionAttrSRes, *BatchSRes, *MeshLookupRes:
 		// 这些消息不可能在client端编码
 		return nil, errors.New("unsupported message in client encoder:" + reflect.TypeOf(msg).String())
 	default:
@@ -107,6 +195,21 @@ func (cCodec *ClientCodec) Encode(msg any) ([]byte, error) {
 }
 
 func (cCodec *ClientCodec) Decode(in []byte) (any, error) {
+	return cCodec.decode(in, bytes.Clone)
+}
+
+// DecodeBorrowed
+//
+//	@Description: 解码但不拷贝负载，负载引用 in，仅在 in 的生命周期内有效
+//	@receiver cCodec
+//	@param in
+//	@return any
+//	@return error
+func (cCodec *ClientCodec) DecodeBorrowed(in []byte) (any, error) {
+	return cCodec.decode(in, borrowBytes)
+}
+
+func (cCodec *ClientCodec) decode(in []byte, clone func([]byte) []byte) (any, error) {
 	inLen := len(in)
 	if inLen < 1 {
 		return nil, io.ErrShortBuffer
@@ -120,12 +223,17 @@ func (cCodec *ClientCodec) Decode(in []byte) (any, error) {
 		if res.ConnId, left, err = codec.ReadUint64(cCodec.byteOrder, left); err != nil {
 			return nil, err
 		}
-		res.Payload = bytes.Clone(left)
+		res.Payload = clone(left)
 		return res, nil
-	case TypeMessageRouter:
+	case TypeMessageRouter, TypeMessageRouterC:
 		res := &MessageRouter{}
 		left := in[1:]
 		err := error(nil)
+		if msgType == TypeMessageRouterC {
+			if res.CorrelationId, left, err = codec.ReadUint32(cCodec.byteOrder, left); err != nil {
+				return nil, err
+			}
+		}
 		if res.RouterService, left, err = codec.ReadString(cCodec.byteOrder, left); err != nil {
 			return nil, err
 		}
@@ -135,7 +243,7 @@ func (cCodec *ClientCodec) Decode(in []byte) (any, error) {
 		if res.RouterId, left, err = codec.ReadString(cCodec.byteOrder, left); err != nil {
 			return nil, err
 		}
-		res.Payload = bytes.Clone(left)
+		res.Payload = clone(left)
 		return res, nil
 	case TypeBroadcastS:
 		res := &BroadcastSRes{}
@@ -144,7 +252,86 @@ func (cCodec *ClientCodec) Decode(in []byte) (any, error) {
 		if res.ConnIds, left, err = codec.ReadUint64Array(cCodec.byteOrder, left); err != nil {
 			return nil, err
 		}
-		res.Payload = bytes.Clone(left)
+		res.Payload = clone(left)
+		return res, nil
+	case TypeBroadcastAllS:
+		if inLen < 2 {
+			return nil, io.ErrShortBuffer
+		}
+		res := &BroadcastAllSRes{}
+		res.Flags = in[1]
+		left := in[2:]
+		err := error(nil)
+		if res.AttrKey, left, err = codec.ReadString(cCodec.byteOrder, left); err != nil {
+			return nil, err
+		}
+		if res.AttrValue, left, err = codec.ReadString(cCodec.byteOrder, left); err != nil {
+			return nil, err
+		}
+		res.Payload = clone(left)
+		return res, nil
+	case TypeSessionAttrS:
+		res := &SessionAttrSRes{}
+		left := in[1:]
+		err := error(nil)
+		if res.ConnId, left, err = codec.ReadUint64(cCodec.byteOrder, left); err != nil {
+			return nil, err
+		}
+		if res.Keys, left, err = codec.ReadStringArray(cCodec.byteOrder, left); err != nil {
+			return nil, err
+		}
+		if res.Values, left, err = codec.ReadStringArray(cCodec.byteOrder, left); err != nil {
+			return nil, err
+		}
+		if len(res.Keys) != len(res.Values) {
+			return nil, errors.New("(transfer client) mismatched session attribute keys and values")
+		}
+		if res.DeleteKeys, left, err = codec.ReadStringArray(cCodec.byteOrder, left); err != nil {
+			return nil, err
+		}
+		return res, nil
+	case TypeRebindS:
+		res := &RebindSRes{}
+		left := in[1:]
+		err := error(nil)
+		if res.ConnId, left, err = codec.ReadUint64(cCodec.byteOrder, left); err != nil {
+			return nil, err
+		}
+		if res.TargetServiceId, left, err = codec.ReadString(cCodec.byteOrder, left); err != nil {
+			return nil, err
+		}
+		res.Payload = clone(left)
+		return res, nil
+	case TypeJoinGroupS:
+		res := &JoinGroupSRes{}
+		left := in[1:]
+		err := error(nil)
+		if res.ConnId, left, err = codec.ReadUint64(cCodec.byteOrder, left); err != nil {
+			return nil, err
+		}
+		if res.Group, left, err = codec.ReadString(cCodec.byteOrder, left); err != nil {
+			return nil, err
+		}
+		return res, nil
+	case TypeLeaveGroupS:
+		res := &LeaveGroupSRes{}
+		left := in[1:]
+		err := error(nil)
+		if res.ConnId, left, err = codec.ReadUint64(cCodec.byteOrder, left); err != nil {
+			return nil, err
+		}
+		if res.Group, left, err = codec.ReadString(cCodec.byteOrder, left); err != nil {
+			return nil, err
+		}
+		return res, nil
+	case TypeGroupBroadcastS:
+		res := &GroupBroadcastSRes{}
+		left := in[1:]
+		err := error(nil)
+		if res.Group, left, err = codec.ReadString(cCodec.byteOrder, left); err != nil {
+			return nil, err
+		}
+		res.Payload = clone(left)
 		return res, nil
 	case TypeRegisterS:
 		res := &RegisterSRes{}
@@ -159,7 +346,7 @@ func (cCodec *ClientCodec) Decode(in []byte) (any, error) {
 		if res.RouterId, left, err = codec.ReadString(cCodec.byteOrder, left); err != nil {
 			return nil, err
 		}
-		res.Payload = bytes.Clone(left)
+		res.Payload = clone(left)
 		return res, nil
 	case TypeUnregisterS:
 		res := &UnregisterSRes{}
@@ -177,7 +364,7 @@ func (cCodec *ClientCodec) Decode(in []byte) (any, error) {
 		if res.ConnId, left, err = codec.ReadUint64(cCodec.byteOrder, left); err != nil {
 			return nil, err
 		}
-		res.Payload = bytes.Clone(left)
+		res.Payload = clone(left)
 		//plog.Debug("decode HeartbeatSRes", pfield.Uint64("connId", res.ConnId), pfield.Stack("stack"))
 		return res, nil
 	case TypeHandshake:
@@ -187,6 +374,20 @@ func (cCodec *ClientCodec) Decode(in []byte) (any, error) {
 		if res.Code, left, err = codec.ReadUint16(cCodec.byteOrder, left); err != nil {
 			return nil, err
 		}
+		// 兼容旧版本
+		if len(left) >= 4 {
+			if res.MaxFrameSize, left, err = codec.ReadUint32(cCodec.byteOrder, left); err != nil {
+				return nil, err
+			}
+		}
+		if len(left) >= 2+8 {
+			if res.Version, left, err = codec.ReadUint16(cCodec.byteOrder, left); err != nil {
+				return nil, err
+			}
+			if res.Capabilities, left, err = codec.ReadUint64(cCodec.byteOrder, left); err != nil {
+				return nil, err
+			}
+		}
 		return res, nil
 	case TypeServiceInstIReq:
 		req := &ServiceInstIReq{}
@@ -196,9 +397,32 @@ func (cCodec *ClientCodec) Decode(in []byte) (any, error) {
 			return nil, err
 		}
 		return req, nil
+	case TypeCompressed:
+		// 由 FrameCodec 或 Reassembler 按各自的长度限制解压
+		return nil, ErrUnexpectedCompressed
+	case TypeBatchS:
+		res := &BatchSRes{}
+		err := error(nil)
+		if res.Entries, err = decodeBatch(cCodec.byteOrder, clone, in[1:]); err != nil {
+			return nil, err
+		}
+		return res, nil
 	case TypeSegment:
-		return decodeSegmentMsg(cCodec.byteOrder, in[1:])
-	case TypeRPCRReq, TypeRPCRRes:
+		return decodeSegmentMsg(cCodec.byteOrder, clone, in[1:], false)
+	case TypeSegmentM:
+		return decodeSegmentMsg(cCodec.byteOrder, clone, in[1:], true)
+	case TypeMeshLookup:
+		res := &MeshLookupRes{}
+		left := in[1:]
+		err := error(nil)
+		if res.Seq, left, err = codec.ReadUint32(cCodec.byteOrder, left); err != nil {
+			return nil, err
+		}
+		if res.Code, _, err = codec.ReadUint16(cCodec.byteOrder, left); err != nil {
+			return nil, err
+		}
+		return res, nil
+	case TypeRPCRReq, TypeRPCRRes, TypeRPCRReqI, TypeRPCRResI, TypeMessageRouterRes, TypeMeshRelay:
 		// 这些消息不可能在client端解码
 		return nil, fmt.Errorf("unsupported message in client decoder:%d", msgType)
 	default:
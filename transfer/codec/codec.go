@@ -2,24 +2,559 @@ package codec
 
 import (
 	"encoding/binary"
+	"errors"
+	"github.com/meow-pad/chinchilla/utils/bufpool"
+	"github.com/meow-pad/persian/frame/plog"
+	"github.com/meow-pad/persian/frame/plog/pfield"
+	"github.com/meow-pad/persian/frame/pnet"
 	"github.com/meow-pad/persian/frame/pnet/message"
-	"github.com/meow-pad/persian/frame/pnet/tcp/codec"
+	"github.com/meow-pad/persian/frame/pnet/utils"
+	"github.com/panjf2000/gnet/v2"
+	"io"
 	"math"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// 帧长度字段大小
+	frameLengthSize = 2
+	// 大帧长度字段大小（跟在 bigFrameMarker 之后）
+	bigFrameLengthSize = 4
+	// 大帧标识，旧版本帧长度不会超过 LegacyMaxFrameSize，不会与之冲突
+	bigFrameMarker = math.MaxUint16
+	// 编码时预留的帧头长度
+	frameHeadroom = frameLengthSize + bigFrameLengthSize
+
+	// LegacyMaxFrameSize 2字节长度模式下的最大帧长度
+	LegacyMaxFrameSize = math.MaxInt16
+	// DefaultMaxFrameSize 默认最大帧长度
+	DefaultMaxFrameSize = 4 * 1024 * 1024
 )
 
 var (
 	MessageCodecByteOrder = binary.LittleEndian
 )
 
-func NewCodec(msgCodec message.Codec, messageWarningSize int) (codec.Codec, error) {
-	opts := []codec.Option[*codec.LengthOptions]{
-		codec.WithMessageCodec[*codec.LengthOptions](msgCodec),
-		codec.WithByteOrder(MessageCodecByteOrder),
-		codec.WithLengthSize(2),
-		codec.WithMaxDecodedLength[*codec.LengthOptions](math.MaxInt16),
-		codec.WithMaxEncodedLength[*codec.LengthOptions](math.MaxInt16),
-		codec.WithWarningEncodedLength[*codec.LengthOptions](messageWarningSize),
-		codec.WithEncodeLargeMessage(MessageSegmentation),
-	}
-	return codec.NewLengthFieldCodec(opts...)
+// Allocator 编码缓存分配器，返回长度为 size 的切片
+type Allocator func(size int) []byte
+
+// bufferEncoder 支持指定分配器的消息编码器
+type bufferEncoder interface {
+	EncodeWith(msg any, alloc Allocator) ([]byte, error)
+}
+
+// borrowDecoder 支持不拷贝负载的消息解码器
+type borrowDecoder interface {
+	DecodeBorrowed(in []byte) (any, error)
+}
+
+func makeBytes(size int) []byte {
+	return make([]byte, size)
+}
+
+func borrowBytes(in []byte) []byte {
+	return in
+}
+
+// NewCodec
+//
+//	@Description: 构建传输编解码器，可解码大帧，但仅在 EnableBigFrame 后才会编码大帧
+//	@param msgCodec
+//	@param messageWarningSize
+//	@return *FrameCodec
+//	@return error
+func NewCodec(msgCodec message.Codec, messageWarningSize int) (*FrameCodec, error) {
+	return NewFrameCodec(msgCodec, messageWarningSize, DefaultMaxFrameSize)
+}
+
+// NewFrameCodec
+//
+//	@Description: 构建传输编解码器
+//	@param msgCodec
+//	@param messageWarningSize
+//	@param maxFrameSize 可接收的最大帧长度
+//	@return *FrameCodec
+//	@return error
+func NewFrameCodec(msgCodec message.Codec, messageWarningSize int, maxFrameSize int) (*FrameCodec, error) {
+	if msgCodec == nil {
+		return nil, errors.New("nil message codec")
+	}
+	if maxFrameSize < LegacyMaxFrameSize {
+		maxFrameSize = LegacyMaxFrameSize
+	}
+	return &FrameCodec{
+		msgCodec:     msgCodec,
+		warningSize:  messageWarningSize,
+		maxFrameSize: maxFrameSize,
+	}, nil
+}
+
+// FrameCodec
+//
+//	@Description: 带长度的传输帧编解码
+//
+// * 普通帧：| len(2) | body |
+// * 大帧：  | 0xFFFF(2) | len(4) | body |
+type FrameCodec struct {
+	msgCodec     message.Codec
+	warningSize  int
+	maxFrameSize int
+	// 对端可接收的大帧长度，为0时超长消息使用分段发送
+	bigFrameSize atomic.Int64
+	// 压缩阈值，为0时不压缩
+	compressThreshold atomic.Int64
+	compression       compressionCounter
+	// 分段是否携带消息编号，对端不支持时使用旧版本分段头
+	segmentId atomic.Bool
+}
+
+// MaxFrameSize
+//
+//	@Description: 可接收的最大帧长度
+//	@receiver fCodec
+//	@return int
+func (fCodec *FrameCodec) MaxFrameSize() int {
+	return fCodec.maxFrameSize
+}
+
+// EnableBigFrame
+//
+//	@Description: 开启大帧编码（握手协商后）
+//	@receiver fCodec
+//	@param peerMaxFrameSize 对端可接收的最大帧长度
+func (fCodec *FrameCodec) EnableBigFrame(peerMaxFrameSize int) {
+	if peerMaxFrameSize <= LegacyMaxFrameSize {
+		fCodec.bigFrameSize.Store(0)
+		return
+	}
+	fCodec.bigFrameSize.Store(int64(peerMaxFrameSize))
+}
+
+// DisableBigFrame
+//
+//	@Description: 关闭大帧编码（如重新连接时）
+//	@receiver fCodec
+func (fCodec *FrameCodec) DisableBigFrame() {
+	fCodec.bigFrameSize.Store(0)
+}
+
+// IsBigFrameEnabled
+//
+//	@Description: 是否开启了大帧编码
+//	@receiver fCodec
+//	@return bool
+func (fCodec *FrameCodec) IsBigFrameEnabled() bool {
+	return fCodec.bigFrameSize.Load() > 0
+}
+
+// EnableCompression
+//
+//	@Description: 开启帧压缩（握手协商后）
+//	@receiver fCodec
+//	@param threshold 压缩阈值，消息体不小于该长度时压缩
+func (fCodec *FrameCodec) EnableCompression(threshold int) {
+	if threshold <= 0 {
+		threshold = DefaultCompressThreshold
+	}
+	fCodec.compressThreshold.Store(int64(threshold))
+}
+
+// DisableCompression
+//
+//	@Description: 关闭帧压缩（如重新连接时），仍可解压对端的压缩帧
+//	@receiver fCodec
+func (fCodec *FrameCodec) DisableCompression() {
+	fCodec.compressThreshold.Store(0)
+}
+
+// IsCompressionEnabled
+//
+//	@Description: 是否开启了帧压缩
+//	@receiver fCodec
+//	@return bool
+func (fCodec *FrameCodec) IsCompressionEnabled() bool {
+	return fCodec.compressThreshold.Load() > 0
+}
+
+// EnableSegmentId
+//
+//	@Description: 分段消息携带消息编号（握手协商后）
+//	@receiver fCodec
+func (fCodec *FrameCodec) EnableSegmentId() {
+	fCodec.segmentId.Store(true)
+}
+
+// DisableSegmentId
+//
+//	@Description: 分段消息使用旧版本分段头（如重新连接时），仍可解码携带编号的分段
+//	@receiver fCodec
+func (fCodec *FrameCodec) DisableSegmentId() {
+	fCodec.segmentId.Store(false)
+}
+
+// CompressionStats
+//
+//	@Description: 压缩统计
+//	@receiver fCodec
+//	@return CompressionStats
+func (fCodec *FrameCodec) CompressionStats() CompressionStats {
+	return fCodec.compression.stats()
+}
+
+func (fCodec *FrameCodec) Encode(msg any) ([]byte, error) {
+	builder := getFrameBuilder(false)
+	defer putFrameBuilder(builder)
+	frame, _, err := fCodec.encode(msg, builder)
+	return frame, err
+}
+
+// EncodeBuffer
+//
+//	@Description: 使用复用缓存编码，写出完成后需调用 Release 归还
+//	@receiver fCodec
+//	@param msg
+//	@return *bufpool.Buffer
+//	@return error
+func (fCodec *FrameCodec) EncodeBuffer(msg any) (*bufpool.Buffer, error) {
+	builder := getFrameBuilder(true)
+	defer putFrameBuilder(builder)
+	frame, inPlace, err := fCodec.encode(msg, builder)
+	pBuf := builder.pBuf
+	if err != nil {
+		pBuf.Release()
+		return nil, err
+	}
+	if !inPlace {
+		// 未能直接编码至复用缓存（如分段消息）
+		pBuf.Release()
+		pBuf = bufpool.Get(len(frame))
+		copy(pBuf.B, frame)
+		return pBuf, nil
+	}
+	pBuf.B = frame
+	return pBuf, nil
+}
+
+// encode
+//
+//	@Description: 编码帧，消息编码器支持时直接编码至预留了帧头的缓存，避免再次拷贝
+//	@receiver fCodec
+//	@param msg
+//	@param builder
+//	@return frame
+//	@return inPlace 帧是否位于 builder 分配的缓存中
+//	@return err
+func (fCodec *FrameCodec) encode(msg any, builder *frameBuilder) (frame []byte, inPlace bool, err error) {
+	if msg == nil {
+		return nil, false, pnet.ErrNilMessage
+	}
+	var body []byte
+	if encoder, ok := fCodec.msgCodec.(bufferEncoder); ok {
+		body, err = encoder.EncodeWith(msg, builder.allocFunc)
+	} else {
+		body, err = fCodec.msgCodec.Encode(msg)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	bodyLen := len(body)
+	if bodyLen <= 0 {
+		return nil, false, pnet.ErrEmptyEncodeBuffer
+	}
+	if bodyLen > fCodec.warningSize {
+		plog.Warn("encoded message is too long", pfield.Int("bodyLen", bodyLen))
+	}
+	// 消息体是否位于预留了帧头的缓存中（直接转发的数据不会使用分配器）
+	full := builder.full
+	inPlace = len(full) == frameHeadroom+bodyLen && &full[frameHeadroom] == &body[0]
+	if threshold := int(fCodec.compressThreshold.Load()); threshold > 0 && bodyLen >= threshold && !IsCompressed(body) {
+		if body, err = fCodec.compress(body); err != nil {
+			return nil, false, err
+		}
+		if len(body) != bodyLen {
+			bodyLen = len(body)
+			inPlace = false
+		}
+	}
+	if bodyLen <= LegacyMaxFrameSize {
+		if !inPlace {
+			return encodeFrame(body), false, nil
+		}
+		frame = full[frameHeadroom-frameLengthSize:]
+		MessageCodecByteOrder.PutUint16(frame, uint16(bodyLen))
+		return frame, true, nil
+	}
+	if bigFrameSize := int(fCodec.bigFrameSize.Load()); bodyLen <= bigFrameSize {
+		if !inPlace {
+			return encodeBigFrame(body), false, nil
+		}
+		MessageCodecByteOrder.PutUint16(full, bigFrameMarker)
+		MessageCodecByteOrder.PutUint32(full[frameLengthSize:], uint32(bodyLen))
+		return full, true, nil
+	}
+	// 对端不支持大帧或超出其限制则分段
+	frame, err = MessageSegmentation(body, LegacyMaxFrameSize, fCodec.segmentId.Load())
+	return frame, false, err
+}
+
+// compress
+//
+//	@Description: 压缩消息体，压缩后未变小则仍使用原消息体
+//	@receiver fCodec
+//	@param body
+//	@return []byte
+//	@return error
+func (fCodec *FrameCodec) compress(body []byte) ([]byte, error) {
+	compressed, err := compressBody(body)
+	if err != nil {
+		return nil, err
+	}
+	fCodec.compression.rawBytes.Add(uint64(len(body)))
+	if len(compressed) >= len(body) {
+		fCodec.compression.compressedBytes.Add(uint64(len(body)))
+		return body, nil
+	}
+	fCodec.compression.compressedBytes.Add(uint64(len(compressed)))
+	return compressed, nil
+}
+
+var (
+	frameBuilderPool = sync.Pool{
+		New: func() any {
+			builder := &frameBuilder{}
+			// 缓存方法值，避免每次编码创建闭包
+			builder.allocFunc = builder.alloc
+			return builder
+		},
+	}
+)
+
+func getFrameBuilder(pooled bool) *frameBuilder {
+	builder := frameBuilderPool.Get().(*frameBuilder)
+	builder.pooled = pooled
+	return builder
+}
+
+func putFrameBuilder(builder *frameBuilder) {
+	builder.pBuf = nil
+	builder.full = nil
+	frameBuilderPool.Put(builder)
+}
+
+// frameBuilder
+//
+//	@Description: 为消息体分配预留了帧头的缓存
+type frameBuilder struct {
+	pooled    bool
+	pBuf      *bufpool.Buffer
+	full      []byte
+	allocFunc Allocator
+}
+
+func (builder *frameBuilder) alloc(size int) []byte {
+	if builder.pooled {
+		builder.pBuf = bufpool.Get(frameHeadroom + size)
+		builder.full = builder.pBuf.B
+	} else {
+		builder.full = make([]byte, frameHeadroom+size)
+	}
+	return builder.full[frameHeadroom:]
+}
+
+func (fCodec *FrameCodec) Decode(reader gnet.Reader) (result []any, totalLen int, err error) {
+	for {
+		var msg any
+		var msgLen int
+		msg, msgLen, err = fCodec.decodeOne(reader)
+		if err != nil {
+			// 数据不足，稍后读取
+			if errors.Is(err, io.ErrShortBuffer) {
+				err = nil
+			}
+			return
+		}
+		if msg != nil {
+			result = append(result, msg)
+			totalLen += msgLen
+		}
+		if reader.InboundBuffered() <= 0 {
+			break
+		}
+	}
+	return
+}
+
+// DecodeBytes
+//
+//	@Description: 解码完整的帧数据，消息编码器支持时负载直接引用 data（data 需在消息处理完成前保持不变）
+//	@receiver fCodec
+//	@param data
+//	@return []any
+//	@return error
+func (fCodec *FrameCodec) DecodeBytes(data []byte) ([]any, error) {
+	decoder, ok := fCodec.msgCodec.(borrowDecoder)
+	if !ok {
+		msgArr, _, err := fCodec.Decode(utils.NewBytesReader(data))
+		return msgArr, err
+	}
+	var result []any
+	for len(data) > 0 {
+		bodyOffset, bodyLen, err := fCodec.frameHead(data)
+		if err != nil {
+			return result, err
+		}
+		if len(data) < bodyOffset+bodyLen {
+			return result, io.ErrShortBuffer
+		}
+		body := data[bodyOffset : bodyOffset+bodyLen]
+		var msg any
+		if IsCompressed(body) {
+			msg, err = fCodec.decodeCompressed(body)
+		} else {
+			msg, err = decoder.DecodeBorrowed(body)
+		}
+		if err != nil {
+			return result, err
+		}
+		result = append(result, msg)
+		data = data[bodyOffset+bodyLen:]
+	}
+	return result, nil
+}
+
+// frameHead
+//
+//	@Description: 解析帧头
+//	@receiver fCodec
+//	@param head
+//	@return bodyOffset
+//	@return bodyLen
+//	@return err 数据不足时为 io.ErrShortBuffer
+func (fCodec *FrameCodec) frameHead(head []byte) (bodyOffset int, bodyLen int, err error) {
+	if len(head) < frameLengthSize {
+		return 0, 0, io.ErrShortBuffer
+	}
+	bodyOffset = frameLengthSize
+	bodyLen = int(MessageCodecByteOrder.Uint16(head))
+	if bodyLen == bigFrameMarker {
+		bodyOffset += bigFrameLengthSize
+		if len(head) < bodyOffset {
+			return 0, 0, io.ErrShortBuffer
+		}
+		bodyLen = int(MessageCodecByteOrder.Uint32(head[frameLengthSize:]))
+	}
+	if bodyLen > fCodec.maxFrameSize {
+		return 0, 0, pnet.ErrMessageTooLarge
+	}
+	return
+}
+
+func (fCodec *FrameCodec) decodeOne(reader gnet.Reader) (msg any, msgLen int, err error) {
+	var headBuf []byte
+	headBuf, err = reader.Peek(frameLengthSize)
+	if err != nil || headBuf == nil {
+		return
+	}
+	if MessageCodecByteOrder.Uint16(headBuf) == bigFrameMarker {
+		headBuf, err = reader.Peek(frameLengthSize + bigFrameLengthSize)
+		if err != nil || headBuf == nil {
+			return
+		}
+	}
+	var bodyOffset, bodyLen int
+	if bodyOffset, bodyLen, err = fCodec.frameHead(headBuf); err != nil {
+		return
+	}
+	msgLen = bodyOffset + bodyLen
+	var msgBuf []byte
+	msgBuf, err = reader.Peek(msgLen)
+	if err != nil || msgBuf == nil {
+		return
+	}
+	if _, err = reader.Discard(msgLen); err != nil {
+		return
+	}
+	body := msgBuf[bodyOffset:msgLen]
+	if IsCompressed(body) {
+		// 解压后的数据不再复用，无需拷贝负载
+		msg, err = fCodec.decodeCompressed(body)
+		return
+	}
+	if decoder, ok := fCodec.msgCodec.(borrowDecoder); ok && len(body) > 0 && body[0] == TypeMessageS {
+		msg, err = decodePooled(decoder, body)
+		return
+	}
+	// 读缓存会被复用，需拷贝负载
+	msg, err = fCodec.msgCodec.Decode(body)
+	return
+}
+
+// decodePooled
+//
+//	@Description: 读缓存在处理前会被复用，将消息拷贝至复用缓存后解码，负载引用该缓存直至转发完成
+//	（见 MessageSReq.Release、MessageSRes.Release），未归还的缓存由GC回收
+//	@param decoder
+//	@param body
+//	@return any
+//	@return error
+func decodePooled(decoder borrowDecoder, body []byte) (any, error) {
+	pBuf := bufpool.Get(len(body))
+	copy(pBuf.B, body)
+	msg, err := decoder.DecodeBorrowed(pBuf.B)
+	switch tMsg := msg.(type) {
+	case *MessageSReq:
+		tMsg.buf = pBuf
+	case *MessageSRes:
+		tMsg.buf = pBuf
+	default:
+		pBuf.Release()
+	}
+	return msg, err
+}
+
+// decodeCompressed
+//
+//	@Description: 解压并解码消息
+//	@receiver fCodec
+//	@param body
+//	@return any
+//	@return error
+func (fCodec *FrameCodec) decodeCompressed(body []byte) (any, error) {
+	raw, err := decompressBody(body, fCodec.maxFrameSize)
+	if err != nil {
+		return nil, err
+	}
+	fCodec.compression.inCompressedBytes.Add(uint64(len(body)))
+	fCodec.compression.inRawBytes.Add(uint64(len(raw)))
+	return fCodec.DecodeBody(raw)
+}
+
+// DecodeBody
+//
+//	@Description: 以消息编解码器解码不带帧头的消息体（如重组后的分段消息），支持时负载直接引用 body
+//	@receiver fCodec
+//	@param body 需在消息处理完成前保持不变
+//	@return any
+//	@return error
+func (fCodec *FrameCodec) DecodeBody(body []byte) (any, error) {
+	if decoder, ok := fCodec.msgCodec.(borrowDecoder); ok {
+		return decoder.DecodeBorrowed(body)
+	}
+	return fCodec.msgCodec.Decode(body)
+}
+
+func encodeFrame(body []byte) []byte {
+	out := make([]byte, frameLengthSize+len(body))
+	MessageCodecByteOrder.PutUint16(out, uint16(len(body)))
+	copy(out[frameLengthSize:], body)
+	return out
+}
+
+func encodeBigFrame(body []byte) []byte {
+	out := make([]byte, frameLengthSize+bigFrameLengthSize+len(body))
+	MessageCodecByteOrder.PutUint16(out, bigFrameMarker)
+	MessageCodecByteOrder.PutUint32(out[frameLengthSize:], uint32(len(body)))
+	copy(out[frameLengthSize+bigFrameLengthSize:], body)
+	return out
 }
@@ -1,7 +1,10 @@
 package codec
 
 import (
+	"bytes"
 	"encoding/binary"
+	"fmt"
+	"github.com/meow-pad/persian/frame/pnet/utils"
 	"github.com/stretchr/testify/require"
 	"math/rand"
 	"reflect"
@@ -16,6 +19,7 @@ func _getObjectValue(ptr any) any {
 func TestCodec_Req(t *testing.T) {
 	should := require.New(t)
 	segmentMsg := &SegmentMsg{
+		MsgId:  987654321,
 		Amount: 12345,
 		Seq:    123,
 		Frame:  []byte{1, 2, 3, 4, 5},
@@ -27,6 +31,10 @@ func TestCodec_Req(t *testing.T) {
 		ServiceId: "123",
 		ConnIds:   []uint64{123, 456},
 		RouterIds: []string{"123", "456"},
+
+		MaxFrameSize: 1 << 20,
+		Version:      ProtocolVersion,
+		Capabilities: SupportedCapabilities,
 	}
 	registerSReq := &RegisterSReq{
 		ConnId:  12345,
@@ -47,7 +55,35 @@ func TestCodec_Req(t *testing.T) {
 		ServiceName:    "123",
 		ServiceInstArr: []string{"123", "456"},
 	}
-	messages := []any{segmentMsg, handshakeReq, registerSReq, unregisterReq, heartbeatSReq, messageSReq, srvInstIRes}
+	rebindSReq := &RebindSReq{
+		ConnId:          12345,
+		TargetServiceId: "ts-2",
+		Code:            1,
+	}
+	messageRouterRes := &MessageRouterRes{
+		Code:          8,
+		CorrelationId: 987654,
+		RouterService: "456",
+		RouterType:    -2,
+		RouterId:      "abc",
+	}
+	batchSReq := &BatchSReq{
+		Entries: []BatchEntry{
+			{ConnId: 123, Payload: []byte{1, 2, 3}},
+			{ConnId: 456, Payload: []byte{4, 5}},
+		},
+	}
+	meshRelayReq := &MeshRelayReq{
+		Service:   "test",
+		ServiceId: "123",
+		Payload:   []byte{1, 2, 3, 4, 5},
+	}
+	meshLookupReq := &MeshLookupReq{
+		Seq:      987654,
+		RouterId: "10086",
+	}
+	messages := []any{segmentMsg, handshakeReq, registerSReq, unregisterReq, heartbeatSReq, messageSReq, srvInstIRes,
+		rebindSReq, batchSReq, messageRouterRes, meshRelayReq, meshLookupReq}
 	cCodec := ClientCodec{byteOrder: binary.BigEndian}
 	sCodec := ServerCodec{byteOrder: binary.BigEndian}
 	for _, msg := range messages {
@@ -66,12 +102,16 @@ func TestCodec_Req(t *testing.T) {
 func TestCodec_Res(t *testing.T) {
 	should := require.New(t)
 	segmentMsg := &SegmentMsg{
+		MsgId:  987654321,
 		Amount: 12345,
 		Seq:    123,
 		Frame:  []byte{1, 2, 3, 4, 5},
 	}
 	handshakeRes := &HandshakeRes{
-		Code: 123,
+		Code:         123,
+		MaxFrameSize: 1 << 20,
+		Version:      ProtocolVersion,
+		Capabilities: SupportedCapabilities,
 	}
 	registerSRes := &RegisterSRes{
 		ConnId:   12345,
@@ -100,11 +140,59 @@ func TestCodec_Res(t *testing.T) {
 		RouterId:      "abc",
 		Payload:       []byte{1, 2, 3, 4, 5},
 	}
+	messageRouterC := &MessageRouter{
+		CorrelationId: 987654,
+		RouterService: "456",
+		RouterType:    -2,
+		RouterId:      "abc",
+		Payload:       []byte{1, 2, 3, 4, 5},
+	}
 	serviceInstIReq := &ServiceInstIReq{
 		ServiceName: "654",
 	}
+	joinGroupSRes := &JoinGroupSRes{
+		ConnId: 12345,
+		Group:  "world",
+	}
+	leaveGroupSRes := &LeaveGroupSRes{
+		ConnId: 12345,
+		Group:  "world",
+	}
+	groupBroadcastSRes := &GroupBroadcastSRes{
+		Group:   "world",
+		Payload: []byte{1, 2, 3, 4, 5},
+	}
+	broadcastAllSRes := &BroadcastAllSRes{
+		Flags:     BroadcastFlagService | BroadcastFlagRegistered | BroadcastFlagAttribute,
+		AttrKey:   "guild",
+		AttrValue: "42",
+		Payload:   []byte{1, 2, 3, 4, 5},
+	}
+	rebindSRes := &RebindSRes{
+		ConnId:          12345,
+		TargetServiceId: "ts-2",
+		Payload:         []byte{1, 2, 3, 4, 5},
+	}
+	sessionAttrSRes := &SessionAttrSRes{
+		ConnId:     12345,
+		Keys:       []string{"uid", "locale"},
+		Values:     []string{"10086", "zh"},
+		DeleteKeys: []string{"guild"},
+	}
+	batchSRes := &BatchSRes{
+		Entries: []BatchEntry{
+			{ConnId: 123, Payload: []byte{1, 2, 3}},
+			{ConnId: 456, Payload: []byte{4, 5}},
+		},
+	}
+	meshLookupRes := &MeshLookupRes{
+		Seq:  987654,
+		Code: 9,
+	}
 	messages := []any{segmentMsg, handshakeRes, registerSRes, unregisterSRes,
-		heartbeatSRes, messageSRes, broadcastSRes, messageRouter, serviceInstIReq}
+		heartbeatSRes, messageSRes, broadcastSRes, messageRouter, messageRouterC, serviceInstIReq,
+		joinGroupSRes, leaveGroupSRes, groupBroadcastSRes, broadcastAllSRes, sessionAttrSRes, rebindSRes, batchSRes,
+		meshLookupRes}
 	cCodec := ClientCodec{byteOrder: binary.BigEndian}
 	sCodec := ServerCodec{byteOrder: binary.BigEndian}
 	for _, msg := range messages {
@@ -119,3 +207,409 @@ func TestCodec_Res(t *testing.T) {
 		should.Equal(_getObjectValue(msg), _getObjectValue(dMsg))
 	}
 }
+
+func TestFrameCodec(t *testing.T) {
+	should := require.New(t)
+	payload := make([]byte, 100*1024)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	msg := &MessageSReq{
+		ConnId:  12345,
+		Payload: payload,
+	}
+	fCodec, err := NewCodec(NewClientCodec(MessageCodecByteOrder), 8*1024)
+	should.Nil(err)
+	dCodec, err := NewCodec(NewServerCodec(MessageCodecByteOrder), 8*1024)
+	should.Nil(err)
+	body, err := NewClientCodec(MessageCodecByteOrder).Encode(msg)
+	should.Nil(err)
+	// 未协商大帧时分段
+	data, err := fCodec.Encode(msg)
+	should.Nil(err)
+	dFrames, dLen, err := dCodec.Decode(utils.NewBytesReader(data))
+	should.Nil(err)
+	should.Equal(len(data), dLen)
+	should.Greater(len(dFrames), 1)
+	reassembler := NewReassembler(DefaultReassembleMaxSize, DefaultReassembleTimeout)
+	var merged []byte
+	for i, dFrame := range dFrames {
+		segment, ok := dFrame.(*SegmentMsg)
+		should.True(ok)
+		should.Equal(uint16(i), segment.Seq)
+		should.Equal(uint16(len(dFrames)), segment.Amount)
+		merged, err = reassembler.Push(segment)
+		should.Nil(err)
+	}
+	should.True(bytes.Equal(body, merged))
+	// 协商大帧后单帧发送
+	fCodec.EnableBigFrame(DefaultMaxFrameSize)
+	data, err = fCodec.Encode(msg)
+	should.Nil(err)
+	should.Equal(frameLengthSize+bigFrameLengthSize+len(body), len(data))
+	reader := utils.NewBytesReader(append(data, data...))
+	dMessages, _, err := dCodec.Decode(reader)
+	should.Nil(err)
+	should.Len(dMessages, 2)
+	for _, dMsg := range dMessages {
+		dReq := dMsg.(*MessageSReq)
+		should.Equal(msg.ConnId, dReq.ConnId)
+		should.Equal(msg.Payload, dReq.Payload)
+		dReq.Release()
+	}
+	// 超出可接收长度
+	limitCodec, err := NewFrameCodec(NewServerCodec(MessageCodecByteOrder), 8*1024, LegacyMaxFrameSize)
+	should.Nil(err)
+	_, _, err = limitCodec.Decode(utils.NewBytesReader(data))
+	should.NotNil(err)
+}
+
+func TestReassembler(t *testing.T) {
+	should := require.New(t)
+	reassembler := NewReassembler(16, DefaultReassembleTimeout)
+	// 交错到达
+	buf, err := reassembler.Push(&SegmentMsg{MsgId: 1, Amount: 2, Seq: 0, Frame: []byte{1, 2}})
+	should.Nil(err)
+	should.Nil(buf)
+	buf, err = reassembler.Push(&SegmentMsg{MsgId: 2, Amount: 2, Seq: 0, Frame: []byte{5, 6}})
+	should.Nil(err)
+	should.Nil(buf)
+	buf, err = reassembler.Push(&SegmentMsg{MsgId: 1, Amount: 2, Seq: 1, Frame: []byte{3, 4}})
+	should.Nil(err)
+	should.Equal([]byte{1, 2, 3, 4}, buf)
+	buf, err = reassembler.Push(&SegmentMsg{MsgId: 2, Amount: 2, Seq: 1, Frame: []byte{7, 8}})
+	should.Nil(err)
+	should.Equal([]byte{5, 6, 7, 8}, buf)
+	should.Equal(0, reassembler.Pending())
+	// 丢失分段
+	_, err = reassembler.Push(&SegmentMsg{MsgId: 3, Amount: 3, Seq: 0, Frame: []byte{1}})
+	should.Nil(err)
+	_, err = reassembler.Push(&SegmentMsg{MsgId: 3, Amount: 3, Seq: 2, Frame: []byte{3}})
+	should.NotNil(err)
+	should.Equal(0, reassembler.Pending())
+	_, err = reassembler.Push(&SegmentMsg{MsgId: 4, Amount: 2, Seq: 1, Frame: []byte{1}})
+	should.NotNil(err)
+	// 超出长度
+	_, err = reassembler.Push(&SegmentMsg{MsgId: 5, Amount: 2, Seq: 0, Frame: make([]byte, 10)})
+	should.Nil(err)
+	_, err = reassembler.Push(&SegmentMsg{MsgId: 5, Amount: 2, Seq: 1, Frame: make([]byte, 10)})
+	should.NotNil(err)
+	should.Equal(0, reassembler.Pending())
+}
+
+func TestReassembler_Compressed(t *testing.T) {
+	should := require.New(t)
+	raw := bytes.Repeat([]byte{TypeMessageS, 1, 2, 3}, 64)
+	compressed, err := compressBody(raw)
+	should.Nil(err)
+	should.Less(len(compressed), 64)
+	// 解压后的长度受重组长度限制
+	reassembler := NewReassembler(64, DefaultReassembleTimeout)
+	_, err = reassembler.Push(&SegmentMsg{MsgId: 1, Amount: 1, Frame: compressed})
+	should.ErrorContains(err, "invalid decompressed size")
+	reassembler = NewReassembler(len(raw), DefaultReassembleTimeout)
+	buf, err := reassembler.Push(&SegmentMsg{MsgId: 2, Amount: 1, Frame: compressed})
+	should.Nil(err)
+	should.Equal(raw, buf)
+	// 拒绝嵌套压缩
+	nested, err := compressBody(compressed)
+	should.Nil(err)
+	_, err = reassembler.Push(&SegmentMsg{MsgId: 3, Amount: 1, Frame: nested})
+	should.ErrorIs(err, ErrUnexpectedCompressed)
+	// 消息编解码器不解压
+	_, err = NewServerCodec(MessageCodecByteOrder).Decode(compressed)
+	should.ErrorIs(err, ErrUnexpectedCompressed)
+	_, err = NewClientCodec(MessageCodecByteOrder).Decode(compressed)
+	should.ErrorIs(err, ErrUnexpectedCompressed)
+}
+
+func TestCodec_HandshakeCompat(t *testing.T) {
+	should := require.New(t)
+	cCodec := ClientCodec{byteOrder: binary.BigEndian}
+	sCodec := ServerCodec{byteOrder: binary.BigEndian}
+	data, err := cCodec.Encode(&HandshakeReq{
+		Id:           "1234",
+		MaxFrameSize: 1 << 20,
+		Version:      ProtocolVersion,
+		Capabilities: SupportedCapabilities,
+	})
+	should.Nil(err)
+	// 旧版本无帧长度、版本及能力字段
+	dMsg, err := sCodec.Decode(data[:len(data)-4-2-8])
+	should.Nil(err)
+	should.Equal(HandshakeReq{Id: "1234"}, _getObjectValue(dMsg))
+	data, err = sCodec.Encode(&HandshakeRes{
+		Code:         1,
+		MaxFrameSize: 1 << 20,
+		Version:      ProtocolVersion,
+		Capabilities: SupportedCapabilities,
+	})
+	should.Nil(err)
+	dMsg, err = cCodec.Decode(data[:len(data)-2-8])
+	should.Nil(err)
+	should.Equal(HandshakeRes{Code: 1, MaxFrameSize: 1 << 20}, _getObjectValue(dMsg))
+}
+
+func TestCodec_RpcCompat(t *testing.T) {
+	should := require.New(t)
+	sCodec := ServerCodec{byteOrder: binary.BigEndian}
+	// 旧版本响应 | type | code | rpcId | payload |
+	oldRes := []byte{TypeRPCRRes, 0, 0, 0, 0, 0, 7, '{', '}'}
+	dMsg, err := sCodec.Decode(oldRes)
+	should.Nil(err)
+	should.Equal(RpcRRes{RPCId: 7, Payload: []byte("{}")}, _getObjectValue(dMsg))
+	// 无实例id时保持旧格式
+	data, err := sCodec.Encode(&RpcRRes{RPCId: 7, Payload: []byte("{}")})
+	should.Nil(err)
+	should.Equal(oldRes, data)
+	data, err = sCodec.Encode(&RpcRReq{SourceSrv: "a", SourceId: "1", RPCId: 7, Payload: []byte{1}})
+	should.Nil(err)
+	should.Equal(byte(TypeRPCRReq), data[0])
+	// 携带实例id时使用新类型
+	res := &RpcRRes{Code: 1, RPCId: 7, ServiceId: "inst1", Payload: []byte("{}")}
+	data, err = sCodec.Encode(res)
+	should.Nil(err)
+	should.Equal(byte(TypeRPCRResI), data[0])
+	dMsg, err = sCodec.Decode(data)
+	should.Nil(err)
+	should.Equal(*res, _getObjectValue(dMsg))
+	req := &RpcRReq{SourceSrv: "a", SourceId: "1", RPCId: 7, WantServiceId: true, Payload: []byte{1}}
+	data, err = sCodec.Encode(req)
+	should.Nil(err)
+	should.Equal(byte(TypeRPCRReqI), data[0])
+	dMsg, err = sCodec.Decode(data)
+	should.Nil(err)
+	should.Equal(*req, _getObjectValue(dMsg))
+}
+
+func TestFrameCodec_SegmentCompat(t *testing.T) {
+	should := require.New(t)
+	sCodec := ServerCodec{byteOrder: binary.BigEndian}
+	// 旧版本分段 | type | amount | seq | frame |
+	oldSegment := []byte{TypeSegment, 0, 2, 0, 1, 7, 8}
+	dMsg, err := sCodec.Decode(oldSegment)
+	should.Nil(err)
+	should.Equal(SegmentMsg{Amount: 2, Seq: 1, Frame: []byte{7, 8}}, _getObjectValue(dMsg))
+	msg := &MessageSReq{ConnId: 1, Payload: make([]byte, 3*LegacyMaxFrameSize)}
+	fCodec, err := NewCodec(NewClientCodec(MessageCodecByteOrder), 8*1024)
+	should.Nil(err)
+	dCodec, err := NewCodec(NewServerCodec(MessageCodecByteOrder), 8*1024)
+	should.Nil(err)
+	decodeSegments := func() []*SegmentMsg {
+		data, eErr := fCodec.Encode(msg)
+		should.Nil(eErr)
+		dFrames, _, dErr := dCodec.Decode(utils.NewBytesReader(data))
+		should.Nil(dErr)
+		segments := make([]*SegmentMsg, 0, len(dFrames))
+		for _, dFrame := range dFrames {
+			segments = append(segments, dFrame.(*SegmentMsg))
+		}
+		return segments
+	}
+	// 未协商时使用旧版本分段头
+	for _, segment := range decodeSegments() {
+		should.Equal(uint32(0), segment.MsgId)
+	}
+	// 协商后携带消息编号
+	fCodec.EnableSegmentId()
+	segments := decodeSegments()
+	for _, segment := range segments {
+		should.Equal(segments[0].MsgId, segment.MsgId)
+	}
+	should.Equal(segments[0].MsgId+1, decodeSegments()[0].MsgId)
+	fCodec.DisableSegmentId()
+	should.Equal(uint32(0), decodeSegments()[0].MsgId)
+}
+
+func TestFrameCodec_Buffer(t *testing.T) {
+	should := require.New(t)
+	messages := []any{
+		&HandshakeReq{Id: "1234", AuthKey: "12345", Service: "test", ServiceId: "123",
+			ConnIds: []uint64{123, 456}, RouterIds: []string{"123", "456"}, MaxFrameSize: 1 << 20},
+		&RegisterSReq{ConnId: 12345, Payload: []byte{1, 2, 3, 4, 5}},
+		&MessageSReq{ConnId: 12345, Payload: make([]byte, 40*1024)},
+		&RebindSReq{ConnId: 12345, TargetServiceId: "ts-2", Code: 1},
+	}
+	fCodec, err := NewCodec(NewClientCodec(MessageCodecByteOrder), 64*1024)
+	should.Nil(err)
+	fCodec.EnableBigFrame(DefaultMaxFrameSize)
+	dCodec, err := NewCodec(NewServerCodec(MessageCodecByteOrder), 64*1024)
+	should.Nil(err)
+	// 多轮以复用已写过的缓存
+	for i := 0; i < 3; i++ {
+		for _, msg := range messages {
+			buf, err := fCodec.EncodeBuffer(msg)
+			should.Nil(err)
+			data, err := fCodec.Encode(msg)
+			should.Nil(err)
+			should.Equal(data, buf.B)
+			dMessages, err := dCodec.DecodeBytes(bytes.Clone(buf.B))
+			should.Nil(err)
+			should.Len(dMessages, 1)
+			should.Equal(_getObjectValue(msg), _getObjectValue(dMessages[0]))
+			buf.Release()
+		}
+	}
+}
+
+func benchmarkMessage() *MessageSReq {
+	return &MessageSReq{
+		ConnId:  12345,
+		Payload: make([]byte, 512),
+	}
+}
+
+// BenchmarkFrameCodec_EncodeCopy 先编码消息体再拷贝至帧（原实现）
+func BenchmarkFrameCodec_EncodeCopy(b *testing.B) {
+	msg := benchmarkMessage()
+	cCodec := NewClientCodec(MessageCodecByteOrder)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		body, _ := cCodec.Encode(msg)
+		_ = encodeFrame(body)
+	}
+}
+
+func BenchmarkFrameCodec_Encode(b *testing.B) {
+	msg := benchmarkMessage()
+	fCodec, _ := NewCodec(NewClientCodec(MessageCodecByteOrder), 64*1024)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = fCodec.Encode(msg)
+	}
+}
+
+func BenchmarkFrameCodec_EncodeBuffer(b *testing.B) {
+	msg := benchmarkMessage()
+	fCodec, _ := NewCodec(NewClientCodec(MessageCodecByteOrder), 64*1024)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf, _ := fCodec.EncodeBuffer(msg)
+		buf.Release()
+	}
+}
+
+func BenchmarkFrameCodec_Decode(b *testing.B) {
+	fCodec, _ := NewCodec(NewClientCodec(MessageCodecByteOrder), 64*1024)
+	data, _ := fCodec.Encode(benchmarkMessage())
+	dCodec, _ := NewCodec(NewServerCodec(MessageCodecByteOrder), 64*1024)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _, _ = dCodec.Decode(utils.NewBytesReader(data))
+	}
+}
+
+func BenchmarkFrameCodec_DecodeBytes(b *testing.B) {
+	fCodec, _ := NewCodec(NewClientCodec(MessageCodecByteOrder), 64*1024)
+	data, _ := fCodec.Encode(benchmarkMessage())
+	dCodec, _ := NewCodec(NewServerCodec(MessageCodecByteOrder), 64*1024)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = dCodec.DecodeBytes(data)
+	}
+}
+
+func TestFrameCodec_DecodePooled(t *testing.T) {
+	should := require.New(t)
+	fCodec, err := NewCodec(NewServerCodec(MessageCodecByteOrder), 64*1024)
+	should.Nil(err)
+	dCodec, err := NewCodec(NewClientCodec(MessageCodecByteOrder), 64*1024)
+	should.Nil(err)
+	var data []byte
+	for i := 0; i < 2; i++ {
+		frame, err := fCodec.Encode(&MessageSRes{ConnId: uint64(i), Payload: []byte{1, 2, 3, byte(i)}})
+		should.Nil(err)
+		data = append(data, frame...)
+	}
+	frame, err := fCodec.Encode(&HeartbeatSRes{ConnId: 9, Payload: []byte{1}})
+	should.Nil(err)
+	data = append(data, frame...)
+	dMessages, _, err := dCodec.Decode(utils.NewBytesReader(data))
+	should.Nil(err)
+	should.Len(dMessages, 3)
+	// 读缓存被复用后负载不变
+	for i := range data {
+		data[i] = 0
+	}
+	for i, dMsg := range dMessages[:2] {
+		res := dMsg.(*MessageSRes)
+		should.NotNil(res.buf)
+		should.Equal([]byte{1, 2, 3, byte(i)}, res.Payload)
+		res.Release()
+		should.Nil(res.buf)
+		should.Nil(res.Payload)
+		// 重复归还无影响
+		res.Release()
+	}
+	// 其它消息仍拷贝负载
+	should.Equal(&HeartbeatSRes{ConnId: 9, Payload: []byte{1}}, dMessages[2])
+}
+
+func BenchmarkFrameCodec_DecodePooled(b *testing.B) {
+	fCodec, _ := NewCodec(NewClientCodec(MessageCodecByteOrder), 64*1024)
+	data, _ := fCodec.Encode(benchmarkMessage())
+	dCodec, _ := NewCodec(NewServerCodec(MessageCodecByteOrder), 64*1024)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dMessages, _, _ := dCodec.Decode(utils.NewBytesReader(data))
+		dMessages[0].(*MessageSReq).Release()
+	}
+}
+
+func TestFrameCodec_Compression(t *testing.T) {
+	should := require.New(t)
+	msg := &MessageSReq{
+		ConnId:  12345,
+		Payload: bytes.Repeat([]byte(`{"name":"chinchilla","level":1}`), 4*1024),
+	}
+	fCodec, err := NewCodec(NewClientCodec(MessageCodecByteOrder), 256*1024)
+	should.Nil(err)
+	dCodec, err := NewCodec(NewServerCodec(MessageCodecByteOrder), 256*1024)
+	should.Nil(err)
+	fCodec.EnableCompression(DefaultCompressThreshold)
+	// 压缩后单帧
+	data, err := fCodec.Encode(msg)
+	should.Nil(err)
+	should.Less(len(data), len(msg.Payload)/4)
+	dMessages, _, err := dCodec.Decode(utils.NewBytesReader(data))
+	should.Nil(err)
+	should.Len(dMessages, 1)
+	should.Equal(_getObjectValue(msg), _getObjectValue(dMessages[0]))
+	stats := fCodec.CompressionStats()
+	should.Less(stats.Ratio(), 0.25)
+	should.Equal(stats.CompressedBytes, dCodec.CompressionStats().InCompressedBytes)
+	should.Equal(stats.RawBytes, dCodec.CompressionStats().InRawBytes)
+	// 压缩后仍需分段
+	msg.Payload = nil
+	for i := 0; i < 20000; i++ {
+		msg.Payload = append(msg.Payload, fmt.Sprintf(`{"id":%d,"score":%d},`, rand.Int31(), rand.Int31())...)
+	}
+	data, err = fCodec.Encode(msg)
+	should.Nil(err)
+	should.Less(len(data), len(msg.Payload))
+	dMessages, _, err = dCodec.Decode(utils.NewBytesReader(data))
+	should.Nil(err)
+	should.Greater(len(dMessages), 1)
+	reassembler := NewReassembler(DefaultReassembleMaxSize, DefaultReassembleTimeout)
+	var body []byte
+	for _, dMsg := range dMessages {
+		body, err = reassembler.Push(dMsg.(*SegmentMsg))
+		should.Nil(err)
+	}
+	// 重组时解压
+	should.False(IsCompressed(body))
+	dMsg, err := NewServerCodec(MessageCodecByteOrder).DecodeBorrowed(body)
+	should.Nil(err)
+	should.Equal(_getObjectValue(msg), _getObjectValue(dMsg))
+	// 低于阈值不压缩
+	small := &MessageSReq{ConnId: 1, Payload: []byte{1, 2, 3}}
+	data, err = fCodec.Encode(small)
+	should.Nil(err)
+	should.False(IsCompressed(data[frameLengthSize:]))
+}
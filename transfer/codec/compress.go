@@ -0,0 +1,158 @@
+package codec
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	CompressFlate = 1 // deflate 压缩
+
+	// 压缩消息头 | type(1) | algo(1) | rawLen(4) |
+	compressHeadSize = 1 + 1 + 4
+	// 默认压缩阈值
+	DefaultCompressThreshold = 1024
+)
+
+// ErrUnexpectedCompressed 压缩消息只能由 FrameCodec 或 Reassembler 解压，不能出现在消息体中（如嵌套压缩）
+var ErrUnexpectedCompressed = errors.New("unexpected compressed message")
+
+var (
+	flateWriterPool = sync.Pool{
+		New: func() any {
+			writer, _ := flate.NewWriter(nil, flate.BestSpeed)
+			return writer
+		},
+	}
+	flateReaderPool = sync.Pool{
+		New: func() any {
+			return flate.NewReader(bytes.NewReader(nil))
+		},
+	}
+)
+
+// CompressionStats
+//
+//	@Description: 压缩统计，仅统计达到压缩阈值的帧
+type CompressionStats struct {
+	RawBytes          uint64 // 发送：压缩前字节数
+	CompressedBytes   uint64 // 发送：实际发送字节数
+	InRawBytes        uint64 // 接收：解压后字节数
+	InCompressedBytes uint64 // 接收：压缩字节数
+}
+
+// Ratio
+//
+//	@Description: 发送压缩率（实际发送/压缩前），无数据时为1
+//	@receiver stats
+//	@return float64
+func (stats CompressionStats) Ratio() float64 {
+	if stats.RawBytes == 0 {
+		return 1
+	}
+	return float64(stats.CompressedBytes) / float64(stats.RawBytes)
+}
+
+// InRatio
+//
+//	@Description: 接收压缩率（压缩字节/解压后），无数据时为1
+//	@receiver stats
+//	@return float64
+func (stats CompressionStats) InRatio() float64 {
+	if stats.InRawBytes == 0 {
+		return 1
+	}
+	return float64(stats.InCompressedBytes) / float64(stats.InRawBytes)
+}
+
+type compressionCounter struct {
+	rawBytes          atomic.Uint64
+	compressedBytes   atomic.Uint64
+	inRawBytes        atomic.Uint64
+	inCompressedBytes atomic.Uint64
+}
+
+func (counter *compressionCounter) stats() CompressionStats {
+	return CompressionStats{
+		RawBytes:          counter.rawBytes.Load(),
+		CompressedBytes:   counter.compressedBytes.Load(),
+		InRawBytes:        counter.inRawBytes.Load(),
+		InCompressedBytes: counter.inCompressedBytes.Load(),
+	}
+}
+
+// IsCompressed
+//
+//	@Description: 是否为压缩消息
+//	@param body
+//	@return bool
+func IsCompressed(body []byte) bool {
+	return len(body) > 0 && body[0] == TypeCompressed
+}
+
+// compressBody
+//
+//	@Description: 压缩已编码的消息
+//	@param body
+//	@return []byte | type(1) | algo(1) | rawLen(4) | data |
+//	@return error
+func compressBody(body []byte) ([]byte, error) {
+	out := bytes.NewBuffer(make([]byte, compressHeadSize, compressHeadSize+len(body)/2))
+	writer := flateWriterPool.Get().(*flate.Writer)
+	defer flateWriterPool.Put(writer)
+	writer.Reset(out)
+	if _, err := writer.Write(body); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	buf := out.Bytes()
+	buf[0] = TypeCompressed
+	buf[1] = CompressFlate
+	MessageCodecByteOrder.PutUint32(buf[2:], uint32(len(body)))
+	return buf, nil
+}
+
+// decompressBody
+//
+//	@Description: 解压消息
+//	@param in 压缩消息
+//	@param maxSize 解压后的最大长度
+//	@return []byte 解压后的消息
+//	@return error
+func decompressBody(in []byte, maxSize int) ([]byte, error) {
+	if len(in) < compressHeadSize {
+		return nil, io.ErrShortBuffer
+	}
+	if in[1] != CompressFlate {
+		return nil, fmt.Errorf("unsupported compression algorithm:%d", in[1])
+	}
+	rawLen := int(MessageCodecByteOrder.Uint32(in[2:]))
+	if rawLen <= 0 || rawLen > maxSize {
+		return nil, fmt.Errorf("invalid decompressed size:%d, max:%d", rawLen, maxSize)
+	}
+	reader := flateReaderPool.Get().(io.ReadCloser)
+	defer flateReaderPool.Put(reader)
+	if err := reader.(flate.Resetter).Reset(bytes.NewReader(in[compressHeadSize:]), nil); err != nil {
+		return nil, err
+	}
+	raw := make([]byte, rawLen)
+	if _, err := io.ReadFull(reader, raw); err != nil {
+		return nil, err
+	}
+	// 数据应恰好解压完毕
+	var probe [1]byte
+	if n, _ := reader.Read(probe[:]); n > 0 {
+		return nil, fmt.Errorf("decompressed size exceeds declared size:%d", rawLen)
+	}
+	if IsCompressed(raw) {
+		return nil, ErrUnexpectedCompressed
+	}
+	return raw, nil
+}
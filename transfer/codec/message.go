@@ -1,5 +1,7 @@
 package codec
 
+import "github.com/meow-pad/chinchilla/utils/bufpool"
+
 const (
 	// 默认为服务器间交互消息（transfer为req）
 	// 以S结尾的消息为转发用户相关的消息
@@ -17,9 +19,46 @@ const (
 	TypeRPCRRes
 	TypeServiceInstIReS
 	TypeServiceInstIReq
+	TypeJoinGroupS
+	TypeLeaveGroupS
+	TypeGroupBroadcastS
+	TypeBroadcastAllS
+	TypeSessionAttrS
+	TypeRebindS
+	TypeCompressed
+	TypeBatchS
+	TypeMessageRouterC   // 带关联id的路由消息，路由失败时回复 MessageRouterRes
+	TypeMessageRouterRes // 路由失败回复
+	TypeMeshRelay        // 网关间转发的服务消息
+	TypeMeshLookup       // 网关间查询会话
+	TypeRPCRReqI         // 要求响应携带处理实例id的 rpc 请求
+	TypeRPCRResI         // 携带处理实例id的 rpc 响应
+	TypeSegmentM         // 携带消息编号的分段消息
+)
+
+const (
+	BroadcastFlagService    = 1 << iota // 仅发送至绑定了发送方服务实例的会话
+	BroadcastFlagRegistered             // 仅发送至已注册的会话
+	BroadcastFlagAttribute              // 仅发送至属性匹配的会话
+)
+
+const (
+	// ProtocolVersion 当前传输协议版本，旧版本握手中无该字段即视为0
+	ProtocolVersion = 1
+)
+
+const (
+	CapBigFrame    = 1 << iota // 4字节长度帧
+	CapCompression             // 帧压缩
+	CapBatch                   // 多连接批量帧
+	CapSegmentId               // 分段消息携带消息编号
+
+	// SupportedCapabilities 当前版本支持的能力
+	SupportedCapabilities = CapBigFrame | CapCompression | CapBatch | CapSegmentId
 )
 
 type SegmentMsg struct {
+	MsgId  uint32 // 消息编号，同一消息的分段相同
 	Amount uint16
 	Seq    uint16
 	Frame  []byte
@@ -32,10 +71,17 @@ type HandshakeReq struct {
 	ServiceId string   // 目标服务ID
 	ConnIds   []uint64 // 已注册连接编号
 	RouterIds []string // 已注册路由编号
+	// 可接收的最大帧长度，超过 LegacyMaxFrameSize 时对端可使用4字节长度帧（旧版本无该字段）
+	MaxFrameSize uint32
+	Version      uint16 // 协议版本（旧版本无该字段）
+	Capabilities uint64 // 支持的能力（旧版本无该字段）
 }
 
 type HandshakeRes struct {
-	Code uint16
+	Code         uint16
+	MaxFrameSize uint32 // 可接收的最大帧长度（旧版本无该字段）
+	Version      uint16 // 协议版本（旧版本无该字段）
+	Capabilities uint64 // 协商后的能力，为双方能力的交集（旧版本无该字段）
 }
 
 type RegisterSReq struct {
@@ -71,11 +117,35 @@ type HeartbeatSRes struct {
 type MessageSReq struct {
 	ConnId  uint64
 	Payload []byte
+	// 从连接读取时负载引用的复用缓存
+	buf *bufpool.Buffer
+}
+
+// Release
+//
+//	@Description: 转发完成后归还负载引用的读缓存，之后不可再访问负载
+//	@receiver req
+func (req *MessageSReq) Release() {
+	req.buf.Release()
+	req.buf = nil
+	req.Payload = nil
 }
 
 type MessageSRes struct {
 	ConnId  uint64
 	Payload []byte
+	// 从连接读取时负载引用的复用缓存
+	buf *bufpool.Buffer
+}
+
+// Release
+//
+//	@Description: 转发完成后归还负载引用的读缓存，之后不可再访问负载
+//	@receiver res
+func (res *MessageSRes) Release() {
+	res.buf.Release()
+	res.buf = nil
+	res.Payload = nil
 }
 
 type BroadcastSRes struct {
@@ -83,13 +153,66 @@ type BroadcastSRes struct {
 	Payload []byte
 }
 
+type BroadcastAllSRes struct {
+	Flags     uint8  // 过滤标识，见 BroadcastFlagService 等
+	AttrKey   string // 过滤属性名（BroadcastFlagAttribute）
+	AttrValue string // 过滤属性值（BroadcastFlagAttribute）
+	Payload   []byte
+}
+
+type SessionAttrSRes struct {
+	ConnId     uint64
+	Keys       []string // 设置的属性名
+	Values     []string // 设置的属性值，与 Keys 一一对应
+	DeleteKeys []string // 清除的属性名
+}
+
+type RebindSReq struct {
+	ConnId          uint64
+	TargetServiceId string // 目标服务实例ID
+	Code            uint16 // 换绑结果
+}
+
+type RebindSRes struct {
+	ConnId          uint64
+	TargetServiceId string // 目标服务实例ID
+	Payload         []byte // 以 RegisterSReq 转交给目标实例的数据
+}
+
+type JoinGroupSRes struct {
+	ConnId uint64
+	Group  string // 组名
+}
+
+type LeaveGroupSRes struct {
+	ConnId uint64
+	Group  string // 组名
+}
+
+type GroupBroadcastSRes struct {
+	Group   string // 组名
+	Payload []byte
+}
+
 type MessageRouter struct {
+	CorrelationId uint32 // 调用方关联id，非0时路由失败会回复 MessageRouterRes
 	RouterService string // 路由的服务
 	RouterType    int16  // 目标路由类型（0以上为自定义路由类型）
 	RouterId      string // 目标路由标识
 	Payload       []byte
 }
 
+// MessageRouterRes
+//
+//	@Description: 路由失败回复
+type MessageRouterRes struct {
+	Code          uint16 // 见 common.ErrCodeNoService 等
+	CorrelationId uint32 // 原路由消息的关联id
+	RouterService string
+	RouterType    int16
+	RouterId      string
+}
+
 type ServiceInstIReq struct {
 	ServiceName string // 服务名
 }
@@ -99,3 +222,44 @@ type ServiceInstIRes struct {
 	ServiceName    string
 	ServiceInstArr []string
 }
+
+// BatchEntry 批量帧中单个连接的消息
+type BatchEntry struct {
+	ConnId  uint64
+	Payload []byte
+}
+
+// BatchSReq 多个连接的 MessageSReq 合并为一帧
+type BatchSReq struct {
+	Entries []BatchEntry
+}
+
+// BatchSRes 多个连接的 MessageSRes 合并为一帧
+type BatchSRes struct {
+	Entries []BatchEntry
+}
+
+// MeshRelayReq
+//
+//	@Description: 网关间转发的服务消息，对端网关按来源服务实例处理且不再转发
+type MeshRelayReq struct {
+	Service   string // 消息来源服务名
+	ServiceId string // 消息来源服务实例ID
+	Payload   []byte // 以 ServerCodec 编码的服务消息（如 MessageRouter、GroupBroadcastSRes）
+}
+
+// MeshLookupReq
+//
+//	@Description: 查询会话是否在对端网关
+type MeshLookupReq struct {
+	Seq      uint32 // 查询序号，原样返回
+	RouterId string // 会话 RouterId（如uid）
+}
+
+// MeshLookupRes
+//
+//	@Description: 会话查询结果
+type MeshLookupRes struct {
+	Seq  uint32
+	Code uint16 // 会话在对端网关时为 common.ErrCodeSuccess，否则为 common.ErrCodeNoSession
+}
@@ -0,0 +1,152 @@
+package codec
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultReassembleMaxSize = DefaultMaxFrameSize
+	DefaultReassembleTimeout = 30 * time.Second
+)
+
+// NewReassembler
+//
+//	@Description: 构建分段消息重组器
+//	@param maxSize 单条消息重组后的最大长度
+//	@param timeout 未完成消息的超时时间
+//	@return *Reassembler
+func NewReassembler(maxSize int, timeout time.Duration) *Reassembler {
+	if maxSize <= 0 {
+		maxSize = DefaultReassembleMaxSize
+	}
+	if timeout <= 0 {
+		timeout = DefaultReassembleTimeout
+	}
+	return &Reassembler{
+		maxSize: maxSize,
+		timeout: timeout,
+		pending: make(map[uint32]*segmentBuffer),
+	}
+}
+
+type segmentBuffer struct {
+	amount   uint16
+	next     uint16
+	buf      []byte
+	deadline time.Time
+}
+
+// Reassembler
+//
+//	@Description: 按消息编号重组分段消息，不同消息的分段可交错到达
+type Reassembler struct {
+	maxSize int
+	timeout time.Duration
+
+	mu        sync.Mutex
+	pending   map[uint32]*segmentBuffer
+	nextSweep time.Time
+}
+
+// Push
+//
+//	@Description: 加入分段，消息完整时返回重组后的消息体，压缩后分段的消息解压后返回，解压后的长度同样不超过 maxSize
+//	@receiver reassembler
+//	@param msg
+//	@return []byte 未完整时为nil
+//	@return error 分段非法时返回错误，同时丢弃该消息已接收的分段
+func (reassembler *Reassembler) Push(msg *SegmentMsg) ([]byte, error) {
+	buf, err := reassembler.push(msg)
+	if err != nil || buf == nil || !IsCompressed(buf) {
+		return buf, err
+	}
+	raw, err := decompressBody(buf, reassembler.maxSize)
+	if err != nil {
+		return nil, fmt.Errorf("segment(%d) decompress error: %w", msg.MsgId, err)
+	}
+	return raw, nil
+}
+
+func (reassembler *Reassembler) push(msg *SegmentMsg) ([]byte, error) {
+	now := time.Now()
+	reassembler.mu.Lock()
+	defer reassembler.mu.Unlock()
+	if now.After(reassembler.nextSweep) {
+		reassembler.clearExpired(now)
+		reassembler.nextSweep = now.Add(reassembler.timeout)
+	}
+	sBuf := reassembler.pending[msg.MsgId]
+	if sBuf == nil {
+		if msg.Seq != 0 {
+			return nil, fmt.Errorf("segment(%d) lost head, seq:%d", msg.MsgId, msg.Seq)
+		}
+		if msg.Amount <= 0 {
+			return nil, fmt.Errorf("segment(%d) invalid amount:%d", msg.MsgId, msg.Amount)
+		}
+		sBuf = &segmentBuffer{
+			amount:   msg.Amount,
+			deadline: now.Add(reassembler.timeout),
+		}
+		reassembler.pending[msg.MsgId] = sBuf
+	} else if sBuf.amount != msg.Amount || sBuf.next != msg.Seq {
+		delete(reassembler.pending, msg.MsgId)
+		return nil, fmt.Errorf("segment(%d) out of order, amount:%d/%d, seq:%d/%d",
+			msg.MsgId, msg.Amount, sBuf.amount, msg.Seq, sBuf.next)
+	}
+	if len(sBuf.buf)+len(msg.Frame) > reassembler.maxSize {
+		delete(reassembler.pending, msg.MsgId)
+		return nil, fmt.Errorf("segment(%d) exceeds max size:%d", msg.MsgId, reassembler.maxSize)
+	}
+	sBuf.buf = append(sBuf.buf, msg.Frame...)
+	sBuf.next++
+	if sBuf.next < sBuf.amount {
+		return nil, nil
+	}
+	delete(reassembler.pending, msg.MsgId)
+	return sBuf.buf, nil
+}
+
+// ClearExpired
+//
+//	@Description: 清理超时未完成的消息
+//	@receiver reassembler
+//	@return int 清理数量
+func (reassembler *Reassembler) ClearExpired() int {
+	reassembler.mu.Lock()
+	defer reassembler.mu.Unlock()
+	return reassembler.clearExpired(time.Now())
+}
+
+func (reassembler *Reassembler) clearExpired(now time.Time) int {
+	count := 0
+	for msgId, sBuf := range reassembler.pending {
+		if now.After(sBuf.deadline) {
+			delete(reassembler.pending, msgId)
+			count++
+		}
+	}
+	return count
+}
+
+// Reset
+//
+//	@Description: 丢弃所有未完成的消息（如连接重建时）
+//	@receiver reassembler
+func (reassembler *Reassembler) Reset() {
+	reassembler.mu.Lock()
+	defer reassembler.mu.Unlock()
+	reassembler.pending = make(map[uint32]*segmentBuffer)
+}
+
+// Pending
+//
+//	@Description: 未完成的消息数量
+//	@receiver reassembler
+//	@return int
+func (reassembler *Reassembler) Pending() int {
+	reassembler.mu.Lock()
+	defer reassembler.mu.Unlock()
+	return len(reassembler.pending)
+}
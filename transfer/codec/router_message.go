@@ -1,7 +1,6 @@
 package codec
 
 import (
-	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -16,13 +15,17 @@ type RpcRReq struct {
 	SourceSrv string // 源请求服务名
 	SourceId  string // 源请求服务id
 	RPCId     uint32 // 源请求编号
-	Payload   []byte
+	// 要求响应携带处理实例id，为true时使用新类型编码，旧版本服务无法解析
+	WantServiceId bool
+	Payload       []byte
 }
 
 type RpcRRes struct {
-	Code    uint16
-	RPCId   uint32 // 源请求编号
-	Payload []byte
+	Code  uint16
+	RPCId uint32 // 源请求编号
+	// 处理请求的服务实例id，非空时使用新类型编码，仅应回复给 WantServiceId 的请求
+	ServiceId string
+	Payload   []byte
 }
 
 func NewRpcRReq(SourceSrv, SourceId string, rpcId uint32, payload []byte) *RpcRReq {
@@ -42,6 +45,10 @@ func NewRpcRRes(rpcId uint32, payload []byte) *RpcRRes {
 	}
 }
 
+// NewMessageRouter
+//
+//	@Description: 构建路由消息，网关会原样转发 Payload，目标实例未必协商了压缩等能力，
+//	msgCodec 不应开启帧压缩
 func NewMessageRouter(byteOrder binary.ByteOrder, routerService string,
 	routerType int16, routerId string, msgCodec netcodec.Codec, routerMessage any) (*MessageRouter, error) {
 	payload, err := msgCodec.Encode(routerMessage)
@@ -56,11 +63,14 @@ func NewMessageRouter(byteOrder binary.ByteOrder, routerService string,
 	}, nil
 }
 
-func encodeRouterMessage(byteOrder binary.ByteOrder, msg any) (buf []byte, err error) {
+func encodeRouterMessage(byteOrder binary.ByteOrder, alloc Allocator, msg any) (buf []byte, err error) {
 	switch req := msg.(type) {
 	case *RpcRReq:
-		buf = make([]byte, 1+2+len(req.SourceSrv)+2+len(req.SourceId)+4+len(req.Payload))
+		buf = alloc(1 + 2 + len(req.SourceSrv) + 2 + len(req.SourceId) + 4 + len(req.Payload))
 		buf[0] = TypeRPCRReq
+		if req.WantServiceId {
+			buf[0] = TypeRPCRReqI
+		}
 		left := buf[1:]
 		left, err = codec.WriteString(byteOrder, req.SourceSrv, left)
 		if err != nil {
@@ -77,14 +87,29 @@ func encodeRouterMessage(byteOrder binary.ByteOrder, msg any) (buf []byte, err e
 		copy(left, req.Payload)
 		return
 	case *RpcRRes:
-		buf = make([]byte, 1+2+4+len(req.Payload))
+		size := 1 + 2 + 4 + len(req.Payload)
+		if len(req.ServiceId) > 0 {
+			size += 2 + len(req.ServiceId)
+		}
+		buf = alloc(size)
 		buf[0] = TypeRPCRRes
 		left := buf[1:]
 		left, err = codec.WriteUint16(byteOrder, req.Code, left)
+		if err != nil {
+			return
+		}
 		left, err = codec.WriteUint32(byteOrder, req.RPCId, left)
 		if err != nil {
 			return
 		}
+		if len(req.ServiceId) > 0 {
+			// 仅在携带实例id时使用新类型，兼容旧版本服务
+			buf[0] = TypeRPCRResI
+			left, err = codec.WriteString(byteOrder, req.ServiceId, left)
+			if err != nil {
+				return
+			}
+		}
 		copy(left, req.Payload)
 		return
 	default:
@@ -93,14 +118,14 @@ func encodeRouterMessage(byteOrder binary.ByteOrder, msg any) (buf []byte, err e
 	}
 }
 
-func decodeRouterMessage(byteOrder binary.ByteOrder, in []byte) (any, error) {
+func decodeRouterMessage(byteOrder binary.ByteOrder, clone func([]byte) []byte, in []byte) (any, error) {
 	if len(in) < 1 {
 		return nil, io.ErrShortBuffer
 	}
 	msgType := in[0]
 	switch msgType {
-	case TypeRPCRReq:
-		res := &RpcRReq{}
+	case TypeRPCRReq, TypeRPCRReqI:
+		res := &RpcRReq{WantServiceId: msgType == TypeRPCRReqI}
 		left := in[1:]
 		err := error(nil)
 		if res.SourceSrv, left, err = codec.ReadString(byteOrder, left); err != nil {
@@ -112,9 +137,9 @@ func decodeRouterMessage(byteOrder binary.ByteOrder, in []byte) (any, error) {
 		if res.RPCId, left, err = codec.ReadUint32(byteOrder, left); err != nil {
 			return nil, err
 		}
-		res.Payload = bytes.Clone(left)
+		res.Payload = clone(left)
 		return res, nil
-	case TypeRPCRRes:
+	case TypeRPCRRes, TypeRPCRResI:
 		res := &RpcRRes{}
 		left := in[1:]
 		err := error(nil)
@@ -124,7 +149,12 @@ func decodeRouterMessage(byteOrder binary.ByteOrder, in []byte) (any, error) {
 		if res.RPCId, left, err = codec.ReadUint32(byteOrder, left); err != nil {
 			return nil, err
 		}
-		res.Payload = bytes.Clone(left)
+		if msgType == TypeRPCRResI {
+			if res.ServiceId, left, err = codec.ReadString(byteOrder, left); err != nil {
+				return nil, err
+			}
+		}
+		res.Payload = clone(left)
 		return res, nil
 	default:
 		return nil, fmt.Errorf("(transfer) decode invalid router message type:%d", msgType)
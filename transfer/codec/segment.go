@@ -1,50 +1,75 @@
 package codec
 
 import (
-	"bytes"
 	"encoding/binary"
 	"fmt"
 	"github.com/meow-pad/chinchilla/utils/codec"
 	"github.com/meow-pad/persian/frame/pnet"
 	"github.com/meow-pad/persian/utils/numeric"
 	"math"
+	"math/rand"
+	"sync/atomic"
 )
 
 const (
-	segmentHeadSize = 1 + 2 + 2 + 2
-	maxSegmentNum   = math.MaxUint16
+	// 旧版本分段头 | len(2) | type(1) | amount(2) | seq(2) |
+	segmentHeadSize = 2 + 1 + 2 + 2
+	// 带消息编号的分段头 | len(2) | type(1) | msgId(4) | amount(2) | seq(2) |
+	segmentIdHeadSize = segmentHeadSize + 4
+	maxSegmentNum     = math.MaxUint16
 )
 
-func MessageSegmentation(largeMsg []byte, maxLen int) (out []byte, err error) {
+var (
+	// 分段消息编号，随机起始以降低不同发送方之间的冲突
+	segmentMsgId atomic.Uint32
+)
+
+func init() {
+	segmentMsgId.Store(rand.Uint32())
+}
+
+// MessageSegmentation
+//
+//	@Description: 将超长消息拆分为多个带长度的分段帧
+//	@param largeMsg 已编码的消息体
+//	@param maxLen 单帧消息体最大长度
+//	@param withId 是否携带消息编号，仅在对端支持 CapSegmentId 时开启，否则使用旧版本分段头
+//	@return out
+//	@return err
+func MessageSegmentation(largeMsg []byte, maxLen int, withId bool) (out []byte, err error) {
 	msgLen := len(largeMsg)
-	segmentNum := msgLen/maxLen + 1
-	totalLen := msgLen + segmentNum*segmentHeadSize
-	segmentNum = totalLen / maxLen
-	if totalLen%maxLen != 0 {
-		segmentNum += 1
+	headSize := segmentHeadSize
+	msgId := uint32(0)
+	if withId {
+		headSize = segmentIdHeadSize
+		msgId = segmentMsgId.Add(1)
+	}
+	// 每个分段帧为 | len(2) | 分段头 | frame |，消息体不超过 maxLen
+	segmentFrameSize := maxLen + frameLengthSize - headSize
+	if segmentFrameSize <= 0 {
+		return nil, fmt.Errorf("invalid segment frame size:%d, maxLen:%d", segmentFrameSize, maxLen)
 	}
+	segmentNum := (msgLen + segmentFrameSize - 1) / segmentFrameSize
 	if segmentNum > maxSegmentNum {
 		return nil, pnet.ErrMessageTooLarge
 	}
-	segmentFrameSize := msgLen / segmentNum
-	if (segmentFrameSize + segmentHeadSize) > maxLen {
-		// ????
-		return nil, fmt.Errorf("invalid segment frame size:%d, maxLen:%d", segmentFrameSize, maxLen)
-	}
+	out = make([]byte, 0, msgLen+segmentNum*headSize)
 	left := largeMsg
 	leftLen := len(left)
 	for i := 0; leftLen > 0; i++ {
 		frameLen := numeric.Min[int](leftLen, segmentFrameSize)
 		sMsg := &SegmentMsg{
+			MsgId:  msgId,
 			Amount: uint16(segmentNum),
 			Seq:    uint16(i),
 			Frame:  left[:frameLen],
 		}
 		var sOut []byte
-		sOut, err = encodeSegmentMsg(MessageCodecByteOrder, sMsg)
+		sOut, err = encodeSegmentMsg(MessageCodecByteOrder, makeBytes, sMsg, withId)
 		if err != nil {
 			return
 		}
+		out = MessageCodecByteOrder.AppendUint16(out, uint16(len(sOut)))
 		out = append(out, sOut...)
 		left = left[frameLen:]
 		leftLen = len(left)
@@ -52,24 +77,56 @@ func MessageSegmentation(largeMsg []byte, maxLen int) (out []byte, err error) {
 	return
 }
 
-func encodeSegmentMsg(byteOrder binary.ByteOrder, msg *SegmentMsg) ([]byte, error) {
-	buf := make([]byte, len(msg.Frame)+2+2+1)
-	buf[0] = TypeSegment
-	byteOrder.PutUint16(buf[1:], msg.Amount)
-	byteOrder.PutUint16(buf[3:], msg.Seq)
-	copy(buf[5:], msg.Frame)
+// encodeSegmentMsg
+//
+//	@Description: 编码分段消息
+//	@param byteOrder
+//	@param alloc
+//	@param msg
+//	@param withId 是否携带消息编号（TypeSegmentM），否则为旧版本格式（TypeSegment）
+//	@return []byte
+//	@return error
+func encodeSegmentMsg(byteOrder binary.ByteOrder, alloc Allocator, msg *SegmentMsg, withId bool) ([]byte, error) {
+	if !withId {
+		buf := alloc(len(msg.Frame) + segmentHeadSize - frameLengthSize)
+		buf[0] = TypeSegment
+		byteOrder.PutUint16(buf[1:], msg.Amount)
+		byteOrder.PutUint16(buf[3:], msg.Seq)
+		copy(buf[5:], msg.Frame)
+		return buf, nil
+	}
+	buf := alloc(len(msg.Frame) + segmentIdHeadSize - frameLengthSize)
+	buf[0] = TypeSegmentM
+	byteOrder.PutUint32(buf[1:], msg.MsgId)
+	byteOrder.PutUint16(buf[5:], msg.Amount)
+	byteOrder.PutUint16(buf[7:], msg.Seq)
+	copy(buf[9:], msg.Frame)
 	return buf, nil
 }
 
-func decodeSegmentMsg(byteOrder binary.ByteOrder, buf []byte) (any, error) {
+// decodeSegmentMsg
+//
+//	@Description: 解码分段消息，旧版本格式的消息编号为0
+//	@param byteOrder
+//	@param clone
+//	@param buf 不含类型的消息体
+//	@param withId 是否携带消息编号
+//	@return any
+//	@return error
+func decodeSegmentMsg(byteOrder binary.ByteOrder, clone func([]byte) []byte, buf []byte, withId bool) (any, error) {
 	req := &SegmentMsg{}
 	err := error(nil)
+	if withId {
+		if req.MsgId, buf, err = codec.ReadUint32(byteOrder, buf); err != nil {
+			return nil, err
+		}
+	}
 	if req.Amount, buf, err = codec.ReadUint16(byteOrder, buf); err != nil {
 		return nil, err
 	}
 	if req.Seq, buf, err = codec.ReadUint16(byteOrder, buf); err != nil {
 		return nil, err
 	}
-	req.Frame = bytes.Clone(buf)
+	req.Frame = clone(buf)
 	return req, nil
 }
@@ -21,18 +21,41 @@ type ServerCodec struct {
 }
 
 func (sCodec *ServerCodec) Encode(msg any) ([]byte, error) {
+	return sCodec.EncodeWith(msg, makeBytes)
+}
+
+// EncodeWith
+//
+//	@Description: 使用指定分配器申请编码缓存（如带预留头部的复用缓存）
+//	@receiver sCodec
+//	@param msg
+//	@param alloc
+//	@return []byte
+//	@return error
+func (sCodec *ServerCodec) EncodeWith(msg any, alloc Allocator) ([]byte, error) {
 	switch sMsg := msg.(type) {
 	case *MessageSRes:
-		buf := make([]byte, len(sMsg.Payload)+8+1)
+		buf := alloc(len(sMsg.Payload) + 8 + 1)
 		buf[0] = TypeMessageS
 		sCodec.byteOrder.PutUint64(buf[1:], sMsg.ConnId)
 		copy(buf[9:], sMsg.Payload)
 		return buf, nil
 	case *MessageRouter:
-		buf := make([]byte, len(sMsg.Payload)+2+len(sMsg.RouterService)+2+len(sMsg.RouterId)+2+1)
+		size := len(sMsg.Payload) + 2 + len(sMsg.RouterService) + 2 + len(sMsg.RouterId) + 2 + 1
+		if sMsg.CorrelationId != 0 {
+			size += 4
+		}
+		buf := alloc(size)
 		buf[0] = TypeMessageRouter
 		left := buf[1:]
 		err := error(nil)
+		if sMsg.CorrelationId != 0 {
+			// 仅在需要回复时使用新类型，兼容旧网关
+			buf[0] = TypeMessageRouterC
+			if left, err = codec.WriteUint32(sCodec.byteOrder, sMsg.CorrelationId, left); err != nil {
+				return nil, err
+			}
+		}
 		if left, err = codec.WriteString(sCodec.byteOrder, sMsg.RouterService, left); err != nil {
 			return nil, err
 		}
@@ -45,7 +68,7 @@ func (sCodec *ServerCodec) Encode(msg any) ([]byte, error) {
 		copy(left, sMsg.Payload)
 		return buf, nil
 	case *BroadcastSRes:
-		buf := make([]byte, codec.Uint64ArrayLen(sMsg.ConnIds)+len(sMsg.Payload)+1)
+		buf := alloc(codec.Uint64ArrayLen(sMsg.ConnIds) + len(sMsg.Payload) + 1)
 		buf[0] = TypeBroadcastS
 		left := buf[1:]
 		err := error(nil)
@@ -54,8 +77,71 @@ func (sCodec *ServerCodec) Encode(msg any) ([]byte, error) {
 		}
 		copy(left, sMsg.Payload)
 		return buf, nil
+	case *BroadcastAllSRes:
+		buf := alloc(1 + 2 + len(sMsg.AttrKey) + 2 + len(sMsg.AttrValue) + len(sMsg.Payload) + 1)
+		buf[0] = TypeBroadcastAllS
+		buf[1] = sMsg.Flags
+		left := buf[2:]
+		err := error(nil)
+		if left, err = codec.WriteString(sCodec.byteOrder, sMsg.AttrKey, left); err != nil {
+			return nil, err
+		}
+		if left, err = codec.WriteString(sCodec.byteOrder, sMsg.AttrValue, left); err != nil {
+			return nil, err
+		}
+		copy(left, sMsg.Payload)
+		return buf, nil
+	case *SessionAttrSRes:
+		if len(sMsg.Keys) != len(sMsg.Values) {
+			return nil, errors.New("(transfer server) mismatched session attribute keys and values")
+		}
+		buf := alloc(8 + codec.StringArrayLen(sMsg.Keys) + codec.StringArrayLen(sMsg.Values) +
+			codec.StringArrayLen(sMsg.DeleteKeys) + 1)
+		buf[0] = TypeSessionAttrS
+		left := buf[1:]
+		err := error(nil)
+		if left, err = codec.WriteUint64(sCodec.byteOrder, sMsg.ConnId, left); err != nil {
+			return nil, err
+		}
+		if left, err = codec.WriteStringArray(sCodec.byteOrder, sMsg.Keys, left); err != nil {
+			return nil, err
+		}
+		if left, err = codec.WriteStringArray(sCodec.byteOrder, sMsg.Values, left); err != nil {
+			return nil, err
+		}
+		if left, err = codec.WriteStringArray(sCodec.byteOrder, sMsg.DeleteKeys, left); err != nil {
+			return nil, err
+		}
+		return buf, nil
+	case *RebindSRes:
+		buf := alloc(8 + 2 + len(sMsg.TargetServiceId) + len(sMsg.Payload) + 1)
+		buf[0] = TypeRebindS
+		left := buf[1:]
+		err := error(nil)
+		if left, err = codec.WriteUint64(sCodec.byteOrder, sMsg.ConnId, left); err != nil {
+			return nil, err
+		}
+		if left, err = codec.WriteString(sCodec.byteOrder, sMsg.TargetServiceId, left); err != nil {
+			return nil, err
+		}
+		copy(left, sMsg.Payload)
+		return buf, nil
+	case *JoinGroupSRes:
+		return encodeGroupMember(sCodec.byteOrder, alloc, TypeJoinGroupS, sMsg.ConnId, sMsg.Group)
+	case *LeaveGroupSRes:
+		return encodeGroupMember(sCodec.byteOrder, alloc, TypeLeaveGroupS, sMsg.ConnId, sMsg.Group)
+	case *GroupBroadcastSRes:
+		buf := alloc(2 + len(sMsg.Group) + len(sMsg.Payload) + 1)
+		buf[0] = TypeGroupBroadcastS
+		left := buf[1:]
+		err := error(nil)
+		if left, err = codec.WriteString(sCodec.byteOrder, sMsg.Group, left); err != nil {
+			return nil, err
+		}
+		copy(left, sMsg.Payload)
+		return buf, nil
 	case *RegisterSRes:
-		buf := make([]byte, len(sMsg.Payload)+8+2+2+len(sMsg.RouterId)+1)
+		buf := alloc(len(sMsg.Payload) + 8 + 2 + 2 + len(sMsg.RouterId) + 1)
 		buf[0] = TypeRegisterS
 		left := buf[1:]
 		err := error(nil)
@@ -72,7 +158,7 @@ func (sCodec *ServerCodec) Encode(msg any) ([]byte, error) {
 		return buf, nil
 	case *UnregisterSRes:
 		plog.Debug("encode UnregisterSRes", pfield.Uint64("connId", sMsg.ConnId), pfield.Stack("stack"))
-		buf := make([]byte, 8+1)
+		buf := alloc(8 + 1)
 		buf[0] = TypeUnregisterS
 		left := buf[1:]
 		err := error(nil)
@@ -82,18 +168,21 @@ func (sCodec *ServerCodec) Encode(msg any) ([]byte, error) {
 		return buf, nil
 	case *HeartbeatSRes:
 		//plog.Debug("encode HeartbeatSRes", pfield.Uint64("connId", sMsg.ConnId), pfield.Stack("stack"))
-		buf := make([]byte, len(sMsg.Payload)+8+1)
+		buf := alloc(len(sMsg.Payload) + 8 + 1)
 		buf[0] = TypeHeartbeatS
 		sCodec.byteOrder.PutUint64(buf[1:], sMsg.ConnId)
 		copy(buf[9:], sMsg.Payload)
 		return buf, nil
 	case *HandshakeRes:
-		buf := make([]byte, 2+1)
+		buf := alloc(2 + 4 + 2 + 8 + 1)
 		buf[0] = TypeHandshake
 		sCodec.byteOrder.PutUint16(buf[1:], sMsg.Code)
+		sCodec.byteOrder.PutUint32(buf[3:], sMsg.MaxFrameSize)
+		sCodec.byteOrder.PutUint16(buf[7:], sMsg.Version)
+		sCodec.byteOrder.PutUint64(buf[9:], sMsg.Capabilities)
 		return buf, nil
 	case *ServiceInstIReq:
-		buf := make([]byte, 2+len(sMsg.ServiceName)+1)
+		buf := alloc(2 + len(sMsg.ServiceName) + 1)
 		buf[0] = TypeServiceInstIReq
 		left := buf[1:]
 		err := error(nil)
@@ -101,18 +190,41 @@ func (sCodec *ServerCodec) Encode(msg any) ([]byte, error) {
 			return nil, err
 		}
 		return buf, nil
+	case *MeshLookupRes:
+		buf := alloc(1 + 4 + 2)
+		buf[0] = TypeMeshLookup
+		sCodec.byteOrder.PutUint32(buf[1:], sMsg.Seq)
+		sCodec.byteOrder.PutUint16(buf[5:], sMsg.Code)
+		return buf, nil
+	case *BatchSRes:
+		return encodeBatch(sCodec.byteOrder, alloc, TypeBatchS, sMsg.Entries)
 	case *SegmentMsg:
-		return encodeSegmentMsg(sCodec.byteOrder, sMsg)
+		return encodeSegmentMsg(sCodec.byteOrder, alloc, sMsg, true)
 	case *RpcRReq, *RpcRRes:
 		//// 这些消息不可能在server端编码
 		//return nil, errors.New("unsupported message in server encoder:" + reflect.TypeOf(msg).String())
-		return encodeRouterMessage(sCodec.byteOrder, msg)
+		return encodeRouterMessage(sCodec.byteOrder, alloc, msg)
 	default:
 		return nil, errors.New("(transfer server) encode invalid message type:" + reflect.TypeOf(msg).String())
 	}
 }
 
 func (sCodec *ServerCodec) Decode(in []byte) (any, error) {
+	return sCodec.decode(in, bytes.Clone)
+}
+
+// DecodeBorrowed
+//
+//	@Description: 解码但不拷贝负载，负载引用 in，仅在 in 的生命周期内有效
+//	@receiver sCodec
+//	@param in
+//	@return any
+//	@return error
+func (sCodec *ServerCodec) DecodeBorrowed(in []byte) (any, error) {
+	return sCodec.decode(in, borrowBytes)
+}
+
+func (sCodec *ServerCodec) decode(in []byte, clone func([]byte) []byte) (any, error) {
 	inLen := len(in)
 	if inLen < 1 {
 		return nil, io.ErrShortBuffer
@@ -126,10 +238,10 @@ func (sCodec *ServerCodec) Decode(in []byte) (any, error) {
 		if req.ConnId, left, err = codec.ReadUint64(sCodec.byteOrder, left); err != nil {
 			return nil, err
 		}
-		req.Payload = bytes.Clone(left)
+		req.Payload = clone(left)
 		return req, nil
-	case TypeRPCRReq, TypeRPCRRes:
-		return decodeRouterMessage(sCodec.byteOrder, in)
+	case TypeRPCRReq, TypeRPCRRes, TypeRPCRReqI, TypeRPCRResI:
+		return decodeRouterMessage(sCodec.byteOrder, clone, in)
 	case TypeRegisterS:
 		req := &RegisterSReq{}
 		left := in[1:]
@@ -137,7 +249,7 @@ func (sCodec *ServerCodec) Decode(in []byte) (any, error) {
 		if req.ConnId, left, err = codec.ReadUint64(sCodec.byteOrder, left); err != nil {
 			return nil, err
 		}
-		req.Payload = bytes.Clone(left)
+		req.Payload = clone(left)
 		return req, nil
 	case TypeUnregisterS:
 		req := &UnregisterSReq{}
@@ -154,7 +266,21 @@ func (sCodec *ServerCodec) Decode(in []byte) (any, error) {
 		if req.ConnId, left, err = codec.ReadUint64(sCodec.byteOrder, left); err != nil {
 			return nil, err
 		}
-		req.Payload = bytes.Clone(left)
+		req.Payload = clone(left)
+		return req, nil
+	case TypeRebindS:
+		req := &RebindSReq{}
+		left := in[1:]
+		err := error(nil)
+		if req.ConnId, left, err = codec.ReadUint64(sCodec.byteOrder, left); err != nil {
+			return nil, err
+		}
+		if req.TargetServiceId, left, err = codec.ReadString(sCodec.byteOrder, left); err != nil {
+			return nil, err
+		}
+		if req.Code, left, err = codec.ReadUint16(sCodec.byteOrder, left); err != nil {
+			return nil, err
+		}
 		return req, nil
 	case TypeHandshake:
 		req := &HandshakeReq{}
@@ -178,6 +304,20 @@ func (sCodec *ServerCodec) Decode(in []byte) (any, error) {
 		if req.RouterIds, left, err = codec.ReadStringArray(sCodec.byteOrder, left); err != nil {
 			return nil, err
 		}
+		// 兼容旧版本
+		if len(left) >= 4 {
+			if req.MaxFrameSize, left, err = codec.ReadUint32(sCodec.byteOrder, left); err != nil {
+				return nil, err
+			}
+		}
+		if len(left) >= 2+8 {
+			if req.Version, left, err = codec.ReadUint16(sCodec.byteOrder, left); err != nil {
+				return nil, err
+			}
+			if req.Capabilities, left, err = codec.ReadUint64(sCodec.byteOrder, left); err != nil {
+				return nil, err
+			}
+		}
 		return req, nil
 	case TypeServiceInstIReS:
 		res := &ServiceInstIRes{}
@@ -193,12 +333,82 @@ func (sCodec *ServerCodec) Decode(in []byte) (any, error) {
 			return nil, err
 		}
 		return res, nil
+	case TypeCompressed:
+		// 由 FrameCodec 或 Reassembler 按各自的长度限制解压
+		return nil, ErrUnexpectedCompressed
+	case TypeBatchS:
+		req := &BatchSReq{}
+		err := error(nil)
+		if req.Entries, err = decodeBatch(sCodec.byteOrder, clone, in[1:]); err != nil {
+			return nil, err
+		}
+		return req, nil
 	case TypeSegment:
-		return decodeSegmentMsg(sCodec.byteOrder, in[1:])
-	case TypeMessageRouter:
+		return decodeSegmentMsg(sCodec.byteOrder, clone, in[1:], false)
+	case TypeSegmentM:
+		return decodeSegmentMsg(sCodec.byteOrder, clone, in[1:], true)
+	case TypeMessageRouterRes:
+		res := &MessageRouterRes{}
+		left := in[1:]
+		err := error(nil)
+		if res.Code, left, err = codec.ReadUint16(sCodec.byteOrder, left); err != nil {
+			return nil, err
+		}
+		if res.CorrelationId, left, err = codec.ReadUint32(sCodec.byteOrder, left); err != nil {
+			return nil, err
+		}
+		if res.RouterService, left, err = codec.ReadString(sCodec.byteOrder, left); err != nil {
+			return nil, err
+		}
+		if res.RouterType, left, err = codec.ReadInt16(sCodec.byteOrder, left); err != nil {
+			return nil, err
+		}
+		if res.RouterId, _, err = codec.ReadString(sCodec.byteOrder, left); err != nil {
+			return nil, err
+		}
+		return res, nil
+	case TypeMeshRelay:
+		req := &MeshRelayReq{}
+		left := in[1:]
+		err := error(nil)
+		if req.Service, left, err = codec.ReadString(sCodec.byteOrder, left); err != nil {
+			return nil, err
+		}
+		if req.ServiceId, left, err = codec.ReadString(sCodec.byteOrder, left); err != nil {
+			return nil, err
+		}
+		req.Payload = clone(left)
+		return req, nil
+	case TypeMeshLookup:
+		req := &MeshLookupReq{}
+		left := in[1:]
+		err := error(nil)
+		if req.Seq, left, err = codec.ReadUint32(sCodec.byteOrder, left); err != nil {
+			return nil, err
+		}
+		if req.RouterId, _, err = codec.ReadString(sCodec.byteOrder, left); err != nil {
+			return nil, err
+		}
+		return req, nil
+	case TypeMessageRouter, TypeMessageRouterC, TypeJoinGroupS, TypeLeaveGroupS, TypeGroupBroadcastS,
+		TypeBroadcastAllS, TypeSessionAttrS:
 		// 这些消息不可能在server端解码
 		return nil, fmt.Errorf("unsupported message in server decoder:%d", msgType)
 	default:
 		return nil, fmt.Errorf("(transfer server) decode invalid message type:%d", msgType)
 	}
 }
+
+func encodeGroupMember(byteOrder binary.ByteOrder, alloc Allocator, msgType uint8, connId uint64, group string) ([]byte, error) {
+	buf := alloc(8 + 2 + len(group) + 1)
+	buf[0] = msgType
+	left := buf[1:]
+	err := error(nil)
+	if left, err = codec.WriteUint64(byteOrder, connId, left); err != nil {
+		return nil, err
+	}
+	if left, err = codec.WriteString(byteOrder, group, left); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
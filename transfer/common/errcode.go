@@ -10,4 +10,9 @@ const (
 	ErrCodeAuthFailed
 	ErrCodeRouteError
 	ErrCodeNoService
+	ErrCodeNoSession
+	ErrCodeNotBound
+	ErrCodeNoInstance       // 路由找不到目标实例
+	ErrCodeInstanceDisabled // 路由目标实例不可用
+	ErrCodeSendFailed       // 转发至目标实例失败
 )
@@ -4,4 +4,9 @@ import "errors"
 
 var (
 	ErrEmptyInstances = errors.New("empty service infoArr")
+	// 路由错误，实例不可用时为 service.ErrDisabledService 等
+	ErrNoInstance       = errors.New("no route instance")
+	ErrUnknownRouteType = errors.New("unknown route type")
+	ErrNoSession        = errors.New("no route session")
+	ErrNotBound         = errors.New("route session not bound to service")
 )
@@ -0,0 +1,132 @@
+package transfer
+
+import (
+	"sync"
+)
+
+func NewGroupManager() *GroupManager {
+	return &GroupManager{
+		groups:     make(map[string]map[uint64]struct{}),
+		connGroups: make(map[uint64]map[string]struct{}),
+	}
+}
+
+// GroupManager
+//
+//	@Description: 网关侧会话分组（房间）管理
+type GroupManager struct {
+	mu         sync.RWMutex
+	groups     map[string]map[uint64]struct{} // 组 -> 连接
+	connGroups map[uint64]map[string]struct{} // 连接 -> 组
+}
+
+// Join
+//
+//	@Description: 连接加入组
+//	@receiver manager
+//	@param group
+//	@param connId
+func (manager *GroupManager) Join(group string, connId uint64) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	members := manager.groups[group]
+	if members == nil {
+		members = make(map[uint64]struct{})
+		manager.groups[group] = members
+	}
+	members[connId] = struct{}{}
+	groups := manager.connGroups[connId]
+	if groups == nil {
+		groups = make(map[string]struct{})
+		manager.connGroups[connId] = groups
+	}
+	groups[group] = struct{}{}
+}
+
+// Leave
+//
+//	@Description: 连接离开组
+//	@receiver manager
+//	@param group
+//	@param connId
+func (manager *GroupManager) Leave(group string, connId uint64) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	manager.leave(group, connId)
+	groups := manager.connGroups[connId]
+	if groups != nil {
+		delete(groups, group)
+		if len(groups) <= 0 {
+			delete(manager.connGroups, connId)
+		}
+	}
+}
+
+// LeaveAll
+//
+//	@Description: 连接离开所有组（会话关闭时）
+//	@receiver manager
+//	@param connId
+func (manager *GroupManager) LeaveAll(connId uint64) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	groups := manager.connGroups[connId]
+	if groups == nil {
+		return
+	}
+	for group := range groups {
+		manager.leave(group, connId)
+	}
+	delete(manager.connGroups, connId)
+}
+
+func (manager *GroupManager) leave(group string, connId uint64) {
+	members := manager.groups[group]
+	if members == nil {
+		return
+	}
+	delete(members, connId)
+	if len(members) <= 0 {
+		delete(manager.groups, group)
+	}
+}
+
+// Members
+//
+//	@Description: 组内连接快照
+//	@receiver manager
+//	@param group
+//	@return []uint64
+func (manager *GroupManager) Members(group string) []uint64 {
+	manager.mu.RLock()
+	defer manager.mu.RUnlock()
+	members := manager.groups[group]
+	if len(members) <= 0 {
+		return nil
+	}
+	connIds := make([]uint64, 0, len(members))
+	for connId := range members {
+		connIds = append(connIds, connId)
+	}
+	return connIds
+}
+
+// Groups
+//
+//	@Description: 连接所在的组
+//	@receiver manager
+//	@param connId
+//	@return []string
+func (manager *GroupManager) Groups(connId uint64) []string {
+	manager.mu.RLock()
+	defer manager.mu.RUnlock()
+	groups := manager.connGroups[connId]
+	if len(groups) <= 0 {
+		return nil
+	}
+	result := make([]string, 0, len(groups))
+	for group := range groups {
+		result = append(result, group)
+	}
+	return result
+}
@@ -0,0 +1,107 @@
+package transfer
+
+import (
+	tcodec "github.com/meow-pad/chinchilla/transfer/codec"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestGroupManager_JoinLeave(t *testing.T) {
+	should := require.New(t)
+	manager := NewGroupManager()
+	manager.Join("room1", 1)
+	manager.Join("room1", 2)
+	manager.Join("room2", 1)
+	should.ElementsMatch([]uint64{1, 2}, manager.Members("room1"))
+	should.ElementsMatch([]string{"room1", "room2"}, manager.Groups(1))
+
+	manager.Leave("room1", 1)
+	should.ElementsMatch([]uint64{2}, manager.Members("room1"))
+	should.ElementsMatch([]string{"room2"}, manager.Groups(1))
+	// 重复离开及离开不存在的组
+	manager.Leave("room1", 1)
+	manager.Leave("room3", 1)
+	should.ElementsMatch([]uint64{2}, manager.Members("room1"))
+
+	manager.Leave("room1", 2)
+	should.Nil(manager.Members("room1"))
+	should.Nil(manager.Groups(2))
+	should.NotContains(manager.groups, "room1")
+	should.NotContains(manager.connGroups, uint64(2))
+}
+
+func TestGroupManager_LeaveAll(t *testing.T) {
+	should := require.New(t)
+	manager := NewGroupManager()
+	manager.Join("room1", 1)
+	manager.Join("room2", 1)
+	manager.Join("room2", 2)
+	// 会话关闭
+	manager.LeaveAll(1)
+	should.Nil(manager.Groups(1))
+	should.Nil(manager.Members("room1"))
+	should.ElementsMatch([]uint64{2}, manager.Members("room2"))
+	should.NotContains(manager.groups, "room1")
+	should.NotContains(manager.connGroups, uint64(1))
+	// 未加入任何组
+	manager.LeaveAll(3)
+	should.ElementsMatch([]uint64{2}, manager.Members("room2"))
+}
+
+func TestGroupManager_Concurrent(t *testing.T) {
+	should := require.New(t)
+	manager := NewGroupManager()
+	const (
+		connNum  = 64
+		groupNum = 8
+	)
+	var wg sync.WaitGroup
+	for i := 0; i < connNum; i++ {
+		wg.Add(1)
+		go func(connId uint64) {
+			defer wg.Done()
+			for j := 0; j < groupNum; j++ {
+				group := "room" + strconv.Itoa(j)
+				manager.Join(group, connId)
+				_ = manager.Members(group)
+				if connId%2 == 0 {
+					manager.Leave(group, connId)
+				}
+			}
+			if connId%4 == 1 {
+				manager.LeaveAll(connId)
+			}
+		}(uint64(i))
+	}
+	wg.Wait()
+	for j := 0; j < groupNum; j++ {
+		members := manager.Members("room" + strconv.Itoa(j))
+		should.Len(members, connNum/4)
+		for _, connId := range members {
+			should.Equal(uint64(3), connId%4)
+		}
+	}
+	for i := 0; i < connNum; i++ {
+		if i%4 == 3 {
+			should.Len(manager.Groups(uint64(i)), groupNum)
+		} else {
+			should.Nil(manager.Groups(uint64(i)))
+		}
+	}
+}
+
+func TestGroupManager_BroadcastEmptyGroup(t *testing.T) {
+	should := require.New(t)
+	groupMgr := NewGroupManager()
+	groupMgr.Join("room1", 1)
+	groupMgr.Leave("room1", 1)
+	gListener := &listener{manager: &Manager{transfer: &Transfer{groupMgr: groupMgr}}}
+	// 空组及不存在的组不分发消息
+	should.NotPanics(func() {
+		gListener.handleGroupBroadcastRes(&tcodec.GroupBroadcastSRes{Group: "room1", Payload: []byte{1}})
+		gListener.handleGroupBroadcastRes(&tcodec.GroupBroadcastSRes{Group: "missing", Payload: []byte{1}})
+	})
+	should.Nil(groupMgr.Members("missing"))
+}
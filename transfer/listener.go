@@ -1,22 +1,28 @@
 package transfer
 
 import (
+	"encoding/binary"
+	"errors"
 	rcodec "github.com/meow-pad/chinchilla/receiver/codec"
 	"github.com/meow-pad/chinchilla/receiver/context"
 	tcodec "github.com/meow-pad/chinchilla/transfer/codec"
 	"github.com/meow-pad/chinchilla/transfer/common"
+	"github.com/meow-pad/chinchilla/transfer/router"
 	"github.com/meow-pad/chinchilla/transfer/service"
 	"github.com/meow-pad/persian/frame/plog"
 	"github.com/meow-pad/persian/frame/plog/pfield"
 	"github.com/meow-pad/persian/frame/pnet/tcp/session"
 	"github.com/meow-pad/persian/utils/coding"
 	"github.com/meow-pad/persian/utils/worker"
+	"hash/fnv"
 	"reflect"
 )
 
 type listener struct {
 	manager       *Manager
+	service       service.Service // 消息来源服务实例
 	handleMessage func(session session.Session, msg any)
+	relayed       bool // 消息由其它网关经组网转发，不再转发
 }
 
 func (listener *listener) OnOpened(session session.Session) {
@@ -80,6 +86,7 @@ func getSessionFromGoLocal(local *worker.GoroutineLocal, connId uint64) session.
 
 func (listener *listener) handleMessageRes(res *tcodec.MessageSRes) {
 	listener.manager.transfer.Forward(int64(res.ConnId), func(local *worker.GoroutineLocal) {
+		defer releaseForwarded(res)
 		plog.Debug("(transfer) client forward MessageSRes", pfield.Uint64("conn", res.ConnId))
 		sess := getSessionFromGoLocal(local, res.ConnId)
 		if sess == nil {
@@ -87,10 +94,28 @@ func (listener *listener) handleMessageRes(res *tcodec.MessageSRes) {
 		}
 		rRes := &rcodec.MessageRes{}
 		rRes.Payload = res.Payload
-		sess.SendMessage(rRes)
+		context.SendMessage(sess, rRes)
 	})
 }
 
+// releaseForwarded
+//
+//	@Description: 转发完成（发送时已编码）后归还负载引用的读缓存；
+//	调试级别时接收端在写出完成后才记录消息日志，仍会引用负载，此时不归还
+//	@param res
+func releaseForwarded(res *tcodec.MessageSRes) {
+	if plog.LoggerLevel() == plog.DebugLevel {
+		return
+	}
+	res.Release()
+}
+
+func (listener *listener) handleBatchRes(res *tcodec.BatchSRes) {
+	for _, entry := range res.Entries {
+		listener.handleMessageRes(&tcodec.MessageSRes{ConnId: entry.ConnId, Payload: entry.Payload})
+	}
+}
+
 func (listener *listener) handleBroadcastRes(res *tcodec.BroadcastSRes) {
 	for _, connId := range res.ConnIds {
 		listener.manager.transfer.Forward(int64(connId), func(local *worker.GoroutineLocal) {
@@ -101,9 +126,178 @@ func (listener *listener) handleBroadcastRes(res *tcodec.BroadcastSRes) {
 			}
 			rRes := &rcodec.MessageRes{}
 			rRes.Payload = res.Payload
-			sess.SendMessage(rRes)
+			context.SendMessage(sess, rRes)
+		})
+	}
+}
+
+func (listener *listener) handleBroadcastAllRes(res *tcodec.BroadcastAllSRes) {
+	plog.Debug("(transfer) client forward BroadcastAllSRes", pfield.Uint8("flags", res.Flags))
+	srvName := listener.manager.service
+	srvId := listener.service.Info().ServiceId()
+	listener.manager.transfer.ForwardAll(func(local *worker.GoroutineLocal) {
+		local.Range(func(_, val any) bool {
+			sess, _ := val.(session.Session)
+			if sess == nil || sess.IsClosed() {
+				return false
+			}
+			if res.Flags != 0 {
+				senderCtx, _ := sess.Context().(context.SenderContext)
+				if senderCtx == nil {
+					return false
+				}
+				if res.Flags&tcodec.BroadcastFlagRegistered != 0 && !senderCtx.IsRegistered() {
+					return false
+				}
+				if res.Flags&tcodec.BroadcastFlagAttribute != 0 {
+					if value, ok := senderCtx.GetAttribute(res.AttrKey); !ok || value != res.AttrValue {
+						return false
+					}
+				}
+				if res.Flags&tcodec.BroadcastFlagService != 0 {
+					srv := senderCtx.GetService(srvName)
+					if srv == nil || srv.Info().ServiceId() != srvId {
+						return false
+					}
+				}
+			}
+			rRes := &rcodec.MessageRes{}
+			rRes.Payload = res.Payload
+			context.SendMessage(sess, rRes)
+			return false
+		})
+	})
+	listener.relayBroadcast(res)
+}
+
+func (listener *listener) handleSessionAttrRes(res *tcodec.SessionAttrSRes) {
+	listener.manager.transfer.Forward(int64(res.ConnId), func(local *worker.GoroutineLocal) {
+		plog.Debug("(transfer) client forward SessionAttrSRes", pfield.Uint64("conn", res.ConnId))
+		sess := getSessionFromGoLocal(local, res.ConnId)
+		if sess == nil {
+			return
+		}
+		senderCtx, _ := sess.Context().(context.SenderContext)
+		if senderCtx == nil {
+			plog.Error("invalid serverSession context")
+			return
+		}
+		for _, key := range res.DeleteKeys {
+			senderCtx.DeleteAttribute(key)
+		}
+		for i, key := range res.Keys {
+			senderCtx.SetAttribute(key, res.Values[i])
+		}
+	})
+}
+
+func (listener *listener) handleRebindRes(session session.Session, res *tcodec.RebindSRes) {
+	listener.manager.transfer.Forward(int64(res.ConnId), func(local *worker.GoroutineLocal) {
+		plog.Debug("(transfer) client forward RebindSRes",
+			pfield.Uint64("conn", res.ConnId), pfield.String("target", res.TargetServiceId))
+		code := listener.rebind(local, res)
+		session.SendMessage(&tcodec.RebindSReq{
+			ConnId:          res.ConnId,
+			TargetServiceId: res.TargetServiceId,
+			Code:            code,
+		})
+	})
+}
+
+// rebind
+//
+//	@Description: 将连接绑定的服务实例换为目标实例
+//	@receiver listener
+//	@param local
+//	@param res
+//	@return uint16 结果码
+func (listener *listener) rebind(local *worker.GoroutineLocal, res *tcodec.RebindSRes) uint16 {
+	sess := getSessionFromGoLocal(local, res.ConnId)
+	if sess == nil || sess.IsClosed() {
+		return common.ErrCodeNoSession
+	}
+	senderCtx, _ := sess.Context().(context.SenderContext)
+	if senderCtx == nil {
+		plog.Error("invalid serverSession context")
+		return common.ErrCodeNoSession
+	}
+	srvName := listener.manager.service
+	curSrv := senderCtx.GetService(srvName)
+	if curSrv == nil || curSrv.Info().ServiceId() != listener.service.Info().ServiceId() {
+		// 仅当前绑定的实例可发起换绑
+		return common.ErrCodeNotBound
+	}
+	if res.TargetServiceId == curSrv.Info().ServiceId() {
+		return common.ErrCodeSuccess
+	}
+	target, _ := listener.manager.getOpenService(res.TargetServiceId)
+	if target == nil || !target.IsEnable() {
+		return common.ErrCodeInvalidServiceId
+	}
+	senderCtx.SetService(srvName, target)
+	if err := target.SendMessage(&tcodec.RegisterSReq{
+		ConnId:  res.ConnId,
+		Payload: res.Payload,
+	}); err != nil {
+		plog.Error("(transfer) send rebind message to service error:",
+			pfield.String("target", res.TargetServiceId), pfield.Error(err))
+		// 回滚
+		senderCtx.SetService(srvName, curSrv)
+		return common.ErrCodeInnerError
+	}
+	return common.ErrCodeSuccess
+}
+
+func (listener *listener) handleJoinGroupRes(res *tcodec.JoinGroupSRes) {
+	listener.manager.transfer.Forward(int64(res.ConnId), func(local *worker.GoroutineLocal) {
+		plog.Debug("(transfer) client forward JoinGroupSRes",
+			pfield.Uint64("conn", res.ConnId), pfield.String("group", res.Group))
+		// 在连接所在的协程中处理，保证与会话关闭的顺序
+		sess := getSessionFromGoLocal(local, res.ConnId)
+		if sess == nil || sess.IsClosed() {
+			return
+		}
+		listener.manager.transfer.groupMgr.Join(res.Group, res.ConnId)
+	})
+}
+
+func (listener *listener) handleLeaveGroupRes(res *tcodec.LeaveGroupSRes) {
+	listener.manager.transfer.Forward(int64(res.ConnId), func(local *worker.GoroutineLocal) {
+		plog.Debug("(transfer) client forward LeaveGroupSRes",
+			pfield.Uint64("conn", res.ConnId), pfield.String("group", res.Group))
+		listener.manager.transfer.groupMgr.Leave(res.Group, res.ConnId)
+	})
+}
+
+func (listener *listener) handleGroupBroadcastRes(res *tcodec.GroupBroadcastSRes) {
+	connIds := listener.manager.transfer.groupMgr.Members(res.Group)
+	for _, connId := range connIds {
+		listener.manager.transfer.Forward(int64(connId), func(local *worker.GoroutineLocal) {
+			sess := getSessionFromGoLocal(local, connId)
+			if sess == nil {
+				return
+			}
+			rRes := &rcodec.MessageRes{}
+			rRes.Payload = res.Payload
+			context.SendMessage(sess, rRes)
 		})
 	}
+	listener.relayBroadcast(res)
+}
+
+// relayBroadcast
+//
+//	@Description: 开启 MeshRelayBroadcast 时将广播转发至其它网关
+//	@receiver listener
+//	@param res
+func (listener *listener) relayBroadcast(res any) {
+	transfer := listener.manager.transfer
+	if listener.relayed || transfer.mesh == nil || !transfer.Options.MeshRelayBroadcast {
+		return
+	}
+	if err := transfer.mesh.Relay(listener.manager.service, listener.service.Info().ServiceId(), res); err != nil {
+		plog.Error("(transfer) relay broadcast error:", pfield.Error(err))
+	}
 }
 
 func (listener *listener) handleRegisterRes(res *tcodec.RegisterSRes) {
@@ -123,12 +317,16 @@ func (listener *listener) handleRegisterRes(res *tcodec.RegisterSRes) {
 					plog.Error("invalid serverSession context")
 				} else {
 					senderCtx.SetRegistered(true)
+					if len(res.RouterId) > 0 {
+						senderCtx.SetAttribute(context.AttrRouterId, res.RouterId)
+						listener.manager.transfer.sessionIndex.Bind(res.RouterId, res.ConnId)
+					}
 				}
 			} // end of else
 		} // end of if
 		rRes := &rcodec.MessageRes{}
 		rRes.Payload = res.Payload
-		sess.SendMessage(rRes)
+		context.SendMessage(sess, rRes)
 	})
 }
 
@@ -144,6 +342,7 @@ func (listener *listener) handleUnregisterRes(res *tcodec.UnregisterSRes) {
 			plog.Error("(transfer) client close serverSession error:", pfield.Error(err))
 		} else {
 			local.Remove(res.ConnId)
+			listener.manager.transfer.sessionIndex.Unbind(res.ConnId)
 		}
 	})
 }
@@ -158,13 +357,13 @@ func (listener *listener) handleHeartbeatRes(res *tcodec.HeartbeatSRes) {
 		plog.Debug("(transfer) client send HeartbeatRes", pfield.Uint64("connId", res.ConnId))
 		rRes := &rcodec.HeartbeatRes{}
 		rRes.Payload = res.Payload
-		sess.SendMessage(rRes)
+		context.SendMessage(sess, rRes)
 	})
 }
 
 func (listener *listener) handleMessageRouter(session session.Session, res *tcodec.MessageRouter) {
-	// 注意，这里提交到池子，会导致消息处理顺序变得无序，有要求时需要修改
-	err := listener.manager.transfer.GoPool.Submit(func() {
+	// 默认按（来源连接，路由目标）保序，开启 RouterMessageUnordered 时无序
+	err := listener.manager.transfer.SubmitRoute(routeKey(session, res), func() {
 		//plog.Debug("router message:", pfield.Int16("routerType", res.RouterType),
 		//	pfield.String("routerId", res.RouterId))
 		defer coding.CatchPanicError("route message error:", func() {},
@@ -175,15 +374,20 @@ func (listener *listener) handleMessageRouter(session session.Session, res *tcod
 		srvManager := listener.manager.transfer.GetServiceManager(res.RouterService)
 		if srvManager != nil {
 			err := srvManager.Route(res.RouterType, res.RouterId, res.Payload)
+			if err != nil {
+				err = listener.routeViaMesh(session, res, err)
+			}
 			if err != nil {
 				plog.Error("route message error:",
 					pfield.String("routerService", res.RouterService),
 					pfield.Int16("routerType", res.RouterType),
 					pfield.String("routerId", res.RouterId),
 					pfield.Any("error", err))
+				listener.replyRouteFailure(session, res, routeErrCode(err))
 			}
 		} else {
 			plog.Warn("(transfer) unknown router service", pfield.String("routerSrv", res.RouterService))
+			listener.replyRouteFailure(session, res, common.ErrCodeNoService)
 		}
 	})
 	if err != nil {
@@ -192,6 +396,87 @@ func (listener *listener) handleMessageRouter(session session.Session, res *tcod
 			pfield.Int16("routerType", res.RouterType),
 			pfield.String("routerId", res.RouterId),
 			pfield.Error(err))
+		listener.replyRouteFailure(session, res, common.ErrCodeInnerError)
+	}
+}
+
+// routeViaMesh
+//
+//	@Description: 用户路由的会话不在本网关时，经组网转发至会话所在的网关，不阻塞当前路由协程
+//	@receiver listener
+//	@param session 消息来源会话，异步转发失败时回复路由失败
+//	@param res
+//	@param err 本网关路由的错误
+//	@return error 无法转发时返回原错误
+func (listener *listener) routeViaMesh(session session.Session, res *tcodec.MessageRouter, err error) error {
+	mesh := listener.manager.transfer.mesh
+	if listener.relayed || mesh == nil || !errors.Is(err, common.ErrNoSession) {
+		return err
+	}
+	if res.RouterType != router.RouteTypeUser && res.RouterType != router.RouteTypeUserClient {
+		return err
+	}
+	return mesh.RouteUser(listener.manager.service, listener.service.Info().ServiceId(), res, func(err error) {
+		plog.Error("route message via mesh error:",
+			pfield.String("routerService", res.RouterService),
+			pfield.Int16("routerType", res.RouterType),
+			pfield.String("routerId", res.RouterId),
+			pfield.Any("error", err))
+		listener.replyRouteFailure(session, res, routeErrCode(err))
+	})
+}
+
+// replyRouteFailure
+//
+//	@Description: 路由消息带有关联id时，向发送方回复路由失败
+//	@receiver listener
+//	@param session
+//	@param res
+//	@param code
+func (listener *listener) replyRouteFailure(session session.Session, res *tcodec.MessageRouter, code uint16) {
+	if res.CorrelationId == 0 {
+		return
+	}
+	session.SendMessage(&tcodec.MessageRouterRes{
+		Code:          code,
+		CorrelationId: res.CorrelationId,
+		RouterService: res.RouterService,
+		RouterType:    res.RouterType,
+		RouterId:      res.RouterId,
+	})
+}
+
+// routeKey
+//
+//	@Description: 路由保序键，来源连接与路由目标相同的消息顺序执行
+//	@param session
+//	@param res
+//	@return uint32
+func routeKey(session session.Session, res *tcodec.MessageRouter) uint32 {
+	hash := fnv.New32a()
+	var head [8 + 2]byte
+	binary.BigEndian.PutUint64(head[:], session.Id())
+	binary.BigEndian.PutUint16(head[8:], uint16(res.RouterType))
+	_, _ = hash.Write(head[:])
+	_, _ = hash.Write([]byte(res.RouterService))
+	_, _ = hash.Write([]byte(res.RouterId))
+	return hash.Sum32()
+}
+
+func routeErrCode(err error) uint16 {
+	switch {
+	case errors.Is(err, common.ErrNoInstance):
+		return common.ErrCodeNoInstance
+	case errors.Is(err, common.ErrNoSession):
+		return common.ErrCodeNoSession
+	case errors.Is(err, common.ErrNotBound):
+		return common.ErrCodeNotBound
+	case errors.Is(err, service.ErrDisabledService), errors.Is(err, service.ErrStoppedInstance):
+		return common.ErrCodeInstanceDisabled
+	case errors.Is(err, common.ErrUnknownRouteType):
+		return common.ErrCodeRouteError
+	default:
+		return common.ErrCodeSendFailed
 	}
 }
 
@@ -221,11 +506,13 @@ func (listener *listener) handleServiceInstIReq(session session.Session, iReq *t
 //
 //	@Description: 构建 localListener
 //	@param manager
+//	@param local
 //	@return *localListener
-func newLocalListener(manager *Manager) *localListener {
+func newLocalListener(manager *Manager, local *Local) *localListener {
 	lListener := &localListener{}
 	lListener.listener = &listener{
 		manager:       manager,
+		service:       local,
 		handleMessage: lListener.handleMessage,
 	}
 	return lListener
@@ -239,6 +526,8 @@ func (listener *localListener) handleMessage(session session.Session, msg any) {
 	switch tMsg := msg.(type) {
 	case *tcodec.MessageSRes:
 		listener.handleMessageRes(tMsg)
+	case *tcodec.BatchSRes:
+		listener.handleBatchRes(tMsg)
 	case *tcodec.MessageRouter:
 		listener.handleMessageRouter(session, tMsg)
 	case *tcodec.BroadcastSRes:
@@ -249,6 +538,18 @@ func (listener *localListener) handleMessage(session session.Session, msg any) {
 		listener.handleUnregisterRes(tMsg)
 	case *tcodec.HeartbeatSRes:
 		listener.handleHeartbeatRes(tMsg)
+	case *tcodec.BroadcastAllSRes:
+		listener.handleBroadcastAllRes(tMsg)
+	case *tcodec.SessionAttrSRes:
+		listener.handleSessionAttrRes(tMsg)
+	case *tcodec.RebindSRes:
+		listener.handleRebindRes(session, tMsg)
+	case *tcodec.JoinGroupSRes:
+		listener.handleJoinGroupRes(tMsg)
+	case *tcodec.LeaveGroupSRes:
+		listener.handleLeaveGroupRes(tMsg)
+	case *tcodec.GroupBroadcastSRes:
+		listener.handleGroupBroadcastRes(tMsg)
 	case *tcodec.HandshakeRes:
 	case *tcodec.ServiceInstIReq:
 		listener.handleServiceInstIReq(session, tMsg)
@@ -269,6 +570,7 @@ func newRemoteListener(client *Remote) *remoteListener {
 	}
 	rListener.listener = &listener{
 		manager:       client.manager,
+		service:       client,
 		handleMessage: rListener.handleMessage,
 	}
 	return rListener
@@ -288,6 +590,10 @@ func (listener *remoteListener) OnOpened(sess session.Session) {
 }
 
 func (listener *remoteListener) OnClosed(session session.Session) {
+	// 主动关闭的连接不计入连接断开，避免一次握手或写出失败重复计数
+	if !listener.client.closing.Swap(false) {
+		listener.client.outlier.onFlap()
+	}
 	// 尝试重连
 	err := listener.client.Connect()
 	if err != nil {
@@ -299,6 +605,8 @@ func (listener *remoteListener) handleMessage(session session.Session, msg any)
 	switch tMsg := msg.(type) {
 	case *tcodec.MessageSRes:
 		listener.handleMessageRes(tMsg)
+	case *tcodec.BatchSRes:
+		listener.handleBatchRes(tMsg)
 	case *tcodec.MessageRouter:
 		listener.handleMessageRouter(session, tMsg)
 	case *tcodec.BroadcastSRes:
@@ -309,8 +617,22 @@ func (listener *remoteListener) handleMessage(session session.Session, msg any)
 		listener.handleUnregisterRes(tMsg)
 	case *tcodec.HeartbeatSRes:
 		listener.handleHeartbeatRes(tMsg)
+	case *tcodec.BroadcastAllSRes:
+		listener.handleBroadcastAllRes(tMsg)
+	case *tcodec.SessionAttrSRes:
+		listener.handleSessionAttrRes(tMsg)
+	case *tcodec.RebindSRes:
+		listener.handleRebindRes(session, tMsg)
+	case *tcodec.JoinGroupSRes:
+		listener.handleJoinGroupRes(tMsg)
+	case *tcodec.LeaveGroupSRes:
+		listener.handleLeaveGroupRes(tMsg)
+	case *tcodec.GroupBroadcastSRes:
+		listener.handleGroupBroadcastRes(tMsg)
 	case *tcodec.HandshakeRes:
 		listener.handleHandshakeRes(tMsg)
+	case *tcodec.MeshLookupRes:
+		listener.handleMeshLookupRes(tMsg)
 	case *tcodec.ServiceInstIReq:
 		listener.handleServiceInstIReq(session, tMsg)
 	case *tcodec.SegmentMsg:
@@ -324,9 +646,10 @@ func (listener *remoteListener) handleHandshakeRes(res *tcodec.HandshakeRes) {
 	switch res.Code {
 	case common.ErrCodeSuccess:
 		// 握手成功
-		listener.client.onHandshake()
+		listener.client.onHandshake(res)
 	default:
 		plog.Error("(transfer client) handshake error:", pfield.Uint16("code", res.Code))
+		listener.client.outlier.onHandshakeFailure()
 		// 无法处理的情况则停掉客户端
 		if err := listener.client.closeConn(); err != nil {
 			plog.Error("close conn error:", pfield.Error(err))
@@ -341,40 +664,27 @@ func (listener *remoteListener) handleHandshakeRes(res *tcodec.HandshakeRes) {
 	}
 }
 
-func (listener *remoteListener) handleSegmentMsg(session session.Session, msg *tcodec.SegmentMsg) {
-	if msg.Seq == 0 {
-		if listener.client.segmentFrameBuf != nil {
-			plog.Error("last segmentation message did not complete")
-		}
-		listener.client.segmentFrameBuf = msg.Frame
-		listener.client.segmentFrameAmount = msg.Amount
-		return
+func (listener *remoteListener) handleMeshLookupRes(res *tcodec.MeshLookupRes) {
+	if mesh := listener.manager.transfer.mesh; mesh != nil {
+		mesh.handleLookupRes(listener.client.Info().ServiceId(), res)
 	}
-	if listener.client.segmentFrameBuf == nil {
-		plog.Error("invalid segmentation message buf")
+}
+
+func (listener *remoteListener) handleSegmentMsg(session session.Session, msg *tcodec.SegmentMsg) {
+	buf, err := listener.client.reassembler.Push(msg)
+	if err != nil {
 		// 此时无法处理，直接丢弃
+		plog.Error("(transfer client) reassemble segmentation message error:", pfield.Error(err))
 		return
-	} else if listener.client.segmentFrameAmount != msg.Amount {
-		plog.Error("invalid segmentation message amount",
-			pfield.Uint16("oldAmount", listener.client.segmentFrameAmount),
-			pfield.Uint16("newAmount", msg.Amount))
-		// 此时无法处理，直接丢弃
-		listener.client.segmentFrameBuf = nil
-		listener.client.segmentFrameAmount = 0
+	}
+	if buf == nil {
 		return
 	}
-	listener.client.segmentFrameBuf = append(listener.client.segmentFrameBuf, msg.Frame...)
-	if msg.Seq == msg.Amount-1 {
-		buf := listener.client.segmentFrameBuf
-		// 清理
-		listener.client.segmentFrameBuf = nil
-		listener.client.segmentFrameAmount = 0
-		// 处理分段消息
-		sMsg, err := listener.client.manager.clientCodec.Decode(buf)
-		if err != nil {
-			plog.Error("decode segmentation message error:", pfield.Error(err))
-			return
-		}
-		listener.handleMessage(session, sMsg)
+	// 处理分段消息，重组后的缓存不再复用，无需拷贝负载
+	sMsg, err := listener.client.manager.clientCodec.DecodeBorrowed(buf)
+	if err != nil {
+		plog.Error("decode segmentation message error:", pfield.Error(err))
+		return
 	}
+	listener.handleMessage(session, sMsg)
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/meow-pad/chinchilla/handler"
+	tcodec "github.com/meow-pad/chinchilla/transfer/codec"
 	"github.com/meow-pad/chinchilla/transfer/common"
 	"github.com/meow-pad/chinchilla/transfer/service"
 	"github.com/meow-pad/persian/errdef"
@@ -19,7 +20,7 @@ func newLocalServerSession(local *Local, manager *Manager,
 	ctxBuilder func(session.Session) (session.Context, error)) (*localServerSession, error) {
 	sess := &localServerSession{
 		clientSess: newLocalClientSession(local),
-		listener:   newLocalListener(manager),
+		listener:   newLocalListener(manager, local),
 	}
 	ctx, err := ctxBuilder(sess)
 	if err != nil {
@@ -125,6 +126,7 @@ type Local struct {
 	serverSession session.Session // 这里比较特殊，它是模拟服务接收端的网关会话（非网关内部的服务的会话），这与网关内其他会话概念相反
 	serverCodec   netcodec.Codec
 	handler       handler.MessageHandler
+	reassembler   *tcodec.Reassembler
 
 	stopped atomic.Bool
 }
@@ -157,6 +159,7 @@ func (local *Local) init(manager *Manager, info common.Info) error {
 	local.serverSession = sess
 	local.serverCodec = options.LocalServerCodec
 	local.handler = msgHandler
+	local.reassembler = tcodec.NewReassembler(options.TransferSegmentMaxSize, options.TransferSegmentTimeout)
 	return nil
 }
 
@@ -193,11 +196,16 @@ func (local *Local) TransferMessage(msgBytes []byte) error {
 	if !local.info.Enable {
 		return service.ErrDisabledService
 	}
-	reader := utils.NewBytesReader(msgBytes)
-	if msgArr, _, err := local.serverCodec.Decode(reader); err != nil {
+	if msgArr, err := local.decode(msgBytes); err != nil {
 		return err
 	} else {
 		for _, msg := range msgArr {
+			if segment, ok := msg.(*tcodec.SegmentMsg); ok {
+				if hErr := local.handleSegmentMsg(segment); hErr != nil {
+					return hErr
+				}
+				continue
+			}
 			if hErr := local.handler.HandleMessage(local.serverSession, msg); hErr != nil {
 				return hErr
 			}
@@ -206,6 +214,48 @@ func (local *Local) TransferMessage(msgBytes []byte) error {
 	return nil
 }
 
+// decode
+//
+//	@Description: 解码完整的消息数据，编解码器支持时不再拷贝负载
+//	@receiver local
+//	@param msgBytes 处理完成前不可修改
+//	@return []any
+//	@return error
+func (local *Local) decode(msgBytes []byte) ([]any, error) {
+	if decoder, ok := local.serverCodec.(interface {
+		DecodeBytes(data []byte) ([]any, error)
+	}); ok {
+		return decoder.DecodeBytes(msgBytes)
+	}
+	msgArr, _, err := local.serverCodec.Decode(utils.NewBytesReader(msgBytes))
+	return msgArr, err
+}
+
+// handleSegmentMsg
+//
+//	@Description: 重组分段消息，完整后交由处理器
+//	@receiver local
+//	@param segment
+//	@return error
+func (local *Local) handleSegmentMsg(segment *tcodec.SegmentMsg) error {
+	buf, err := local.reassembler.Push(segment)
+	if err != nil || buf == nil {
+		return err
+	}
+	// 重组后的消息不带帧头，需以本地编解码器的消息编解码器解码；缓存不再复用，无需拷贝负载
+	decoder, ok := local.serverCodec.(interface {
+		DecodeBody(body []byte) (any, error)
+	})
+	if !ok {
+		return fmt.Errorf("local server codec can not decode reassembled message")
+	}
+	msg, err := decoder.DecodeBody(buf)
+	if err != nil {
+		return err
+	}
+	return local.handler.HandleMessage(local.serverSession, msg)
+}
+
 func (local *Local) IsEnable() bool {
 	if local.stopped.Load() {
 		return false
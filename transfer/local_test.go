@@ -0,0 +1,54 @@
+package transfer
+
+import (
+	"encoding/binary"
+	tcodec "github.com/meow-pad/chinchilla/transfer/codec"
+	"github.com/meow-pad/persian/frame/pnet/tcp/session"
+	"github.com/meow-pad/persian/frame/pnet/utils"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type testMessageHandler struct {
+	messages []any
+}
+
+func (handler *testMessageHandler) HandleMessage(_ session.Session, msg any) error {
+	handler.messages = append(handler.messages, msg)
+	return nil
+}
+
+func TestLocal_HandleSegmentMsg(t *testing.T) {
+	should := require.New(t)
+	msgHandler := &testMessageHandler{}
+	// 本地编解码器使用非默认字节序，重组后的消息需以其解码
+	serverCodec, err := tcodec.NewCodec(tcodec.NewServerCodec(binary.LittleEndian), 8*1024)
+	should.Nil(err)
+	local := &Local{
+		handler:     msgHandler,
+		reassembler: tcodec.NewReassembler(tcodec.DefaultReassembleMaxSize, tcodec.DefaultReassembleTimeout),
+		serverCodec: serverCodec,
+	}
+	payload := make([]byte, 3*tcodec.LegacyMaxFrameSize)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	msg := &tcodec.MessageSReq{ConnId: 12345, Payload: payload}
+	body, err := tcodec.NewClientCodec(binary.LittleEndian).Encode(msg)
+	should.Nil(err)
+	data, err := tcodec.MessageSegmentation(body, tcodec.LegacyMaxFrameSize, true)
+	should.Nil(err)
+	dCodec, err := tcodec.NewCodec(tcodec.NewServerCodec(tcodec.MessageCodecByteOrder), 8*1024)
+	should.Nil(err)
+	segments, _, err := dCodec.Decode(utils.NewBytesReader(data))
+	should.Nil(err)
+	should.Greater(len(segments), 1)
+	for _, segment := range segments {
+		should.Nil(local.handleSegmentMsg(segment.(*tcodec.SegmentMsg)))
+	}
+	should.Len(msgHandler.messages, 1)
+	dMsg, ok := msgHandler.messages[0].(*tcodec.MessageSReq)
+	should.True(ok)
+	should.Equal(msg.ConnId, dMsg.ConnId)
+	should.Equal(msg.Payload, dMsg.Payload)
+}
@@ -1,18 +1,29 @@
 package transfer
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	rcodec "github.com/meow-pad/chinchilla/receiver/codec"
+	rcontext "github.com/meow-pad/chinchilla/receiver/context"
 	"github.com/meow-pad/chinchilla/transfer/codec"
 	"github.com/meow-pad/chinchilla/transfer/common"
+	"github.com/meow-pad/chinchilla/transfer/router"
+	"github.com/meow-pad/chinchilla/transfer/selector"
 	"github.com/meow-pad/chinchilla/transfer/service"
+	"github.com/meow-pad/chinchilla/utils/hashring"
 	"github.com/meow-pad/persian/errdef"
 	"github.com/meow-pad/persian/frame/plog"
 	"github.com/meow-pad/persian/frame/plog/pfield"
 	"github.com/meow-pad/persian/utils/coding"
 	"github.com/meow-pad/persian/utils/collections"
+	"github.com/meow-pad/persian/utils/worker"
 	"github.com/nacos-group/nacos-sdk-go/v2/model"
 	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 func NewManager(transfer *Transfer,
@@ -21,7 +32,8 @@ func NewManager(transfer *Transfer,
 		return nil, errdef.ErrInvalidParams
 	}
 	manager := &Manager{transfer: transfer,
-		service: service, clientCodec: clientCodec}
+		service: service, clientCodec: clientCodec,
+		selector: transfer.newSelector(service), detectOutlier: true}
 	return manager, nil
 }
 
@@ -29,9 +41,19 @@ type Manager struct {
 	transfer    *Transfer
 	service     string
 	clientCodec *codec.ClientCodec
+	selector    selector.Selector
 
 	services   collections.SyncMap[string, service.Service]
 	srvInfoArr []common.Info
+	hashRing   atomic.Pointer[hashring.Ring]
+
+	// 是否摘除异常实例，不经选择器选择的连接（如组网）无需摘除
+	detectOutlier bool
+	ejectLock     sync.Mutex
+	// 选择器的更新序号，持有 ejectLock 时递增，用于丢弃过时的实例变化通知
+	selectionSeq uint64
+	notifyLock   sync.Mutex
+	notifiedSeq  uint64
 }
 
 // UpdateInstances
@@ -40,6 +62,26 @@ type Manager struct {
 //	@receiver manager
 //	@param instArr
 func (manager *Manager) UpdateInstances(instArr []model.Instance) {
+	srvInfoArr := manager.updateServices(instArr)
+	manager.ejectLock.Lock()
+	manager.srvInfoArr = srvInfoArr
+	manager.rebuildHashRing()
+	seq, admitted := manager.refreshSelection()
+	manager.ejectLock.Unlock()
+	manager.notifyInstances(seq, admitted)
+	plog.Debug("update service instances:",
+		pfield.Any("input", instArr),
+		pfield.Any("output", srvInfoArr),
+	)
+}
+
+// updateServices
+//
+//	@Description: 按实例信息增加、更新服务连接
+//	@receiver manager
+//	@param instArr
+//	@return []common.Info 可用的实例
+func (manager *Manager) updateServices(instArr []model.Instance) []common.Info {
 	var infoMap = make(map[string]*common.Info)
 	var srvInfoArr []common.Info
 	for _, inst := range instArr {
@@ -84,15 +126,7 @@ func (manager *Manager) UpdateInstances(instArr []model.Instance) {
 		}
 		return true
 	})
-	manager.srvInfoArr = srvInfoArr
-	manager.transfer.selector.Update(manager.srvInfoArr)
-	if manager.transfer.Options.ServiceInstListener != nil {
-		manager.transfer.Options.ServiceInstListener(manager.service, manager.srvInfoArr)
-	}
-	plog.Debug("update service instances:",
-		pfield.Any("input", instArr),
-		pfield.Any("output", manager.srvInfoArr),
-	)
+	return srvInfoArr
 }
 
 // addService
@@ -150,6 +184,146 @@ func (manager *Manager) KeepClientsAlive() {
 		}
 		return true
 	})
+	manager.readmitInstances(time.Now().UnixMilli())
+}
+
+// refreshSelection
+//
+//	@Description: 以未摘除的实例更新选择器，需持有 ejectLock；释放锁后再以返回值调用 notifyInstances，
+//	避免在锁内执行外部回调
+//	@receiver manager
+//	@return uint64 更新序号
+//	@return []common.Info 未摘除的实例
+func (manager *Manager) refreshSelection() (uint64, []common.Info) {
+	admitted := make([]common.Info, 0, len(manager.srvInfoArr))
+	for _, info := range manager.srvInfoArr {
+		if remote := manager.getRemote(info.ServiceId()); remote != nil && remote.outlier.isEjected() {
+			continue
+		}
+		admitted = append(admitted, info)
+	}
+	manager.selector.Update(admitted)
+	manager.selectionSeq++
+	return manager.selectionSeq, admitted
+}
+
+// notifyInstances
+//
+//	@Description: 通知实例变化（见 option.Options.ServiceInstListener），晚于更新序号更大的通知时丢弃
+//	@receiver manager
+//	@param seq
+//	@param admitted
+func (manager *Manager) notifyInstances(seq uint64, admitted []common.Info) {
+	listener := manager.transfer.Options.ServiceInstListener
+	if listener == nil {
+		return
+	}
+	manager.notifyLock.Lock()
+	defer manager.notifyLock.Unlock()
+	if seq <= manager.notifiedSeq {
+		return
+	}
+	manager.notifiedSeq = seq
+	listener(manager.service, admitted)
+}
+
+// ejectInstance
+//
+//	@Description: 暂时将异常实例从选择中摘除，已摘除的实例占比不超过 TransferOutlierMaxEjectionPercent
+//	@receiver manager
+//	@param remote
+//	@param reason 摘除原因
+//	@return bool 是否摘除
+func (manager *Manager) ejectInstance(remote *Remote, reason string) bool {
+	if !manager.detectOutlier {
+		return false
+	}
+	manager.ejectLock.Lock()
+	seq, admitted, ejected := manager.ejectInstanceLocked(remote, reason)
+	manager.ejectLock.Unlock()
+	if ejected {
+		manager.notifyInstances(seq, admitted)
+	}
+	return ejected
+}
+
+// ejectInstanceLocked
+//
+//	@Description: 摘除实例，需持有 ejectLock
+//	@receiver manager
+//	@param remote
+//	@param reason
+//	@return uint64 更新序号
+//	@return []common.Info 未摘除的实例
+//	@return bool 是否摘除
+func (manager *Manager) ejectInstanceLocked(remote *Remote, reason string) (uint64, []common.Info, bool) {
+	if remote.outlier.isEjected() {
+		return 0, nil, false
+	}
+	total, ejected, found := 0, 0, false
+	for _, info := range manager.srvInfoArr {
+		total++
+		instId := info.ServiceId()
+		if instId == remote.info.ServiceId() {
+			found = true
+		}
+		if ejRemote := manager.getRemote(instId); ejRemote != nil && ejRemote.outlier.isEjected() {
+			ejected++
+		}
+	}
+	if !found {
+		// 已不在可用实例中
+		return 0, nil, false
+	}
+	if (ejected+1)*100 > total*manager.transfer.Options.TransferOutlierMaxEjectionPercent {
+		plog.Warn("(transfer) outlier ejection exceeds max percent:",
+			pfield.String("service", manager.service),
+			pfield.String("serviceId", remote.info.ServiceId()),
+			pfield.String("reason", reason))
+		return 0, nil, false
+	}
+	duration := remote.outlier.eject(time.Now().UnixMilli())
+	plog.Warn("(transfer) eject outlier instance:",
+		pfield.String("service", manager.service),
+		pfield.String("serviceId", remote.info.ServiceId()),
+		pfield.String("reason", reason),
+		pfield.Duration("duration", duration))
+	seq, admitted := manager.refreshSelection()
+	return seq, admitted, true
+}
+
+// readmitInstances
+//
+//	@Description: 恢复摘除到期的实例
+//	@receiver manager
+//	@param now 当前时间，单位毫秒
+func (manager *Manager) readmitInstances(now int64) {
+	if !manager.detectOutlier {
+		return
+	}
+	manager.ejectLock.Lock()
+	readmitted := false
+	for _, info := range manager.srvInfoArr {
+		if remote := manager.getRemote(info.ServiceId()); remote != nil && remote.outlier.readmit(now) {
+			readmitted = true
+			plog.Info("(transfer) readmit outlier instance:",
+				pfield.String("service", manager.service),
+				pfield.String("serviceId", info.ServiceId()))
+		}
+	}
+	if !readmitted {
+		manager.ejectLock.Unlock()
+		return
+	}
+	seq, admitted := manager.refreshSelection()
+	manager.ejectLock.Unlock()
+	manager.notifyInstances(seq, admitted)
+}
+
+func (manager *Manager) getRemote(instId string) *Remote {
+	srv, _ := manager.services.Load(instId)
+	remote, _ := srv.(*Remote)
+	return remote
 }
 
 // SelectInstance
@@ -160,9 +334,21 @@ func (manager *Manager) KeepClientsAlive() {
 //	@return Service
 //	@return error
 func (manager *Manager) SelectInstance(routerId string) (service.Service, error) {
+	return manager.SelectInstanceWithHints(routerId, nil)
+}
+
+// SelectInstanceWithHints
+//
+//	@Description: 按客户端提示选择可用服务，选择器不支持提示时同 SelectInstance（见 selector.HintSelector）
+//	@receiver manager
+//	@param routerId
+//	@param hints 客户端提示的标签，如握手时携带的版本、区域
+//	@return Service
+//	@return error
+func (manager *Manager) SelectInstanceWithHints(routerId string, hints map[string]string) (service.Service, error) {
 	defer coding.CatchPanicError("select service instance error", nil,
 		pfield.String("routerId", routerId))
-	instId, err := manager.transfer.selector.Select(routerId)
+	instId, err := selector.SelectWithHints(manager.selector, routerId, hints)
 	if err != nil && !errors.Is(err, common.ErrEmptyInstances) {
 		return nil, err
 	}
@@ -171,14 +357,180 @@ func (manager *Manager) SelectInstance(routerId string) (service.Service, error)
 
 // Route
 //
-//	@Description: 路由至可用服务
+//	@Description: 路由至可用服务，按路由类型交由路由注册表处理
 //	@receiver manager
 //	@param routerType
 //	@param routerId
 //	@param msg
 //	@return error
 func (manager *Manager) Route(routerType int16, routerId string, msg []byte) error {
-	return manager.transfer.router.Route(&manager.services, routerType, routerId, msg)
+	return manager.transfer.router.Route(manager, routerType, routerId, msg)
+}
+
+// ServiceName
+//
+//	@Description: 服务名
+//	@receiver manager
+//	@return string
+func (manager *Manager) ServiceName() string {
+	return manager.service
+}
+
+// Services
+//
+//	@Description: 服务实例，键为实例id
+//	@receiver manager
+//	@return *collections.SyncMap[string, service.Service]
+func (manager *Manager) Services() *collections.SyncMap[string, service.Service] {
+	return &manager.services
+}
+
+// Selector
+//
+//	@Description: 服务的选择器
+//	@receiver manager
+//	@return selector.Selector
+func (manager *Manager) Selector() selector.Selector {
+	return manager.selector
+}
+
+// registerManagerRoutes
+//
+//	@Description: 注册需要服务管理器处理的内置路由类型，可再通过 ServiceRoutes 覆盖
+//	@param registry
+//	@return error
+func registerManagerRoutes(registry *router.Registry) error {
+	routes := map[int16]func(manager *Manager, routerId string, msg []byte) error{
+		router.RouteTypeSelect: (*Manager).routeSelect,
+		router.RouteTypeHash:   (*Manager).routeHash,
+		router.RouteTypeConn: func(manager *Manager, routerId string, msg []byte) error {
+			return manager.routeSession(router.RouteTypeConn, routerId, msg)
+		},
+		router.RouteTypeUser: func(manager *Manager, routerId string, msg []byte) error {
+			return manager.routeSession(router.RouteTypeUser, routerId, msg)
+		},
+		router.RouteTypeConnClient: func(manager *Manager, routerId string, msg []byte) error {
+			return manager.routeSession(router.RouteTypeConnClient, routerId, msg)
+		},
+		router.RouteTypeUserClient: func(manager *Manager, routerId string, msg []byte) error {
+			return manager.routeSession(router.RouteTypeUserClient, routerId, msg)
+		},
+	}
+	for routerType, route := range routes {
+		route := route
+		if err := registry.Register(routerType, func(ctx router.Context, routerId string, msg []byte) error {
+			manager, ok := ctx.(*Manager)
+			if !ok {
+				return common.ErrUnknownRouteType
+			}
+			return route(manager, routerId, msg)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// routeSelect
+//
+//	@Description: 由服务的选择器按 routerId 选择实例
+//	@receiver manager
+//	@param routerId
+//	@param msg
+//	@return error
+func (manager *Manager) routeSelect(routerId string, msg []byte) error {
+	srv, err := manager.SelectInstance(routerId)
+	if err != nil {
+		return fmt.Errorf("%w: %w", common.ErrNoInstance, err)
+	}
+	if srv == nil {
+		return common.ErrNoInstance
+	}
+	return srv.TransferMessage(msg)
+}
+
+// routeHash
+//
+//	@Description: 按 routerId 在可用实例的一致性哈希环上选择实例
+//	@receiver manager
+//	@param routerId
+//	@param msg
+//	@return error
+func (manager *Manager) routeHash(routerId string, msg []byte) error {
+	instId := manager.hashRing.Load().Get(routerId)
+	if len(instId) <= 0 {
+		return common.ErrNoInstance
+	}
+	srv, _ := manager.services.Load(instId)
+	if srv == nil {
+		return common.ErrNoInstance
+	}
+	if srv.IsStopped() {
+		return service.ErrStoppedInstance
+	}
+	return srv.TransferMessage(msg)
+}
+
+// routeSession
+//
+//	@Description: 路由至会话绑定的服务实例或直接下发至客户端
+//	@receiver manager
+//	@param routerType
+//	@param routerId 连接id或会话 RouterId
+//	@param msg
+//	@return error
+func (manager *Manager) routeSession(routerType int16, routerId string, msg []byte) error {
+	var connId uint64
+	if routerType == router.RouteTypeConn || routerType == router.RouteTypeConnClient {
+		var err error
+		if connId, err = strconv.ParseUint(routerId, 10, 64); err != nil {
+			return fmt.Errorf("%w: invalid connId %s", common.ErrNoSession, routerId)
+		}
+	} else {
+		var ok bool
+		if connId, ok = manager.transfer.sessionIndex.Lookup(routerId); !ok {
+			return common.ErrNoSession
+		}
+	}
+	toClient := routerType == router.RouteTypeConnClient || routerType == router.RouteTypeUserClient
+	ctx, cancel := context.WithTimeout(context.Background(), manager.transfer.Options.RouterSessionTimeout)
+	defer cancel()
+	return manager.transfer.ForwardWait(ctx, int64(connId), func(local *worker.GoroutineLocal) error {
+		sess := getSessionFromGoLocal(local, connId)
+		if sess == nil || sess.IsClosed() {
+			return common.ErrNoSession
+		}
+		if toClient {
+			rRes := &rcodec.MessageRes{}
+			rRes.Payload = msg
+			rcontext.SendMessage(sess, rRes)
+			return nil
+		}
+		senderCtx, _ := sess.Context().(rcontext.SenderContext)
+		if senderCtx == nil {
+			return common.ErrNoSession
+		}
+		srv := senderCtx.GetService(manager.service)
+		if srv == nil {
+			return common.ErrNotBound
+		}
+		if srv.IsStopped() {
+			return service.ErrStoppedInstance
+		}
+		return srv.TransferMessage(msg)
+	})
+}
+
+// rebuildHashRing
+//
+//	@Description: 按可用实例重建一致性哈希环
+//	@receiver manager
+func (manager *Manager) rebuildHashRing() {
+	nodes := make([]hashring.Node, 0, len(manager.srvInfoArr))
+	for _, info := range manager.srvInfoArr {
+		nodes = append(nodes, hashring.Node{Id: info.ServiceId(), Weight: info.Weight})
+	}
+	manager.hashRing.Store(hashring.New(manager.transfer.Options.ServiceHashReplicas, nodes))
 }
 
 func (manager *Manager) getOpenService(instId string) (service.Service, error) {
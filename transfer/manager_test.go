@@ -0,0 +1,52 @@
+package transfer
+
+import (
+	"github.com/meow-pad/chinchilla/option"
+	"github.com/meow-pad/chinchilla/transfer/common"
+	"github.com/meow-pad/chinchilla/transfer/router"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestManager_BuiltinRoutes(t *testing.T) {
+	should := require.New(t)
+	manager := newTestManager(t)
+	manager.transfer.router = router.NewRegistry(nil)
+	should.Nil(registerManagerRoutes(manager.transfer.router))
+	// 内置路由类型由注册表分发至服务管理器
+	should.ErrorIs(manager.Route(router.RouteTypeConn, "not-a-conn-id", nil), common.ErrNoSession)
+	should.ErrorIs(manager.Route(router.RouteTypeHash, "1", nil), common.ErrNoInstance)
+	should.ErrorIs(manager.Route(router.RouteTypeService, "missing", nil), common.ErrNoInstance)
+	// 非服务管理器的上下文
+	should.ErrorIs(manager.transfer.router.Route(&testRouteContext{Manager: manager}, router.RouteTypeHash, "1", nil),
+		common.ErrUnknownRouteType)
+}
+
+func TestManager_OverrideBuiltinRoute(t *testing.T) {
+	should := require.New(t)
+	var routed []string
+	manager := newTestManager(t, option.WithServiceRoute(router.RouteTypeConn,
+		func(ctx router.Context, routerId string, msg []byte) error {
+			should.Equal("test", ctx.ServiceName())
+			should.NotNil(ctx.Services())
+			routed = append(routed, routerId)
+			return nil
+		}))
+	manager.transfer.router = router.NewRegistry(nil)
+	should.Nil(registerManagerRoutes(manager.transfer.router))
+	for routerType, route := range manager.transfer.Options.ServiceRoutes {
+		should.Nil(manager.transfer.router.Register(routerType, route))
+	}
+	should.Nil(manager.Route(router.RouteTypeConn, "not-a-conn-id", nil))
+	should.Equal([]string{"not-a-conn-id"}, routed)
+	// 注销后交由 fallback
+	manager.transfer.router.Unregister(router.RouteTypeConn)
+	should.ErrorIs(manager.Route(router.RouteTypeConn, "1", nil), common.ErrUnknownRouteType)
+}
+
+// testRouteContext
+//
+//	@Description: 包装服务管理器的路由上下文
+type testRouteContext struct {
+	*Manager
+}
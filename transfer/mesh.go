@@ -0,0 +1,466 @@
+package transfer
+
+import (
+	"context"
+	"fmt"
+	"github.com/meow-pad/chinchilla/transfer/codec"
+	"github.com/meow-pad/chinchilla/transfer/common"
+	"github.com/meow-pad/chinchilla/transfer/service"
+	"github.com/meow-pad/persian/frame/plog"
+	"github.com/meow-pad/persian/frame/plog/pfield"
+	"github.com/meow-pad/persian/frame/pnet/tcp/server"
+	"github.com/meow-pad/persian/frame/pnet/tcp/session"
+	"github.com/meow-pad/persian/utils/collections"
+	"github.com/nacos-group/nacos-sdk-go/v2/model"
+	"github.com/panjf2000/gnet/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+func NewMesh(transfer *Transfer) (*Mesh, error) {
+	mesh := &Mesh{transfer: transfer}
+	if err := mesh.init(); err != nil {
+		return nil, err
+	}
+	return mesh, nil
+}
+
+// Mesh
+//
+//	@Description: 网关组网，网关以 MeshServiceName 注册自身并连接其它网关，
+//	会话不在本网关的用户路由、组广播及全服广播经组网转发至其它网关
+type Mesh struct {
+	transfer    *Transfer
+	manager     *Manager // 其它网关的连接
+	serverCodec *codec.ServerCodec
+	msgCoder    *codec.FrameCodec
+	server      *server.Server
+
+	lookupSeq atomic.Uint32
+	lookups   collections.SyncMap[uint32, chan meshLookupResult]
+
+	userLock      sync.Mutex
+	userPeers     map[string]meshUserPeer        // RouterId -> 会话所在网关
+	pendingRoutes map[string][]*meshPendingRoute // 查询中的 RouterId 及待转发的消息
+}
+
+type meshLookupResult struct {
+	peerId string
+	found  bool
+}
+
+// meshUserPeer
+//
+//	@Description: 缓存的会话所在网关
+type meshUserPeer struct {
+	peerId   string
+	expireAt int64 // 过期时间，单位毫秒
+}
+
+// meshPendingRoute
+//
+//	@Description: 等待查询结果的用户路由消息
+type meshPendingRoute struct {
+	srvName   string
+	srvId     string
+	msg       *codec.MessageRouter
+	onFailure func(err error)
+}
+
+func (route *meshPendingRoute) fail(err error) {
+	if route.onFailure != nil {
+		route.onFailure(err)
+	}
+}
+
+func (mesh *Mesh) init() (err error) {
+	options := mesh.transfer.Options
+	mesh.userPeers = make(map[string]meshUserPeer)
+	mesh.pendingRoutes = make(map[string][]*meshPendingRoute)
+	if mesh.manager, err = NewManager(mesh.transfer, options.MeshServiceName,
+		codec.NewClientCodec(codec.MessageCodecByteOrder)); err != nil {
+		return
+	}
+	// 其它网关不经选择器选择
+	mesh.manager.detectOutlier = false
+	mesh.serverCodec = codec.NewServerCodec(codec.MessageCodecByteOrder)
+	if mesh.msgCoder, err = codec.NewFrameCodec(mesh.serverCodec,
+		options.TransferMessageWarningSize, options.TransferMaxFrameSize); err != nil {
+		return
+	}
+	addr := fmt.Sprintf("%s:%d", options.MeshServerIP, options.MeshServerPort)
+	if mesh.server, err = server.NewServer("transfer-mesh-server", addr, mesh.msgCoder,
+		&meshListener{mesh: mesh},
+		server.WithGNetOption(
+			gnet.WithReadBufferCap(options.TransferClientReadBufferCap),
+			gnet.WithWriteBufferCap(options.TransferClientWriteBufferCap),
+			gnet.WithTCPKeepAlive(options.TransferClientTCPKeepAlive),
+			gnet.WithSocketRecvBuffer(options.TransferClientSocketRecvBuffer),
+			gnet.WithSocketSendBuffer(options.TransferClientSocketSendBuffer),
+		),
+	); err != nil {
+		return
+	}
+	return
+}
+
+func (mesh *Mesh) Start(ctx context.Context) error {
+	if err := mesh.server.Start(ctx); err != nil {
+		return err
+	}
+	options := mesh.transfer.Options
+	registry := mesh.transfer.registry
+	if err := registry.RegisterInstance(options.MeshServiceName, options.MeshServerIP, options.MeshServerPort); err != nil {
+		return err
+	}
+	return registry.WatchService(options.MeshServiceName)
+}
+
+func (mesh *Mesh) Stop(ctx context.Context) error {
+	options := mesh.transfer.Options
+	registry := mesh.transfer.registry
+	if err := registry.DeregisterInstance(options.MeshServiceName, options.MeshServerIP, options.MeshServerPort); err != nil {
+		plog.Error("deregister mesh instance error:", pfield.Error(err))
+	}
+	if err := registry.UnSubscribeService(options.MeshServiceName); err != nil {
+		plog.Error("unsubscribe mesh service error:", pfield.Error(err))
+	}
+	mesh.manager.services.Range(func(_ string, peer service.Service) bool {
+		if err := peer.Stop(ctx); err != nil {
+			plog.Error("stop mesh peer error:", pfield.Error(err))
+		}
+		return true
+	})
+	return mesh.server.Stop(ctx)
+}
+
+// updatePeers
+//
+//	@Description: 更新其它网关实例
+//	@receiver mesh
+//	@param instances 包含当前网关的实例
+func (mesh *Mesh) updatePeers(instances []model.Instance) {
+	selfId := mesh.transfer.AppInfo.Id()
+	peers := make([]model.Instance, 0, len(instances))
+	for _, inst := range instances {
+		if common.Info(inst).ServiceId() != selfId {
+			peers = append(peers, inst)
+		}
+	}
+	mesh.manager.srvInfoArr = mesh.manager.updateServices(peers)
+	plog.Debug("update mesh peers:", pfield.Any("peers", mesh.manager.srvInfoArr))
+}
+
+// keepAlive
+//
+//	@Description: 其它网关连接保活
+//	@receiver mesh
+func (mesh *Mesh) keepAlive() {
+	mesh.manager.KeepClientsAlive()
+	mesh.purgeUserPeers(time.Now().UnixMilli())
+}
+
+// Peers
+//
+//	@Description: 可用的其它网关
+//	@receiver mesh
+//	@return []common.Info
+func (mesh *Mesh) Peers() []common.Info {
+	return mesh.manager.GetServiceInfoArray()
+}
+
+// Relay
+//
+//	@Description: 将服务消息转发至所有其它网关
+//	@receiver mesh
+//	@param srvName 消息来源服务名
+//	@param srvId 消息来源服务实例ID
+//	@param msg 服务发往网关的消息，如 GroupBroadcastSRes、BroadcastAllSRes
+//	@return error
+func (mesh *Mesh) Relay(srvName, srvId string, msg any) error {
+	req, err := mesh.buildRelay(srvName, srvId, msg)
+	if err != nil {
+		return err
+	}
+	mesh.manager.services.Range(func(peerId string, peer service.Service) bool {
+		if !peer.IsEnable() {
+			return true
+		}
+		if sErr := peer.SendMessage(req); sErr != nil {
+			plog.Error("(transfer mesh) relay message error:",
+				pfield.String("peer", peerId), pfield.Error(sErr))
+		}
+		return true
+	})
+	return nil
+}
+
+// RelayTo
+//
+//	@Description: 将服务消息转发至指定网关
+//	@receiver mesh
+//	@param peerId 网关id
+//	@param srvName 消息来源服务名
+//	@param srvId 消息来源服务实例ID
+//	@param msg
+//	@return error
+func (mesh *Mesh) RelayTo(peerId string, srvName, srvId string, msg any) error {
+	peer, _ := mesh.manager.services.Load(peerId)
+	if peer == nil {
+		return common.ErrNoInstance
+	}
+	if !peer.IsEnable() {
+		return service.ErrDisabledService
+	}
+	req, err := mesh.buildRelay(srvName, srvId, msg)
+	if err != nil {
+		return err
+	}
+	return peer.SendMessage(req)
+}
+
+func (mesh *Mesh) buildRelay(srvName, srvId string, msg any) (*codec.MeshRelayReq, error) {
+	payload, err := mesh.serverCodec.Encode(msg)
+	if err != nil {
+		return nil, err
+	}
+	return &codec.MeshRelayReq{
+		Service:   srvName,
+		ServiceId: srvId,
+		Payload:   payload,
+	}, nil
+}
+
+// LookupUser
+//
+//	@Description: 向其它网关查询 RouterId（如uid）对应会话所在的网关
+//	@receiver mesh
+//	@param ctx
+//	@param routerId
+//	@return string 网关id
+//	@return error 所有网关都没有该会话时为 common.ErrNoSession
+func (mesh *Mesh) LookupUser(ctx context.Context, routerId string) (string, error) {
+	var peers []service.Service
+	mesh.manager.services.Range(func(_ string, peer service.Service) bool {
+		if peer.IsEnable() {
+			peers = append(peers, peer)
+		}
+		return true
+	})
+	if len(peers) <= 0 {
+		return "", common.ErrNoSession
+	}
+	seq := mesh.nextLookupSeq()
+	resultChan := make(chan meshLookupResult, len(peers))
+	mesh.lookups.Store(seq, resultChan)
+	defer mesh.lookups.Delete(seq)
+	sent := 0
+	for _, peer := range peers {
+		if err := peer.SendMessage(&codec.MeshLookupReq{Seq: seq, RouterId: routerId}); err != nil {
+			plog.Debug("(transfer mesh) send lookup error:",
+				pfield.String("peer", peer.Info().ServiceId()), pfield.Error(err))
+			continue
+		}
+		sent++
+	}
+	for ; sent > 0; sent-- {
+		select {
+		case result := <-resultChan:
+			if result.found {
+				return result.peerId, nil
+			}
+		case <-ctx.Done():
+			return "", fmt.Errorf("%w: %w", common.ErrNoSession, ctx.Err())
+		}
+	}
+	return "", common.ErrNoSession
+}
+
+// RouteUser
+//
+//	@Description: 将用户路由消息转发至会话所在的网关，对端路由失败时不再回复。
+//	会话所在网关已缓存时直接转发，否则在协程池中查询，查询期间相同 RouterId 的消息排队以保证顺序
+//	@receiver mesh
+//	@param srvName 消息来源服务名
+//	@param srvId 消息来源服务实例ID
+//	@param msg
+//	@param onFailure 异步查询或转发失败时回调，可为nil
+//	@return error 同步转发失败或无法提交查询
+func (mesh *Mesh) RouteUser(srvName, srvId string, msg *codec.MessageRouter, onFailure func(err error)) error {
+	relayMsg := *msg
+	relayMsg.CorrelationId = 0
+	route := &meshPendingRoute{srvName: srvName, srvId: srvId, msg: &relayMsg, onFailure: onFailure}
+	routerId := msg.RouterId
+	mesh.userLock.Lock()
+	if pending, ok := mesh.pendingRoutes[routerId]; ok {
+		mesh.pendingRoutes[routerId] = append(pending, route)
+		mesh.userLock.Unlock()
+		return nil
+	}
+	if userPeer, ok := mesh.userPeers[routerId]; ok && userPeer.expireAt > time.Now().UnixMilli() {
+		mesh.userLock.Unlock()
+		err := mesh.RelayTo(userPeer.peerId, srvName, srvId, &relayMsg)
+		if err != nil {
+			mesh.forgetUserPeer(routerId)
+		}
+		return err
+	}
+	mesh.pendingRoutes[routerId] = []*meshPendingRoute{route}
+	mesh.userLock.Unlock()
+	if err := mesh.transfer.GoPool.Submit(func() {
+		mesh.lookupAndRoute(routerId)
+	}); err != nil {
+		mesh.userLock.Lock()
+		delete(mesh.pendingRoutes, routerId)
+		mesh.userLock.Unlock()
+		return err
+	}
+	return nil
+}
+
+// lookupAndRoute
+//
+//	@Description: 查询会话所在网关并按序转发排队的消息
+//	@receiver mesh
+//	@param routerId
+func (mesh *Mesh) lookupAndRoute(routerId string) {
+	ctx, cancel := context.WithTimeout(context.Background(), mesh.transfer.Options.MeshLookupTimeout)
+	peerId, err := mesh.LookupUser(ctx, routerId)
+	cancel()
+	for {
+		mesh.userLock.Lock()
+		routes := mesh.pendingRoutes[routerId]
+		if len(routes) <= 0 {
+			// 队列清空后才结束查询状态，之后的消息不会越过排队的消息
+			delete(mesh.pendingRoutes, routerId)
+			if err == nil && mesh.transfer.Options.MeshLookupCacheTTL > 0 {
+				mesh.userPeers[routerId] = meshUserPeer{
+					peerId:   peerId,
+					expireAt: time.Now().Add(mesh.transfer.Options.MeshLookupCacheTTL).UnixMilli(),
+				}
+			}
+			mesh.userLock.Unlock()
+			return
+		}
+		mesh.pendingRoutes[routerId] = routes[:0:0]
+		mesh.userLock.Unlock()
+		for _, route := range routes {
+			if err != nil {
+				route.fail(err)
+				continue
+			}
+			if rErr := mesh.RelayTo(peerId, route.srvName, route.srvId, route.msg); rErr != nil {
+				plog.Error("(transfer mesh) relay user message error:",
+					pfield.String("peer", peerId), pfield.String("routerId", routerId), pfield.Error(rErr))
+				route.fail(rErr)
+			}
+		}
+	}
+}
+
+// forgetUserPeer
+//
+//	@Description: 移除缓存的会话所在网关
+//	@receiver mesh
+//	@param routerId
+func (mesh *Mesh) forgetUserPeer(routerId string) {
+	mesh.userLock.Lock()
+	delete(mesh.userPeers, routerId)
+	mesh.userLock.Unlock()
+}
+
+// purgeUserPeers
+//
+//	@Description: 清理过期的会话所在网关缓存
+//	@receiver mesh
+//	@param now 当前时间，单位毫秒
+func (mesh *Mesh) purgeUserPeers(now int64) {
+	mesh.userLock.Lock()
+	defer mesh.userLock.Unlock()
+	for routerId, userPeer := range mesh.userPeers {
+		if userPeer.expireAt <= now {
+			delete(mesh.userPeers, routerId)
+		}
+	}
+}
+
+func (mesh *Mesh) nextLookupSeq() uint32 {
+	for {
+		if seq := mesh.lookupSeq.Add(1); seq != 0 {
+			return seq
+		}
+	}
+}
+
+// handleLookupRes
+//
+//	@Description: 处理其它网关的查询结果
+//	@receiver mesh
+//	@param peerId
+//	@param res
+func (mesh *Mesh) handleLookupRes(peerId string, res *codec.MeshLookupRes) {
+	resultChan, _ := mesh.lookups.Load(res.Seq)
+	if resultChan == nil {
+		// 已超时
+		return
+	}
+	select {
+	case resultChan <- meshLookupResult{peerId: peerId, found: res.Code == common.ErrCodeSuccess}:
+	default:
+	}
+}
+
+// handleRelay
+//
+//	@Description: 处理其它网关转发的服务消息，按来源服务实例处理且不再转发
+//	@receiver mesh
+//	@param sess
+//	@param req
+func (mesh *Mesh) handleRelay(sess session.Session, req *codec.MeshRelayReq) {
+	manager := mesh.transfer.GetServiceManager(req.Service)
+	if manager == nil {
+		plog.Warn("(transfer mesh) unknown relay service", pfield.String("service", req.Service))
+		return
+	}
+	srv, _ := manager.services.Load(req.ServiceId)
+	if srv == nil {
+		plog.Warn("(transfer mesh) unknown relay service instance",
+			pfield.String("service", req.Service), pfield.String("serviceId", req.ServiceId))
+		return
+	}
+	msg, err := manager.clientCodec.Decode(req.Payload)
+	if err != nil {
+		plog.Error("(transfer mesh) decode relay message error:", pfield.Error(err))
+		return
+	}
+	relayListener := &listener{
+		manager: manager,
+		service: srv,
+		relayed: true,
+	}
+	switch tMsg := msg.(type) {
+	case *codec.MessageRouter:
+		relayListener.handleMessageRouter(sess, tMsg)
+	case *codec.GroupBroadcastSRes:
+		relayListener.handleGroupBroadcastRes(tMsg)
+	case *codec.BroadcastAllSRes:
+		relayListener.handleBroadcastAllRes(tMsg)
+	default:
+		plog.Error("(transfer mesh) unsupported relay message", pfield.Any("msg", msg))
+	}
+}
+
+// handleLookupReq
+//
+//	@Description: 回复其它网关的会话查询
+//	@receiver mesh
+//	@param sess
+//	@param req
+func (mesh *Mesh) handleLookupReq(sess session.Session, req *codec.MeshLookupReq) {
+	res := &codec.MeshLookupRes{Seq: req.Seq, Code: common.ErrCodeNoSession}
+	if _, ok := mesh.transfer.sessionIndex.Lookup(req.RouterId); ok {
+		res.Code = common.ErrCodeSuccess
+	}
+	sess.SendMessage(res)
+}
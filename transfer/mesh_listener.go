@@ -0,0 +1,167 @@
+package transfer
+
+import (
+	"github.com/meow-pad/chinchilla/transfer/codec"
+	"github.com/meow-pad/chinchilla/transfer/common"
+	"github.com/meow-pad/persian/frame/plog"
+	"github.com/meow-pad/persian/frame/plog/pfield"
+	"github.com/meow-pad/persian/frame/pnet/tcp/session"
+	"math"
+	"reflect"
+	"time"
+)
+
+const (
+	// 组网连接完成握手的时限，单位毫秒
+	meshHandshakeTimeoutMs = 20_000
+)
+
+func newMeshContext(mesh *Mesh, sess session.Session) *meshContext {
+	options := mesh.transfer.Options
+	ctx := &meshContext{
+		reassembler: codec.NewReassembler(options.TransferSegmentMaxSize, options.TransferSegmentTimeout),
+	}
+	ctx.Init(sess.Connection().Hash())
+	ctx.SetDeadline(time.Now().UnixMilli() + meshHandshakeTimeoutMs)
+	return ctx
+}
+
+// meshContext
+//
+//	@Description: 其它网关连入的会话上下文
+type meshContext struct {
+	session.BaseContext
+
+	// 对端网关id，握手后设置
+	peerId string
+	// 分段消息重组
+	reassembler *codec.Reassembler
+}
+
+func (ctx *meshContext) shakeHand(peerId string) {
+	ctx.peerId = peerId
+	ctx.SetDeadline(math.MaxInt64)
+}
+
+func (ctx *meshContext) isHandShook() bool {
+	return len(ctx.peerId) > 0
+}
+
+// meshListener
+//
+//	@Description: 组网服务器监听，处理其它网关的握手、转发及查询消息
+type meshListener struct {
+	mesh *Mesh
+}
+
+func (listener *meshListener) OnOpened(sess session.Session) {
+	plog.Debug("(transfer mesh) session opened:",
+		pfield.String("remoteAddr", sess.Connection().RemoteAddr().String()))
+	if err := sess.Register(newMeshContext(listener.mesh, sess)); err != nil {
+		plog.Error("(transfer mesh) register session context error:", pfield.Error(err))
+		if cErr := sess.Close(); cErr != nil {
+			plog.Error("close session error:", pfield.Error(cErr))
+		}
+	}
+}
+
+func (listener *meshListener) OnClosed(sess session.Session) {
+	plog.Debug("(transfer mesh) session closed:",
+		pfield.String("remoteAddr", sess.Connection().RemoteAddr().String()))
+}
+
+func (listener *meshListener) OnReceive(sess session.Session, msg any, msgLen int) error {
+	if plog.LoggerLevel() == plog.DebugLevel {
+		plog.Debug("(transfer mesh) receive message:",
+			pfield.String("msgType", reflect.TypeOf(msg).String()), pfield.JsonString("msg", msg))
+	}
+	listener.handleMessage(sess, msg)
+	return nil
+}
+
+func (listener *meshListener) OnReceiveMulti(sess session.Session, msgArr []any, totalLen int) error {
+	for _, msg := range msgArr {
+		if plog.LoggerLevel() == plog.DebugLevel {
+			plog.Debug("(transfer mesh) receive message:",
+				pfield.String("msgType", reflect.TypeOf(msg).String()), pfield.JsonString("msg", msg))
+		}
+		listener.handleMessage(sess, msg)
+	}
+	return nil
+}
+
+func (listener *meshListener) OnSend(sess session.Session, msg any, msgLen int) error {
+	return nil
+}
+
+func (listener *meshListener) OnSendMulti(sess session.Session, msgArr []any, totalLen int) error {
+	return nil
+}
+
+func (listener *meshListener) handleMessage(sess session.Session, msg any) {
+	ctx, _ := sess.Context().(*meshContext)
+	if ctx == nil {
+		plog.Error("(transfer mesh) invalid session context")
+		return
+	}
+	if req, ok := msg.(*codec.HandshakeReq); ok {
+		listener.handleHandshakeReq(sess, ctx, req)
+		return
+	}
+	if !ctx.isHandShook() {
+		plog.Error("(transfer mesh) handshake first",
+			pfield.String("msgType", reflect.TypeOf(msg).String()))
+		return
+	}
+	switch tMsg := msg.(type) {
+	case *codec.MeshRelayReq:
+		listener.mesh.handleRelay(sess, tMsg)
+	case *codec.MeshLookupReq:
+		listener.mesh.handleLookupReq(sess, tMsg)
+	case *codec.SegmentMsg:
+		listener.handleSegmentMsg(sess, ctx, tMsg)
+	default:
+		plog.Error("(transfer mesh) unknown message type:", pfield.String("msgType", reflect.TypeOf(msg).String()))
+	}
+}
+
+func (listener *meshListener) handleHandshakeReq(sess session.Session, ctx *meshContext, req *codec.HandshakeReq) {
+	options := listener.mesh.transfer.Options
+	res := &codec.HandshakeRes{}
+	switch {
+	case len(req.Id) <= 0:
+		res.Code = common.ErrCodeInvalidTransferId
+	case req.AuthKey != options.TransferServiceAuthKey:
+		res.Code = common.ErrCodeInvalidAuth
+	case req.Service != options.MeshServiceName:
+		res.Code = common.ErrCodeInvalidService
+	case req.ServiceId != listener.mesh.transfer.AppInfo.Id():
+		res.Code = common.ErrCodeInvalidServiceId
+	default:
+		ctx.shakeHand(req.Id)
+		// 编码器为所有会话共享，下行超长消息仍分段发送，仅告知可接收的帧长度
+		res.MaxFrameSize = uint32(listener.mesh.msgCoder.MaxFrameSize())
+		res.Version = codec.ProtocolVersion
+		res.Capabilities = req.Capabilities & codec.SupportedCapabilities
+		plog.Debug("(transfer mesh) handshake with peer", pfield.String("peer", req.Id))
+	}
+	sess.SendMessage(res)
+}
+
+func (listener *meshListener) handleSegmentMsg(sess session.Session, ctx *meshContext, msg *codec.SegmentMsg) {
+	buf, err := ctx.reassembler.Push(msg)
+	if err != nil {
+		plog.Error("(transfer mesh) reassemble segmentation message error:", pfield.Error(err))
+		return
+	}
+	if buf == nil {
+		return
+	}
+	// 重组后的缓存不再复用，无需拷贝负载
+	sMsg, err := listener.mesh.serverCodec.DecodeBorrowed(buf)
+	if err != nil {
+		plog.Error("(transfer mesh) decode segmentation message error:", pfield.Error(err))
+		return
+	}
+	listener.handleMessage(sess, sMsg)
+}
@@ -0,0 +1,313 @@
+package transfer
+
+import (
+	"context"
+	"github.com/meow-pad/chinchilla/option"
+	"github.com/meow-pad/chinchilla/transfer/codec"
+	"github.com/meow-pad/chinchilla/transfer/common"
+	"github.com/meow-pad/chinchilla/transfer/router"
+	"github.com/meow-pad/chinchilla/transfer/service"
+	"github.com/meow-pad/chinchilla/utils/gopool"
+	"github.com/meow-pad/persian/frame/pboot"
+	"github.com/meow-pad/persian/frame/pnet/tcp/session"
+	pgopool "github.com/meow-pad/persian/utils/gopool"
+	"github.com/nacos-group/nacos-sdk-go/v2/model"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testMeshSelfId = "gateway-self"
+
+type testAppInfo struct {
+	pboot.AppInfo
+
+	id string
+}
+
+func (appInfo *testAppInfo) Id() string {
+	return appInfo.id
+}
+
+// testMeshSession
+//
+//	@Description: 记录发出消息的组网会话
+type testMeshSession struct {
+	session.Session
+
+	ctx  session.Context
+	lock sync.Mutex
+	sent []any
+}
+
+func (sess *testMeshSession) Context() session.Context {
+	return sess.ctx
+}
+
+func (sess *testMeshSession) SendMessage(message any) {
+	sess.lock.Lock()
+	defer sess.lock.Unlock()
+	sess.sent = append(sess.sent, message)
+}
+
+// testPeer
+//
+//	@Description: 其它网关，收到查询时按 users 回复
+type testPeer struct {
+	service.Service
+
+	mesh    *Mesh
+	info    common.Info
+	users   map[string]bool
+	noReply bool
+
+	lock    sync.Mutex
+	lookups int
+	relays  []*codec.MeshRelayReq
+}
+
+func newTestPeer(mesh *Mesh, peerId string, users ...string) *testPeer {
+	peer := &testPeer{
+		mesh: mesh,
+		info: common.Info(model.Instance{
+			Enable:   true,
+			Healthy:  true,
+			Metadata: map[string]string{common.MetadataKeyId: peerId},
+		}),
+		users: make(map[string]bool),
+	}
+	for _, user := range users {
+		peer.users[user] = true
+	}
+	mesh.manager.services.Store(peerId, peer)
+	return peer
+}
+
+func (peer *testPeer) Info() common.Info {
+	return peer.info
+}
+
+func (peer *testPeer) IsEnable() bool {
+	return true
+}
+
+func (peer *testPeer) SendMessage(msg any) error {
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	switch tMsg := msg.(type) {
+	case *codec.MeshLookupReq:
+		peer.lookups++
+		if peer.noReply {
+			return nil
+		}
+		res := &codec.MeshLookupRes{Seq: tMsg.Seq, Code: common.ErrCodeNoSession}
+		if peer.users[tMsg.RouterId] {
+			res.Code = common.ErrCodeSuccess
+		}
+		go peer.mesh.handleLookupRes(peer.info.ServiceId(), res)
+	case *codec.MeshRelayReq:
+		peer.relays = append(peer.relays, tMsg)
+	}
+	return nil
+}
+
+func (peer *testPeer) counts() (int, int) {
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	return peer.lookups, len(peer.relays)
+}
+
+func newTestMesh(t *testing.T, opts ...option.Option) *Mesh {
+	goPool, err := pgopool.NewGoroutinePool("test", 4, 16, true)
+	require.Nil(t, err)
+	require.Nil(t, goPool.Start())
+	opts = append([]option.Option{
+		option.WithMeshServiceName("mesh"),
+		option.WithTransferServiceAuthKey("key"),
+		option.WithMeshLookupTimeout(100 * time.Millisecond),
+	}, opts...)
+	transfer := &Transfer{
+		AppInfo:      &testAppInfo{id: testMeshSelfId},
+		Options:      option.NewOptions(opts...),
+		GoPool:       gopool.NewGoPool(goPool),
+		sessionIndex: NewSessionIndex(),
+	}
+	manager, err := NewManager(transfer, "mesh", codec.NewClientCodec(codec.MessageCodecByteOrder))
+	require.Nil(t, err)
+	mesh := &Mesh{
+		transfer:      transfer,
+		manager:       manager,
+		serverCodec:   codec.NewServerCodec(codec.MessageCodecByteOrder),
+		userPeers:     make(map[string]meshUserPeer),
+		pendingRoutes: make(map[string][]*meshPendingRoute),
+	}
+	mesh.msgCoder, err = codec.NewCodec(mesh.serverCodec, 8*1024)
+	require.Nil(t, err)
+	transfer.mesh = mesh
+	return mesh
+}
+
+func testContext(t *testing.T, timeout time.Duration) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestMeshListener_HandshakeRejected(t *testing.T) {
+	should := require.New(t)
+	mesh := newTestMesh(t)
+	mListener := &meshListener{mesh: mesh}
+	valid := codec.HandshakeReq{Id: "gateway-peer", AuthKey: "key", Service: "mesh", ServiceId: testMeshSelfId}
+	invalid := map[uint16]codec.HandshakeReq{}
+	req := valid
+	req.Id = ""
+	invalid[common.ErrCodeInvalidTransferId] = req
+	req = valid
+	req.AuthKey = "bad"
+	invalid[common.ErrCodeInvalidAuth] = req
+	req = valid
+	req.Service = "other"
+	invalid[common.ErrCodeInvalidService] = req
+	req = valid
+	req.ServiceId = "other-gateway"
+	invalid[common.ErrCodeInvalidServiceId] = req
+	for code, req := range invalid {
+		req := req
+		sess := &testMeshSession{ctx: &meshContext{}}
+		mListener.handleMessage(sess, &req)
+		should.Len(sess.sent, 1)
+		should.Equal(code, sess.sent[0].(*codec.HandshakeRes).Code)
+		should.False(sess.ctx.(*meshContext).isHandShook())
+		// 握手失败后不处理查询
+		mListener.handleMessage(sess, &codec.MeshLookupReq{Seq: 1, RouterId: "uid1"})
+		should.Len(sess.sent, 1)
+	}
+	sess := &testMeshSession{ctx: &meshContext{}}
+	mListener.handleMessage(sess, &valid)
+	should.Equal(uint16(common.ErrCodeSuccess), sess.sent[0].(*codec.HandshakeRes).Code)
+	should.True(sess.ctx.(*meshContext).isHandShook())
+	mesh.transfer.sessionIndex.Bind("uid1", 1)
+	mListener.handleMessage(sess, &codec.MeshLookupReq{Seq: 1, RouterId: "uid1"})
+	should.Equal(&codec.MeshLookupRes{Seq: 1, Code: common.ErrCodeSuccess}, sess.sent[1])
+}
+
+func TestMesh_LookupUser(t *testing.T) {
+	should := require.New(t)
+	mesh := newTestMesh(t)
+	// 无其它网关
+	_, err := mesh.LookupUser(testContext(t, time.Second), "uid1")
+	should.ErrorIs(err, common.ErrNoSession)
+
+	newTestPeer(mesh, "gateway-1")
+	newTestPeer(mesh, "gateway-2", "uid1")
+	peerId, err := mesh.LookupUser(testContext(t, time.Second), "uid1")
+	should.Nil(err)
+	should.Equal("gateway-2", peerId)
+	_, err = mesh.LookupUser(testContext(t, time.Second), "uid2")
+	should.ErrorIs(err, common.ErrNoSession)
+
+	// 未回复的网关导致超时
+	silent := newTestPeer(mesh, "gateway-3")
+	silent.noReply = true
+	_, err = mesh.LookupUser(testContext(t, 20*time.Millisecond), "uid2")
+	should.ErrorIs(err, common.ErrNoSession)
+	should.ErrorContains(err, "deadline")
+	should.Empty(mesh.lookups.Keys())
+}
+
+func TestMesh_RouteUserCache(t *testing.T) {
+	should := require.New(t)
+	mesh := newTestMesh(t)
+	peer := newTestPeer(mesh, "gateway-1", "uid1")
+	failures := make(chan error, 8)
+	onFailure := func(err error) {
+		failures <- err
+	}
+	for i := 0; i < 3; i++ {
+		should.Nil(mesh.RouteUser("srv", "srv-1", &codec.MessageRouter{
+			CorrelationId: 1,
+			RouterType:    router.RouteTypeUser,
+			RouterId:      "uid1",
+			Payload:       []byte{byte(i)},
+		}, onFailure))
+	}
+	should.Eventually(func() bool {
+		_, relays := peer.counts()
+		return relays == 3
+	}, time.Second, time.Millisecond)
+	// 查询期间的消息排队且只查询一次
+	lookups, _ := peer.counts()
+	should.Equal(1, lookups)
+	peer.lock.Lock()
+	relayArr := peer.relays
+	peer.lock.Unlock()
+	for i, relay := range relayArr {
+		msg, err := mesh.manager.clientCodec.Decode(relay.Payload)
+		should.Nil(err)
+		should.Equal([]byte{byte(i)}, msg.(*codec.MessageRouter).Payload)
+		should.Zero(msg.(*codec.MessageRouter).CorrelationId)
+	}
+	// 命中缓存时直接转发
+	should.Nil(mesh.RouteUser("srv", "srv-1", &codec.MessageRouter{RouterId: "uid1"}, onFailure))
+	lookups, relays := peer.counts()
+	should.Equal(1, lookups)
+	should.Equal(4, relays)
+
+	// 未找到时回调失败且不缓存
+	should.Nil(mesh.RouteUser("srv", "srv-1", &codec.MessageRouter{RouterId: "uid2"}, onFailure))
+	should.ErrorIs(<-failures, common.ErrNoSession)
+	should.Eventually(func() bool {
+		mesh.userLock.Lock()
+		defer mesh.userLock.Unlock()
+		return len(mesh.pendingRoutes) == 0
+	}, time.Second, time.Millisecond)
+	should.NotContains(mesh.userPeers, "uid2")
+
+	// 缓存过期后清理
+	mesh.purgeUserPeers(time.Now().Add(time.Hour).UnixMilli())
+	should.Empty(mesh.userPeers)
+}
+
+func TestMesh_RouteUserTimeout(t *testing.T) {
+	should := require.New(t)
+	mesh := newTestMesh(t, option.WithMeshLookupTimeout(20*time.Millisecond))
+	peer := newTestPeer(mesh, "gateway-1", "uid1")
+	peer.noReply = true
+	failures := make(chan error, 1)
+	should.Nil(mesh.RouteUser("srv", "srv-1", &codec.MessageRouter{RouterId: "uid1"}, func(err error) {
+		failures <- err
+	}))
+	select {
+	case err := <-failures:
+		should.ErrorIs(err, common.ErrNoSession)
+	case <-time.After(time.Second):
+		should.Fail("route timeout not reported")
+	}
+	_, relays := peer.counts()
+	should.Zero(relays)
+}
+
+func TestListener_RouteViaMeshRelayed(t *testing.T) {
+	should := require.New(t)
+	mesh := newTestMesh(t)
+	peer := newTestPeer(mesh, "gateway-1", "uid1")
+	msg := &codec.MessageRouter{RouterType: router.RouteTypeUser, RouterId: "uid1"}
+	sess := &testMeshSession{}
+	// 其它网关转发来的消息不再转发，避免网关间往返
+	relayed := &listener{manager: mesh.manager, service: peer, relayed: true}
+	should.ErrorIs(relayed.routeViaMesh(sess, msg, common.ErrNoSession), common.ErrNoSession)
+	lookups, relays := peer.counts()
+	should.Zero(lookups)
+	should.Zero(relays)
+	// 非会话缺失的错误及非用户路由不转发
+	origin := &listener{manager: mesh.manager, service: peer}
+	should.ErrorIs(origin.routeViaMesh(sess, msg, common.ErrNotBound), common.ErrNotBound)
+	should.ErrorIs(origin.routeViaMesh(sess, &codec.MessageRouter{RouterType: router.RouteTypeConn, RouterId: "1"},
+		common.ErrNoSession), common.ErrNoSession)
+	should.Nil(origin.routeViaMesh(sess, msg, common.ErrNoSession))
+	should.Eventually(func() bool {
+		_, relays := peer.counts()
+		return relays == 1
+	}, time.Second, time.Millisecond)
+}
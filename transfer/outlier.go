@@ -0,0 +1,156 @@
+package transfer
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// 摘除时长翻倍的最大次数，避免溢出
+	maxOutlierEjectLevel = 16
+)
+
+func newOutlierDetector(remote *Remote) *outlierDetector {
+	return &outlierDetector{remote: remote}
+}
+
+// outlierDetector
+//
+//	@Description: 远程实例的被动健康检测，统计连续失败、连接断开及握手失败，异常时暂时将实例从选择中摘除
+type outlierDetector struct {
+	remote *Remote
+	// 连续失败次数
+	errors atomic.Int32
+	// 摘除截止时间，单位毫秒，0为未摘除
+	ejectedUntil atomic.Int64
+
+	lock sync.Mutex
+	// 统计窗口内的连接断开时间
+	flaps []int64
+	// 已连续摘除的次数
+	ejectLevel int
+	// 最近恢复时间
+	readmittedAt int64
+}
+
+// onSuccess
+//
+//	@Description: 连接、发送或握手成功
+//	@receiver detector
+func (detector *outlierDetector) onSuccess() {
+	if detector.errors.Load() != 0 {
+		detector.errors.Store(0)
+	}
+}
+
+// onError
+//
+//	@Description: 连接或发送失败
+//	@receiver detector
+func (detector *outlierDetector) onError() {
+	threshold := detector.remote.manager.transfer.Options.TransferOutlierConsecutiveErrors
+	if threshold <= 0 {
+		return
+	}
+	if int(detector.errors.Add(1)) >= threshold {
+		detector.remote.manager.ejectInstance(detector.remote, "consecutive errors")
+	}
+}
+
+// onHandshakeFailure
+//
+//	@Description: 握手被拒绝
+//	@receiver detector
+func (detector *outlierDetector) onHandshakeFailure() {
+	if detector.remote.manager.transfer.Options.TransferOutlierConsecutiveErrors <= 0 {
+		return
+	}
+	detector.remote.manager.ejectInstance(detector.remote, "handshake failure")
+}
+
+// onFlap
+//
+//	@Description: 连接断开
+//	@receiver detector
+func (detector *outlierDetector) onFlap() {
+	options := detector.remote.manager.transfer.Options
+	if options.TransferOutlierFlapThreshold <= 0 {
+		return
+	}
+	now := time.Now().UnixMilli()
+	windowStart := now - options.TransferOutlierFlapWindow.Milliseconds()
+	detector.lock.Lock()
+	flaps := detector.flaps[:0]
+	for _, flapAt := range detector.flaps {
+		if flapAt > windowStart {
+			flaps = append(flaps, flapAt)
+		}
+	}
+	flaps = append(flaps, now)
+	reached := len(flaps) >= options.TransferOutlierFlapThreshold
+	if reached {
+		flaps = flaps[:0]
+	}
+	detector.flaps = flaps
+	detector.lock.Unlock()
+	if reached {
+		detector.remote.manager.ejectInstance(detector.remote, "connection flaps")
+	}
+}
+
+// isEjected
+//
+//	@Description: 是否处于摘除中
+//	@receiver detector
+//	@return bool
+func (detector *outlierDetector) isEjected() bool {
+	return detector.ejectedUntil.Load() > 0
+}
+
+// eject
+//
+//	@Description: 摘除实例，摘除时长按连续摘除次数翻倍
+//	@receiver detector
+//	@param now 当前时间，单位毫秒
+//	@return time.Duration 摘除时长
+func (detector *outlierDetector) eject(now int64) time.Duration {
+	options := detector.remote.manager.transfer.Options
+	detector.lock.Lock()
+	defer detector.lock.Unlock()
+	// 恢复后较长时间未被摘除则重新计算
+	if detector.ejectLevel > 0 && now-detector.readmittedAt > options.TransferOutlierMaxEjectionTime.Milliseconds() {
+		detector.ejectLevel = 0
+	}
+	duration := options.TransferOutlierBaseEjectionTime << detector.ejectLevel
+	if duration <= 0 || duration > options.TransferOutlierMaxEjectionTime {
+		duration = options.TransferOutlierMaxEjectionTime
+	}
+	if detector.ejectLevel < maxOutlierEjectLevel {
+		detector.ejectLevel++
+	}
+	detector.ejectedUntil.Store(now + duration.Milliseconds())
+	detector.errors.Store(0)
+	return duration
+}
+
+// readmit
+//
+//	@Description: 摘除到期则恢复
+//	@receiver detector
+//	@param now 当前时间，单位毫秒
+//	@return bool 是否恢复
+func (detector *outlierDetector) readmit(now int64) bool {
+	ejectedUntil := detector.ejectedUntil.Load()
+	if ejectedUntil <= 0 || ejectedUntil > now {
+		return false
+	}
+	detector.lock.Lock()
+	defer detector.lock.Unlock()
+	if !detector.ejectedUntil.CompareAndSwap(ejectedUntil, 0) {
+		return false
+	}
+	detector.readmittedAt = now
+	detector.errors.Store(0)
+	return true
+}
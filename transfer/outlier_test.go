@@ -0,0 +1,153 @@
+package transfer
+
+import (
+	"github.com/meow-pad/chinchilla/option"
+	"github.com/meow-pad/chinchilla/transfer/common"
+	"github.com/meow-pad/chinchilla/transfer/service"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newTestOutlierManager
+//
+//	@Description: 构建含 num 个未连接实例的服务管理器
+//	@param t
+//	@param num
+//	@param opts
+//	@return *Manager
+//	@return []*Remote
+func newTestOutlierManager(t *testing.T, num int, opts ...option.Option) (*Manager, []*Remote) {
+	opts = append([]option.Option{
+		option.WithTransferOutlierConsecutiveErrors(3),
+		option.WithTransferOutlierBaseEjectionTime(10 * time.Second),
+		option.WithTransferOutlierMaxEjectionTime(80 * time.Second),
+		option.WithTransferOutlierMaxEjectionPercent(50),
+	}, opts...)
+	manager := newTestManager(t, opts...)
+	remotes := make([]*Remote, 0, num)
+	for i := 0; i < num; i++ {
+		remote := newTestRemote(t, manager, "inst"+strconv.Itoa(i))
+		manager.services.Store(remote.Info().ServiceId(), remote)
+		manager.srvInfoArr = append(manager.srvInfoArr, remote.Info())
+		remotes = append(remotes, remote)
+	}
+	return manager, remotes
+}
+
+func testInstIds(infoArr []common.Info) []string {
+	instIds := make([]string, 0, len(infoArr))
+	for _, info := range infoArr {
+		instIds = append(instIds, info.ServiceId())
+	}
+	return instIds
+}
+
+func TestOutlierDetector_Backoff(t *testing.T) {
+	should := require.New(t)
+	_, remotes := newTestOutlierManager(t, 1)
+	detector := remotes[0].outlier
+	now := time.Now().UnixMilli()
+	// 连续摘除时摘除时长翻倍，不超过 MaxEjectionTime
+	for _, expected := range []time.Duration{10, 20, 40, 80, 80, 80} {
+		duration := detector.eject(now)
+		should.Equal(expected*time.Second, duration)
+		should.True(detector.isEjected())
+		should.False(detector.readmit(now + duration.Milliseconds() - 1))
+		now += duration.Milliseconds()
+		should.True(detector.readmit(now))
+		should.False(detector.isEjected())
+		now++
+	}
+	// 恢复后超过 MaxEjectionTime 未被摘除则重新计算
+	now += (80 * time.Second).Milliseconds()
+	should.Equal(10*time.Second, detector.eject(now))
+}
+
+func TestOutlierDetector_ConsecutiveErrors(t *testing.T) {
+	should := require.New(t)
+	_, remotes := newTestOutlierManager(t, 2)
+	remote := remotes[0]
+	remote.outlier.onError()
+	remote.outlier.onError()
+	remote.outlier.onSuccess()
+	remote.outlier.onError()
+	remote.outlier.onError()
+	should.False(remote.outlier.isEjected())
+	// 连接中或未握手时的发送计入连续失败
+	remote.state.Store(StateConnecting)
+	should.ErrorIs(remote.SendMessage(nil), ErrConnectingClient)
+	should.True(remote.outlier.isEjected())
+	should.Zero(remote.outlier.errors.Load())
+	// 停用的实例不计入
+	remotes[1].state.Store(StateDisabled)
+	for i := 0; i < 3; i++ {
+		should.ErrorIs(remotes[1].TransferMessage(nil), service.ErrDisabledService)
+	}
+	should.Zero(remotes[1].outlier.errors.Load())
+	should.False(remotes[1].outlier.isEjected())
+}
+
+func TestOutlierDetector_MaxEjectionPercent(t *testing.T) {
+	should := require.New(t)
+	manager, remotes := newTestOutlierManager(t, 4)
+	should.True(manager.ejectInstance(remotes[0], "test"))
+	should.False(manager.ejectInstance(remotes[0], "test"))
+	should.True(manager.ejectInstance(remotes[1], "test"))
+	// 超过最大摘除比例
+	should.False(manager.ejectInstance(remotes[2], "test"))
+	should.False(remotes[2].outlier.isEjected())
+	// 只有一个实例时不摘除
+	single, singleRemotes := newTestOutlierManager(t, 1)
+	should.False(single.ejectInstance(singleRemotes[0], "test"))
+	should.False(singleRemotes[0].outlier.isEjected())
+	// 不在可用实例中
+	other := newTestRemote(t, manager, "other")
+	should.False(manager.ejectInstance(other, "test"))
+}
+
+func TestOutlierDetector_Readmit(t *testing.T) {
+	should := require.New(t)
+	var notified [][]string
+	manager, remotes := newTestOutlierManager(t, 2,
+		option.WithTransferOutlierBaseEjectionTime(time.Minute),
+		option.WithServiceInstListener(func(srvName string, instances []common.Info) {
+			notified = append(notified, testInstIds(instances))
+		}))
+	should.True(manager.ejectInstance(remotes[0], "test"))
+	should.Equal([][]string{{"inst1"}}, notified)
+	for i := 0; i < 10; i++ {
+		instId, err := manager.selector.Select("")
+		should.Nil(err)
+		should.Equal("inst1", instId)
+	}
+	// 未到期时不恢复
+	ejectedUntil := remotes[0].outlier.ejectedUntil.Load()
+	should.False(remotes[0].outlier.readmit(ejectedUntil - 1))
+	manager.readmitInstances(ejectedUntil - 1)
+	should.True(remotes[0].outlier.isEjected())
+	should.Len(notified, 1)
+	// 到期后恢复并通知
+	manager.readmitInstances(ejectedUntil)
+	should.False(remotes[0].outlier.isEjected())
+	should.Equal([][]string{{"inst1"}, {"inst0", "inst1"}}, notified)
+	// 过时的通知丢弃
+	manager.notifyInstances(1, nil)
+	should.Len(notified, 2)
+}
+
+func TestRemoteListener_SelfClosedNotFlap(t *testing.T) {
+	should := require.New(t)
+	_, remotes := newTestOutlierManager(t, 1, option.WithTransferOutlierFlapThreshold(3))
+	remote := remotes[0]
+	// 已停止的实例不会重连
+	remote.state.Store(StateStopped)
+	listener := newRemoteListener(remote)
+	remote.closing.Store(true)
+	listener.OnClosed(nil)
+	should.Empty(remote.outlier.flaps)
+	should.False(remote.closing.Load())
+	listener.OnClosed(nil)
+	should.Len(remote.outlier.flaps, 1)
+}
@@ -3,7 +3,9 @@ package transfer
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/meow-pad/chinchilla/option"
+	"github.com/meow-pad/chinchilla/transfer/common"
 	"github.com/meow-pad/persian/frame/pboot"
 	"github.com/meow-pad/persian/frame/plog"
 	"github.com/meow-pad/persian/frame/plog/pfield"
@@ -91,6 +93,77 @@ func (registry *Registry) Stop(ctx context.Context) error {
 	return nil
 }
 
+// WatchService
+//
+//	@Description: 初始化并订阅服务实例，实例变化时通知 Transfer.UpdateInstances
+//	@receiver registry
+//	@param srv
+//	@return error
+func (registry *Registry) WatchService(srv string) error {
+	if err := registry.initService(srv); err != nil {
+		return err
+	}
+	return registry.subscribeService(srv)
+}
+
+// RegisterInstance
+//
+//	@Description: 以当前应用的id注册实例
+//	@receiver registry
+//	@param srv 服务名
+//	@param ip
+//	@param port
+//	@return error
+func (registry *Registry) RegisterInstance(srv string, ip string, port uint64) error {
+	result, err := registry.naming.RegisterInstance(vo.RegisterInstanceParam{
+		Ip:      ip,
+		Port:    port,
+		Weight:  1,
+		Enable:  true,
+		Healthy: true,
+		Metadata: map[string]string{
+			common.MetadataKeyId: registry.appInfo.Id(),
+		},
+		ClusterName: registry.appInfo.Cluster(),
+		ServiceName: srv,
+		GroupName:   registry.appInfo.NamingGroup(),
+		Ephemeral:   true,
+	})
+	if err != nil {
+		return err
+	}
+	if !result {
+		return fmt.Errorf("fail to register instance of service %s", srv)
+	}
+	return nil
+}
+
+// DeregisterInstance
+//
+//	@Description: 注销 RegisterInstance 注册的实例
+//	@receiver registry
+//	@param srv
+//	@param ip
+//	@param port
+//	@return error
+func (registry *Registry) DeregisterInstance(srv string, ip string, port uint64) error {
+	result, err := registry.naming.DeregisterInstance(vo.DeregisterInstanceParam{
+		Ip:          ip,
+		Port:        port,
+		Cluster:     registry.appInfo.Cluster(),
+		ServiceName: srv,
+		GroupName:   registry.appInfo.NamingGroup(),
+		Ephemeral:   true,
+	})
+	if err != nil {
+		return err
+	}
+	if !result {
+		return fmt.Errorf("fail to deregister instance of service %s", srv)
+	}
+	return nil
+}
+
 // initService
 //
 //	@Description: 初始化服务当前所有实例
@@ -11,10 +11,10 @@ import (
 	"github.com/meow-pad/persian/frame/plog"
 	"github.com/meow-pad/persian/frame/plog/pfield"
 	"github.com/meow-pad/persian/frame/pnet/tcp/client"
-	tcodec "github.com/meow-pad/persian/frame/pnet/tcp/codec"
 	"github.com/meow-pad/persian/frame/pnet/tcp/session"
 	"github.com/meow-pad/persian/utils/json"
 	"math"
+	"reflect"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -163,7 +163,7 @@ func (connCtx *connectContext) cancelConnect() {
 // Remote 远程服务实例
 type Remote struct {
 	manager   *Manager
-	codec     tcodec.Codec
+	codec     *codec.FrameCodec
 	inner     *client.Client
 	info      common.Info
 	state     atomic.Int32
@@ -172,9 +172,17 @@ type Remote struct {
 	connectCtx *connectContext
 	// 最终关闭时间
 	deadline int64
-	// 分段数据
-	segmentFrameBuf    []byte
-	segmentFrameAmount uint16
+	// 分段消息重组
+	reassembler *codec.Reassembler
+	// 批量发送
+	batcher *remoteBatcher
+	// 握手协商结果
+	peerVersion  atomic.Uint32
+	capabilities atomic.Uint64
+	// 被动健康检测
+	outlier *outlierDetector
+	// 主动关闭连接（如握手失败、写出失败），关闭时不计入连接断开
+	closing atomic.Bool
 }
 
 func (remoteSrv *Remote) init(manager *Manager, srvInfo common.Info) error {
@@ -187,11 +195,15 @@ func (remoteSrv *Remote) init(manager *Manager, srvInfo common.Info) error {
 	remoteSrv.deadline = math.MaxInt64
 	// 编码器
 	options := remoteSrv.manager.transfer.Options
-	cCodec, err := codec.NewCodec(remoteSrv.manager.clientCodec, options.TransferMessageWarningSize)
+	cCodec, err := codec.NewFrameCodec(remoteSrv.manager.clientCodec,
+		options.TransferMessageWarningSize, options.TransferMaxFrameSize)
 	if err != nil {
 		return err
 	}
 	remoteSrv.codec = cCodec
+	remoteSrv.reassembler = codec.NewReassembler(options.TransferSegmentMaxSize, options.TransferSegmentTimeout)
+	remoteSrv.batcher = newRemoteBatcher(remoteSrv, options.TransferBatchWindow, options.TransferBatchMaxSize)
+	remoteSrv.outlier = newOutlierDetector(remoteSrv)
 	remoteSrv.connectCtx = newConnectContext(remoteSrv.onConnect, remoteSrv.onConnected, remoteSrv.onCancelConnect)
 	return nil
 }
@@ -297,12 +309,14 @@ func (remoteSrv *Remote) onConnected(tClient *client.Client, err error) {
 			pfield.String("srvIp", remoteSrv.info.Ip),
 			pfield.Uint64("srvPort", remoteSrv.info.Port),
 			pfield.Error(err))
+		remoteSrv.outlier.onError()
 		// 回滚状态
 		if !remoteSrv.state.CompareAndSwap(StateConnecting, StateInitialized) {
 			plog.Error("transfer client cant change state to StateInitialized on connecting",
 				pfield.Int32("curState", cutState))
 		}
 	} else {
+		remoteSrv.closing.Store(false)
 		remoteSrv.inner = tClient
 		// 进入链接状态
 		if !remoteSrv.state.CompareAndSwap(StateConnecting, StateConnected) {
@@ -401,20 +415,103 @@ func (remoteSrv *Remote) handshake() {
 	}
 	appInfo := remoteSrv.manager.transfer.AppInfo
 	options := remoteSrv.manager.transfer.Options
-	// 发送握手
-	remoteSrv.inner.SendMessage(&codec.HandshakeReq{
-		Id:        appInfo.Id(), // 当前服务Id
-		AuthKey:   options.TransferServiceAuthKey,
-		Service:   remoteSrv.info.Service(),   // 对方服务名
-		ServiceId: remoteSrv.info.ServiceId(), // 对方实例Id
-	})
+	// 新连接需重新协商，并丢弃旧连接未完成的分段
+	remoteSrv.peerVersion.Store(0)
+	remoteSrv.capabilities.Store(0)
+	remoteSrv.codec.DisableBigFrame()
+	remoteSrv.codec.DisableCompression()
+	remoteSrv.codec.DisableSegmentId()
+	remoteSrv.reassembler.Reset()
+	remoteSrv.batcher.reset()
+	// 发送握手，未进入发送队列时计入失败
+	if err := remoteSrv.writeMessage(&codec.HandshakeReq{
+		Id:           appInfo.Id(), // 当前服务Id
+		AuthKey:      options.TransferServiceAuthKey,
+		Service:      remoteSrv.info.Service(),   // 对方服务名
+		ServiceId:    remoteSrv.info.ServiceId(), // 对方实例Id
+		MaxFrameSize: uint32(remoteSrv.codec.MaxFrameSize()),
+		Version:      codec.ProtocolVersion,
+		Capabilities: remoteSrv.localCapabilities(),
+	}); err != nil {
+		plog.Error("(transfer client) send handshake error:", pfield.Error(err))
+	}
+}
+
+// localCapabilities
+//
+//	@Description: 本端开启的能力
+//	@receiver remoteSrv
+//	@return uint64
+func (remoteSrv *Remote) localCapabilities() uint64 {
+	capabilities := uint64(codec.SupportedCapabilities)
+	if !remoteSrv.manager.transfer.Options.TransferCompression {
+		capabilities &^= codec.CapCompression
+	}
+	return capabilities
 }
 
-func (remoteSrv *Remote) onHandshake() {
+// onHandshake
+//
+//	@Description: 握手成功
+//	@receiver remoteSrv
+//	@param res 握手结果，旧版本对端无版本及能力字段
+func (remoteSrv *Remote) onHandshake(res *codec.HandshakeRes) {
+	// 以双方都支持的能力为准
+	capabilities := res.Capabilities & remoteSrv.localCapabilities()
+	remoteSrv.peerVersion.Store(uint32(res.Version))
+	remoteSrv.capabilities.Store(capabilities)
+	if capabilities&codec.CapBigFrame != 0 {
+		// 对端支持时开启大帧，否则超长消息仍分段发送
+		remoteSrv.codec.EnableBigFrame(int(res.MaxFrameSize))
+	}
+	if capabilities&codec.CapCompression != 0 {
+		remoteSrv.codec.EnableCompression(remoteSrv.manager.transfer.Options.TransferCompressThreshold)
+	}
+	if capabilities&codec.CapSegmentId != 0 {
+		remoteSrv.codec.EnableSegmentId()
+	}
 	remoteSrv.certified.CompareAndSwap(false, true)
+	remoteSrv.outlier.onSuccess()
 	plog.Debug("(transfer client) on handshake", pfield.Any("info", remoteSrv.info))
 }
 
+// PeerVersion
+//
+//	@Description: 对端协议版本，未握手或旧版本对端为0
+//	@receiver remoteSrv
+//	@return uint16
+func (remoteSrv *Remote) PeerVersion() uint16 {
+	return uint16(remoteSrv.peerVersion.Load())
+}
+
+// Capabilities
+//
+//	@Description: 当前连接协商后的能力
+//	@receiver remoteSrv
+//	@return uint64
+func (remoteSrv *Remote) Capabilities() uint64 {
+	return remoteSrv.capabilities.Load()
+}
+
+// HasCapability
+//
+//	@Description: 当前连接是否支持某项能力
+//	@receiver remoteSrv
+//	@param capability
+//	@return bool
+func (remoteSrv *Remote) HasCapability(capability uint64) bool {
+	return remoteSrv.capabilities.Load()&capability == capability
+}
+
+// CompressionStats
+//
+//	@Description: 当前实例的压缩统计
+//	@receiver remoteSrv
+//	@return codec.CompressionStats
+func (remoteSrv *Remote) CompressionStats() codec.CompressionStats {
+	return remoteSrv.codec.CompressionStats()
+}
+
 // KeepAlive
 //
 //	@Description: 连接保活
@@ -462,16 +559,29 @@ func (remoteSrv *Remote) KeepAlive() bool {
 //	@return error
 func (remoteSrv *Remote) checkAlive() error {
 	switch remoteSrv.state.Load() {
-	//case StateInitialized:
-	//	return ErrConnectClientFirst
-	case StateConnecting:
-		return ErrConnectingClient
 	case StateDisabled:
 		return service.ErrDisabledService
 	case StateStopped:
 		return service.ErrStoppedInstance
 	default:
 	}
+	if err := remoteSrv.checkConn(); err != nil {
+		// 连接不可用计入连续失败
+		remoteSrv.outlier.onError()
+		return err
+	}
+	return nil
+}
+
+// checkConn
+//
+//	@Description: 检查连接是否可用，连接断开时重连
+//	@receiver remoteSrv
+//	@return error
+func (remoteSrv *Remote) checkConn() error {
+	if remoteSrv.state.Load() == StateConnecting {
+		return ErrConnectingClient
+	}
 	if remoteSrv.inner == nil || remoteSrv.inner.IsClosed() {
 		err := remoteSrv.Connect()
 		if err != nil {
@@ -491,7 +601,37 @@ func (remoteSrv *Remote) SendMessage(msg any) error {
 	if err := remoteSrv.checkAlive(); err != nil {
 		return err
 	}
-	remoteSrv.inner.SendMessage(msg)
+	if remoteSrv.batcher.enabled() {
+		return remoteSrv.batcher.send(msg)
+	}
+	return remoteSrv.writeMessage(msg)
+}
+
+// writeMessage
+//
+//	@Description: 编码并写出消息
+//	@receiver remoteSrv
+//	@param msg
+//	@return error 编码或写入发送队列失败
+func (remoteSrv *Remote) writeMessage(msg any) error {
+	// 使用复用缓存编码，写入发送缓冲后即可归还
+	buf, err := remoteSrv.codec.EncodeBuffer(msg)
+	if err != nil {
+		return err
+	}
+	if err = remoteSrv.asyncWrite(buf.B, func(wErr error) {
+		buf.Release()
+		if wErr == nil && plog.LoggerLevel() == plog.DebugLevel {
+			plog.Debug("(transfer) client send message:",
+				pfield.String("msgType", reflect.TypeOf(msg).String()),
+				pfield.JsonString("msg", msg),
+			)
+		}
+	}); err != nil {
+		// 未进入发送队列，回调不会执行
+		buf.Release()
+		return err
+	}
 	return nil
 }
 
@@ -499,18 +639,63 @@ func (remoteSrv *Remote) TransferMessage(msg []byte) error {
 	if err := remoteSrv.checkAlive(); err != nil {
 		return err
 	}
-	remoteSrv.inner.AsyncWrite(msg, func(c session.Conn, err error) error {
+	if remoteSrv.batcher.enabled() {
+		// 先发出已缓存的消息
+		if err := remoteSrv.batcher.flush(); err != nil {
+			plog.Error("(transfer client) flush batch error:", pfield.Error(err))
+		}
+	}
+	return remoteSrv.asyncWrite(msg, nil)
+}
+
+// asyncWrite
+//
+//	@Description: 异步写出数据，写出失败时关闭连接（随后重连）
+//	@receiver remoteSrv
+//	@param data
+//	@param onWritten 写出完成时调用（无论成败），可为nil
+//	@return error 写入发送队列失败（如队列已满、连接已关闭），此时 onWritten 不会被调用
+func (remoteSrv *Remote) asyncWrite(data []byte, onWritten func(err error)) error {
+	conn, _ := remoteSrv.inner.Connection().(*client.Conn)
+	if conn == nil {
+		remoteSrv.outlier.onError()
+		return ErrNotConnected
+	}
+	if err := conn.AsyncWrite(data, func(_ session.Conn, err error) error {
+		if onWritten != nil {
+			onWritten(err)
+		}
 		if err != nil {
-			plog.Error("write message error:", pfield.Error(err))
+			remoteSrv.onSendingError("write message error:", err)
 			return nil
 		}
+		remoteSrv.outlier.onSuccess()
 		return nil
-	})
+	}); err != nil {
+		plog.Error("async write error:", pfield.Error(err))
+		remoteSrv.outlier.onError()
+		return err
+	}
 	return nil
 }
 
+// onSendingError
+//
+//	@Description: 写出失败时关闭连接，连接已无法使用
+//	@receiver remoteSrv
+//	@param tip 日志消息
+//	@param err
+func (remoteSrv *Remote) onSendingError(tip string, err error) {
+	plog.Error(tip, pfield.Error(err))
+	remoteSrv.outlier.onError()
+	if cErr := remoteSrv.closeConn(); cErr != nil {
+		plog.Error("close conn error:", pfield.Error(cErr))
+	}
+}
+
 func (remoteSrv *Remote) closeConn() error {
-	if remoteSrv.inner != nil {
+	if remoteSrv.inner != nil && !remoteSrv.inner.IsClosed() {
+		remoteSrv.closing.Store(true)
 		return remoteSrv.inner.Close()
 	}
 	return nil
@@ -0,0 +1,70 @@
+package transfer
+
+import (
+	"github.com/meow-pad/chinchilla/option"
+	"github.com/meow-pad/chinchilla/transfer/codec"
+	"github.com/meow-pad/chinchilla/transfer/common"
+	"github.com/meow-pad/persian/frame/pnet/tcp/client"
+	"github.com/nacos-group/nacos-sdk-go/v2/model"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// newTestRemote
+//
+//	@Description: 构建未连接的远程服务实例
+//	@param t
+//	@param manager
+//	@param srvId
+//	@return *Remote
+func newTestRemote(t *testing.T, manager *Manager, srvId string) *Remote {
+	remote, err := NewRemoteService(manager, common.Info(model.Instance{
+		Ip:       "127.0.0.1",
+		Port:     1,
+		Weight:   1,
+		Enable:   true,
+		Healthy:  true,
+		Metadata: map[string]string{common.MetadataKeyId: srvId},
+	}))
+	require.Nil(t, err)
+	return remote
+}
+
+func newTestManager(t *testing.T, opts ...option.Option) *Manager {
+	transfer := &Transfer{Options: option.NewOptions(opts...)}
+	manager, err := NewManager(transfer, "test", codec.NewClientCodec(codec.MessageCodecByteOrder))
+	require.Nil(t, err)
+	return manager
+}
+
+func TestRemote_WriteNotConnected(t *testing.T) {
+	should := require.New(t)
+	remote := newTestRemote(t, newTestManager(t), "remote1")
+	inner, err := client.NewClient(remote.codec, newRemoteListener(remote))
+	should.Nil(err)
+	remote.inner = inner
+	// 未进入发送队列时返回错误并计入失败次数
+	should.ErrorIs(remote.writeMessage(&codec.HeartbeatSReq{}), ErrNotConnected)
+	should.ErrorIs(remote.asyncWrite([]byte{0, 1, 0}, nil), ErrNotConnected)
+	should.Equal(int32(2), remote.outlier.errors.Load())
+}
+
+func TestRemoteBatcher_FlushHandsOffEntries(t *testing.T) {
+	should := require.New(t)
+	remote := newTestRemote(t, newTestManager(t), "remote1")
+	inner, err := client.NewClient(remote.codec, newRemoteListener(remote))
+	should.Nil(err)
+	remote.inner = inner
+	batcher := newRemoteBatcher(remote, time.Hour, 16*1024)
+	should.Nil(batcher.send(&codec.MessageSReq{ConnId: 1, Payload: []byte{1}}))
+	should.Nil(batcher.send(&codec.MessageSReq{ConnId: 2, Payload: []byte{2}}))
+	flushed := batcher.entries
+	// 未连接时写出失败，缓存仍需交出
+	should.ErrorIs(batcher.flush(), ErrNotConnected)
+	should.Nil(batcher.send(&codec.MessageSReq{ConnId: 3, Payload: []byte{3}}))
+	// 下一批不会写入已交给消息的缓存
+	should.Equal([]codec.BatchEntry{{ConnId: 1, Payload: []byte{1}}, {ConnId: 2, Payload: []byte{2}}}, flushed)
+	should.Equal([]codec.BatchEntry{{ConnId: 3, Payload: []byte{3}}}, batcher.entries)
+	batcher.reset()
+}
@@ -1,6 +1,7 @@
 package router
 
 import (
+	"github.com/meow-pad/chinchilla/transfer/common"
 	"github.com/meow-pad/chinchilla/transfer/service"
 	"github.com/meow-pad/persian/frame/plog"
 	"github.com/meow-pad/persian/frame/plog/pfield"
@@ -10,6 +11,18 @@ import (
 const (
 	RouteTypeAll     = 0
 	RouteTypeService = -1
+	// RouteTypeSelect 由服务的选择器按 routerId 选择实例，由 Manager 注册为内置路由
+	RouteTypeSelect = -2
+	// RouteTypeHash 按 routerId 在可用实例的一致性哈希环上选择实例，由 Manager 注册为内置路由
+	RouteTypeHash = -3
+	// RouteTypeConn routerId 为连接id，发往该会话绑定的服务实例，由 Manager 注册为内置路由
+	RouteTypeConn = -4
+	// RouteTypeUser routerId 为会话注册时的 RouterId（如uid），发往该会话绑定的服务实例，由 Manager 注册为内置路由
+	RouteTypeUser = -5
+	// RouteTypeConnClient 同 RouteTypeConn，但 Payload 作为 MessageRes 直接下发至客户端
+	RouteTypeConnClient = -6
+	// RouteTypeUserClient 同 RouteTypeUser，但 Payload 作为 MessageRes 直接下发至客户端
+	RouteTypeUserClient = -7
 )
 
 type CommonRouter struct {
@@ -32,16 +45,18 @@ func (router *CommonRouter) Route(
 			return true
 		})
 	case RouteTypeService:
-		srv := router.GetOpenService(services, routerId)
-		if srv != nil {
-			if err := srv.TransferMessage(msg); err != nil {
-				plog.Error("transfer message error:", pfield.Error(err))
-			}
-		} else {
+		srv, _ := services.Load(routerId)
+		if srv == nil {
 			plog.Debug("unknown router service", pfield.String("serviceId", routerId))
+			return common.ErrNoInstance
+		}
+		if srv.IsStopped() {
+			return service.ErrStoppedInstance
 		}
+		return srv.TransferMessage(msg)
 	default:
 		plog.Warn("unknown router type", pfield.Int16("routerType", routerType))
+		return common.ErrUnknownRouteType
 	}
 	return nil
 }
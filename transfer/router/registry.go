@@ -0,0 +1,104 @@
+package router
+
+import (
+	"fmt"
+	"github.com/meow-pad/chinchilla/transfer/selector"
+	"github.com/meow-pad/chinchilla/transfer/service"
+	"github.com/meow-pad/persian/utils/collections"
+)
+
+// Context
+//
+//	@Description: 路由所属服务管理器的上下文
+type Context interface {
+	// ServiceName
+	//  @Description: 目标服务名
+	//  @return string
+	//
+	ServiceName() string
+
+	// Services
+	//  @Description: 目标服务的实例，键为实例id
+	//  @return *collections.SyncMap[string, service.Service]
+	//
+	Services() *collections.SyncMap[string, service.Service]
+
+	// Selector
+	//  @Description: 目标服务的选择器
+	//  @return selector.Selector
+	//
+	Selector() selector.Selector
+
+	// SelectInstance
+	//  @Description: 通过选择器选择可用实例（已摘除异常实例）
+	//  @param routerId
+	//  @return service.Service 无可用实例时为nil
+	//  @return error
+	//
+	SelectInstance(routerId string) (service.Service, error)
+}
+
+// RouteFunc
+//
+//	@Description: 指定路由类型的路由处理
+type RouteFunc func(ctx Context, routerId string, msg []byte) error
+
+// NewRegistry
+//
+//	@Description: 构建路由类型注册表
+//	@param fallback 未注册路由类型的处理路由，为nil时使用 CommonRouter（RouteTypeAll、RouteTypeService）
+//	@return *Registry
+func NewRegistry(fallback Router) *Registry {
+	if fallback == nil {
+		fallback = &CommonRouter{}
+	}
+	return &Registry{fallback: fallback}
+}
+
+// Registry
+//
+//	@Description: 按路由类型分发的路由，未注册的类型交由 fallback 处理
+type Registry struct {
+	fallback Router
+	routes   collections.SyncMap[int16, RouteFunc]
+}
+
+// Register
+//
+//	@Description: 注册路由类型处理，覆盖已注册的处理（包括 RouteTypeSelect 等内置路由类型）
+//	@receiver registry
+//	@param routerType
+//	@param route
+//	@return error
+func (registry *Registry) Register(routerType int16, route RouteFunc) error {
+	if route == nil {
+		return fmt.Errorf("nil route of type %d", routerType)
+	}
+	registry.routes.Store(routerType, route)
+	return nil
+}
+
+// Unregister
+//
+//	@Description: 注销路由类型处理，之后该类型交由 fallback 处理
+//	@receiver registry
+//	@param routerType
+func (registry *Registry) Unregister(routerType int16) {
+	registry.routes.Delete(routerType)
+}
+
+// Route
+//
+//	@Description: 按路由类型路由
+//	@receiver registry
+//	@param ctx
+//	@param routerType
+//	@param routerId
+//	@param msg
+//	@return error
+func (registry *Registry) Route(ctx Context, routerType int16, routerId string, msg []byte) error {
+	if route, _ := registry.routes.Load(routerType); route != nil {
+		return route(ctx, routerId, msg)
+	}
+	return registry.fallback.Route(ctx.Services(), routerType, routerId, msg)
+}
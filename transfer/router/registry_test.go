@@ -0,0 +1,106 @@
+package router
+
+import (
+	"github.com/meow-pad/chinchilla/transfer/common"
+	"github.com/meow-pad/chinchilla/transfer/selector"
+	"github.com/meow-pad/chinchilla/transfer/service"
+	"github.com/meow-pad/persian/utils/collections"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type testService struct {
+	service.Service
+
+	received [][]byte
+}
+
+func (srv *testService) IsStopped() bool {
+	return false
+}
+
+func (srv *testService) TransferMessage(msg []byte) error {
+	srv.received = append(srv.received, msg)
+	return nil
+}
+
+type testContext struct {
+	services collections.SyncMap[string, service.Service]
+}
+
+func (ctx *testContext) ServiceName() string {
+	return "test"
+}
+
+func (ctx *testContext) Services() *collections.SyncMap[string, service.Service] {
+	return &ctx.services
+}
+
+func (ctx *testContext) Selector() selector.Selector {
+	return nil
+}
+
+func (ctx *testContext) SelectInstance(routerId string) (service.Service, error) {
+	srv, _ := ctx.services.Load(routerId)
+	return srv, nil
+}
+
+func TestRegistry_Fallback(t *testing.T) {
+	should := require.New(t)
+	ctx := &testContext{}
+	srv := &testService{}
+	ctx.services.Store("inst1", srv)
+	registry := NewRegistry(nil)
+	// 未注册的类型交由 CommonRouter
+	should.Nil(registry.Route(ctx, RouteTypeService, "inst1", []byte{1}))
+	should.Equal([][]byte{{1}}, srv.received)
+	should.ErrorIs(registry.Route(ctx, RouteTypeService, "inst2", []byte{1}), common.ErrNoInstance)
+	should.ErrorIs(registry.Route(ctx, 10, "inst1", []byte{1}), common.ErrUnknownRouteType)
+	should.ErrorIs(registry.Route(ctx, RouteTypeSelect, "inst1", []byte{1}), common.ErrUnknownRouteType)
+}
+
+func TestRegistry_Override(t *testing.T) {
+	should := require.New(t)
+	ctx := &testContext{}
+	srv := &testService{}
+	ctx.services.Store("inst1", srv)
+	registry := NewRegistry(nil)
+	should.NotNil(registry.Register(10, nil))
+	var routed []string
+	should.Nil(registry.Register(10, func(rCtx Context, routerId string, msg []byte) error {
+		should.Same(ctx, rCtx)
+		routed = append(routed, "first:"+routerId)
+		return nil
+	}))
+	should.Nil(registry.Route(ctx, 10, "a", nil))
+	// 重复注册覆盖之前的处理
+	should.Nil(registry.Register(10, func(rCtx Context, routerId string, msg []byte) error {
+		routed = append(routed, "second:"+routerId)
+		srv, _ := rCtx.SelectInstance(routerId)
+		return srv.TransferMessage(msg)
+	}))
+	should.Nil(registry.Route(ctx, 10, "inst1", []byte{2}))
+	should.Equal([]string{"first:a", "second:inst1"}, routed)
+	should.Equal([][]byte{{2}}, srv.received)
+	// 注销后交由 fallback
+	registry.Unregister(10)
+	should.ErrorIs(registry.Route(ctx, 10, "inst1", nil), common.ErrUnknownRouteType)
+}
+
+func TestRegistry_BuiltinTypes(t *testing.T) {
+	should := require.New(t)
+	ctx := &testContext{}
+	registry := NewRegistry(nil)
+	// 内置路由类型与 fallback 处理的类型均可覆盖
+	for _, routerType := range []int16{RouteTypeAll, RouteTypeService, RouteTypeSelect, RouteTypeHash,
+		RouteTypeConn, RouteTypeUser, RouteTypeConnClient, RouteTypeUserClient} {
+		routerType := routerType
+		called := false
+		should.Nil(registry.Register(routerType, func(Context, string, []byte) error {
+			called = true
+			return nil
+		}))
+		should.Nil(registry.Route(ctx, routerType, "1", nil))
+		should.True(called, "routerType:%d", routerType)
+	}
+}
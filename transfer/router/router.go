@@ -13,7 +13,8 @@ type Router interface {
 	//	@param routerType
 	//  @param routerId
 	//	@param msg
-	//  @return error
+	//  @return error 目标不存在时为 common.ErrNoInstance，不可用时为 service.ErrStoppedInstance 等，
+	//	广播类路由不返回单个实例的错误
 	//
 	Route(services *collections.SyncMap[string, service.Service],
 		routerType int16, routerId string, msg []byte) error
@@ -0,0 +1,219 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"github.com/meow-pad/chinchilla/transfer/codec"
+	"github.com/meow-pad/chinchilla/transfer/router"
+	"github.com/meow-pad/persian/frame/plog"
+	"github.com/meow-pad/persian/frame/plog/pfield"
+	netcodec "github.com/meow-pad/persian/frame/pnet/tcp/codec"
+	"github.com/meow-pad/persian/utils/collections"
+	"sync/atomic"
+)
+
+// SendFunc
+//
+//	@Description: 将路由消息发往网关（如任选一个已握手的网关会话）
+type SendFunc func(msg *codec.MessageRouter) error
+
+// NewClient
+//
+//	@Description: 构建 rpc 客户端
+//	@param serviceName 本服务名
+//	@param serviceId 本服务实例id，响应将路由回该实例
+//	@param msgCodec 路由消息负载编码器，不应开启帧压缩
+//	@param send
+//	@param opts
+//	@return *Client
+func NewClient(serviceName, serviceId string, msgCodec netcodec.Codec, send SendFunc, opts ...Option) *Client {
+	return &Client{
+		serviceName: serviceName,
+		serviceId:   serviceId,
+		msgCodec:    msgCodec,
+		send:        send,
+		options:     newOptions(opts...),
+	}
+}
+
+// Client
+//
+//	@Description: rpc 客户端，负责请求编号、超时及响应分发
+type Client struct {
+	serviceName string
+	serviceId   string
+	msgCodec    netcodec.Codec
+	send        SendFunc
+	options     *Options
+
+	rpcIdGenerator atomic.Uint32
+	pending        collections.SyncMap[uint32, chan *codec.RpcRRes]
+}
+
+// Invoke
+//
+//	@Description: 调用目标服务实例的方法并等待响应
+//	@receiver client
+//	@param ctx 取消或到期时立即返回 ctx.Err()
+//	@param service 目标服务名
+//	@param serviceId 目标服务实例id
+//	@param methodId
+//	@param req
+//	@param resp 响应解码目标，为nil时忽略响应负载
+//	@return error 错误码非成功时为 *Error
+func (client *Client) Invoke(ctx context.Context, service, serviceId string, methodId uint16, req, resp any) error {
+	_, err := client.invoke(ctx, service, router.RouteTypeService, serviceId, methodId, req, resp)
+	return err
+}
+
+// InvokeSelect
+//
+//	@Description: 由网关通过目标服务的选择器按 routerId 选择实例后调用，目标服务需支持回复实例id
+//	@receiver client
+//	@param ctx
+//	@param service 目标服务名
+//	@param routerId 选择器路由id（如公会id）
+//	@param methodId
+//	@param req
+//	@param resp
+//	@return string 处理请求的实例id
+//	@return error
+func (client *Client) InvokeSelect(ctx context.Context, service, routerId string, methodId uint16, req, resp any) (string, error) {
+	return client.invoke(ctx, service, router.RouteTypeSelect, routerId, methodId, req, resp)
+}
+
+func (client *Client) invoke(ctx context.Context, service string, routerType int16, routerId string,
+	methodId uint16, req, resp any) (string, error) {
+	if _, ok := ctx.Deadline(); !ok && client.options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, client.options.Timeout)
+		defer cancel()
+	}
+	body, err := client.options.PayloadCodec.Marshal(req)
+	if err != nil {
+		return "", NewError(CodeBadRequest, err.Error())
+	}
+	rpcId := client.nextRPCId()
+	rpcReq := codec.NewRpcRReq(client.serviceName, client.serviceId, rpcId,
+		encodeRequest(client.options.ByteOrder, methodId, body))
+	// 仅选择器路由需要知道处理实例，其他请求保持旧格式以兼容旧版本服务
+	rpcReq.WantServiceId = routerType == router.RouteTypeSelect
+	msgRouter, err := codec.NewMessageRouter(codec.MessageCodecByteOrder, service,
+		routerType, routerId, client.msgCodec, rpcReq)
+	if err != nil {
+		return "", NewError(CodeBadRequest, err.Error())
+	}
+	if client.options.RouteFeedback {
+		msgRouter.CorrelationId = rpcId
+	}
+	respChan := make(chan *codec.RpcRRes, 1)
+	client.pending.Store(rpcId, respChan)
+	if err = client.send(msgRouter); err != nil {
+		client.pending.Delete(rpcId)
+		return "", NewError(CodeUnavailable, err.Error())
+	}
+	select {
+	case res := <-respChan:
+		if Code(res.Code) != CodeSuccess {
+			return res.ServiceId, NewError(Code(res.Code), string(res.Payload))
+		}
+		if resp == nil || len(res.Payload) <= 0 {
+			return res.ServiceId, nil
+		}
+		if err = client.options.PayloadCodec.Unmarshal(res.Payload, resp); err != nil {
+			return res.ServiceId, NewError(CodeBadResponse, err.Error())
+		}
+		return res.ServiceId, nil
+	case <-ctx.Done():
+		client.pending.Delete(rpcId)
+		return "", ctx.Err()
+	}
+}
+
+// HandleResponse
+//
+//	@Description: 分发收到的响应
+//	@receiver client
+//	@param res
+//	@return bool 是否有等待该响应的调用
+func (client *Client) HandleResponse(res *codec.RpcRRes) bool {
+	respChan, _ := client.pending.Delete(res.RPCId)
+	if respChan == nil {
+		plog.Warn("(rpc) response without pending call",
+			pfield.Uint32("rpcId", res.RPCId),
+			pfield.Uint16("code", res.Code),
+		)
+		return false
+	}
+	respChan <- res
+	return true
+}
+
+// HandleRouteFailure
+//
+//	@Description: 处理网关的路由失败回复，对应调用立即以路由错误码失败
+//	@receiver client
+//	@param res
+//	@return bool 是否有等待该响应的调用
+func (client *Client) HandleRouteFailure(res *codec.MessageRouterRes) bool {
+	respChan, _ := client.pending.Delete(res.CorrelationId)
+	if respChan == nil {
+		return false
+	}
+	respChan <- &codec.RpcRRes{
+		Code:  res.Code,
+		RPCId: res.CorrelationId,
+		Payload: []byte(fmt.Sprintf("route failed, service:%s, routerType:%d, routerId:%s",
+			res.RouterService, res.RouterType, res.RouterId)),
+	}
+	return true
+}
+
+func (client *Client) nextRPCId() uint32 {
+	for {
+		// 0 表示无关联id
+		if rpcId := client.rpcIdGenerator.Add(1); rpcId != 0 {
+			return rpcId
+		}
+	}
+}
+
+// Call
+//
+//	@Description: Invoke 的泛型封装
+//	@param ctx
+//	@param client
+//	@param service
+//	@param serviceId
+//	@param methodId
+//	@param req
+//	@return *Res
+//	@return error
+func Call[Res any](ctx context.Context, client *Client, service, serviceId string, methodId uint16, req any) (*Res, error) {
+	resp := new(Res)
+	if err := client.Invoke(ctx, service, serviceId, methodId, req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// CallSelect
+//
+//	@Description: InvokeSelect 的泛型封装
+//	@param ctx
+//	@param client
+//	@param service
+//	@param routerId
+//	@param methodId
+//	@param req
+//	@return *Res
+//	@return string 处理请求的实例id
+//	@return error
+func CallSelect[Res any](ctx context.Context, client *Client, service, routerId string, methodId uint16, req any) (*Res, string, error) {
+	resp := new(Res)
+	instId, err := client.InvokeSelect(ctx, service, routerId, methodId, req, resp)
+	if err != nil {
+		return nil, instId, err
+	}
+	return resp, instId, nil
+}
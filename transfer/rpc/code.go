@@ -0,0 +1,74 @@
+package rpc
+
+import (
+	"errors"
+	"fmt"
+	"github.com/meow-pad/chinchilla/transfer/common"
+)
+
+// Code
+//
+//	@Description: rpc 响应码，通过 RpcRRes.Code 传递
+type Code uint16
+
+const (
+	CodeSuccess Code = common.ErrCodeSuccess
+)
+
+// 网关路由失败错误码（需开启 Options.RouteFeedback）
+const (
+	CodeNoService        Code = common.ErrCodeNoService
+	CodeNoInstance       Code = common.ErrCodeNoInstance
+	CodeInstanceDisabled Code = common.ErrCodeInstanceDisabled
+	CodeSendFailed       Code = common.ErrCodeSendFailed
+)
+
+// 框架错误码，从100开始以免与网关错误码冲突
+const (
+	CodeUnknownMethod Code = iota + 100 // 未注册的方法
+	CodeBadRequest                      // 请求解码失败
+	CodeBadResponse                     // 响应解码失败
+	CodeInternal                        // 处理器内部错误
+	CodeUnavailable                     // 请求发送失败
+)
+
+// CodeUser 业务错误码起始值
+const CodeUser Code = 1000
+
+// Error
+//
+//	@Description: 带错误码的 rpc 错误，错误码非成功时 Msg 作为响应负载返回
+type Error struct {
+	Code Code
+	Msg  string
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("rpc error code:%d, msg:%s", err.Code, err.Msg)
+}
+
+// NewError
+//
+//	@Description: 构建 rpc 错误，处理器返回该错误时调用方将收到对应错误码
+//	@param code
+//	@param msg
+//	@return *Error
+func NewError(code Code, msg string) *Error {
+	return &Error{Code: code, Msg: msg}
+}
+
+// CodeOf
+//
+//	@Description: 获取错误对应的错误码
+//	@param err
+//	@return Code nil 为成功，非 *Error 为 CodeInternal
+func CodeOf(err error) Code {
+	if err == nil {
+		return CodeSuccess
+	}
+	var rErr *Error
+	if errors.As(err, &rErr) {
+		return rErr.Code
+	}
+	return CodeInternal
+}
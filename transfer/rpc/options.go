@@ -0,0 +1,60 @@
+package rpc
+
+import (
+	"encoding/binary"
+	"github.com/meow-pad/chinchilla/transfer/codec"
+	"time"
+)
+
+const (
+	DefaultTimeout = 5 * time.Second
+)
+
+func newOptions(opts ...Option) *Options {
+	options := &Options{
+		ByteOrder:    codec.MessageCodecByteOrder,
+		PayloadCodec: JsonCodec{},
+		Timeout:      DefaultTimeout,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
+
+type Options struct {
+	// 请求负载头字节序
+	ByteOrder binary.ByteOrder
+	// 负载编解码器，调用双方需一致
+	PayloadCodec PayloadCodec
+	// 调用的 context 未设置截止时间时使用的超时时间，<=0 不限制
+	Timeout time.Duration
+	// 请求网关在路由失败时回复，调用可立即失败而无需等待超时（需网关支持）
+	RouteFeedback bool
+}
+
+type Option func(options *Options)
+
+func WithByteOrder(byteOrder binary.ByteOrder) Option {
+	return func(options *Options) {
+		options.ByteOrder = byteOrder
+	}
+}
+
+func WithPayloadCodec(payloadCodec PayloadCodec) Option {
+	return func(options *Options) {
+		options.PayloadCodec = payloadCodec
+	}
+}
+
+func WithTimeout(timeout time.Duration) Option {
+	return func(options *Options) {
+		options.Timeout = timeout
+	}
+}
+
+func WithRouteFeedback(value bool) Option {
+	return func(options *Options) {
+		options.RouteFeedback = value
+	}
+}
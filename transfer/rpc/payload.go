@@ -0,0 +1,85 @@
+package rpc
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/gogo/protobuf/proto"
+	"io"
+)
+
+const (
+	// 请求负载头 | methodId(2) |
+	methodHeadSize = 2
+)
+
+// PayloadCodec
+//
+//	@Description: rpc 请求及响应的负载编解码器
+type PayloadCodec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// JsonCodec
+//
+//	@Description: json 负载编解码
+type JsonCodec struct{}
+
+func (JsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// ProtoCodec
+//
+//	@Description: protobuf 负载编解码，消息需实现 proto.Message
+type ProtoCodec struct{}
+
+func (ProtoCodec) Marshal(v any) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("not a proto message:%T", v)
+	}
+	return proto.Marshal(msg)
+}
+
+func (ProtoCodec) Unmarshal(data []byte, v any) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("not a proto message:%T", v)
+	}
+	return proto.Unmarshal(data, msg)
+}
+
+// encodeRequest
+//
+//	@Description: | methodId(2) | body |
+//	@param byteOrder
+//	@param methodId
+//	@param body
+//	@return []byte
+func encodeRequest(byteOrder binary.ByteOrder, methodId uint16, body []byte) []byte {
+	payload := make([]byte, methodHeadSize+len(body))
+	byteOrder.PutUint16(payload, methodId)
+	copy(payload[methodHeadSize:], body)
+	return payload
+}
+
+// decodeRequest
+//
+//	@Description: 解析请求负载
+//	@param byteOrder
+//	@param payload
+//	@return methodId
+//	@return body
+//	@return err
+func decodeRequest(byteOrder binary.ByteOrder, payload []byte) (methodId uint16, body []byte, err error) {
+	if len(payload) < methodHeadSize {
+		return 0, nil, io.ErrShortBuffer
+	}
+	return byteOrder.Uint16(payload), payload[methodHeadSize:], nil
+}
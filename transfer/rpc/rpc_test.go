@@ -0,0 +1,270 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"github.com/meow-pad/chinchilla/proto/receiver/pb"
+	"github.com/meow-pad/chinchilla/transfer/codec"
+	"github.com/meow-pad/chinchilla/transfer/common"
+	"github.com/meow-pad/chinchilla/transfer/router"
+	"github.com/meow-pad/persian/frame/pnet/utils"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClientService = "client"
+	testClientId      = "client-1"
+	testServerService = "server"
+	testServerId      = "server-1"
+
+	testMethodEcho uint16 = 1
+)
+
+type testReq struct {
+	Name string
+}
+
+type testRes struct {
+	Greeting string
+	Source   Source
+}
+
+// testGateway
+//
+//	@Description: 模拟网关在客户端与服务端之间转发路由消息
+type testGateway struct {
+	t        *testing.T
+	msgCodec *codec.FrameCodec
+	client   *Client
+	server   *Server
+
+	lock sync.Mutex
+	hold bool             // 为true时暂存响应而不投递
+	held []*codec.RpcRRes // 暂存的响应
+	sent []*codec.MessageRouter
+}
+
+func newTestGateway(t *testing.T, clientOpts []Option, serverOpts []Option) *testGateway {
+	msgCodec, err := codec.NewCodec(codec.NewServerCodec(codec.MessageCodecByteOrder), 8*1024)
+	require.Nil(t, err)
+	gateway := &testGateway{t: t, msgCodec: msgCodec}
+	gateway.client = NewClient(testClientService, testClientId, msgCodec, gateway.send, clientOpts...)
+	gateway.server = NewServer(testServerId, msgCodec, serverOpts...)
+	return gateway
+}
+
+func (gateway *testGateway) decode(payload []byte) any {
+	msgs, _, err := gateway.msgCodec.Decode(utils.NewBytesReader(payload))
+	require.Nil(gateway.t, err)
+	require.Len(gateway.t, msgs, 1)
+	return msgs[0]
+}
+
+func (gateway *testGateway) send(msg *codec.MessageRouter) error {
+	gateway.lock.Lock()
+	gateway.sent = append(gateway.sent, msg)
+	gateway.lock.Unlock()
+	req, ok := gateway.decode(msg.Payload).(*codec.RpcRReq)
+	require.True(gateway.t, ok)
+	gateway.server.HandleRequest(gateway, req)
+	return nil
+}
+
+func (gateway *testGateway) SendMessage(message any) {
+	msg, ok := message.(*codec.MessageRouter)
+	require.True(gateway.t, ok)
+	require.Equal(gateway.t, testClientService, msg.RouterService)
+	require.Equal(gateway.t, int16(router.RouteTypeService), msg.RouterType)
+	require.Equal(gateway.t, testClientId, msg.RouterId)
+	res, ok := gateway.decode(msg.Payload).(*codec.RpcRRes)
+	require.True(gateway.t, ok)
+	gateway.lock.Lock()
+	if gateway.hold {
+		gateway.held = append(gateway.held, res)
+		gateway.lock.Unlock()
+		return
+	}
+	gateway.lock.Unlock()
+	gateway.client.HandleResponse(res)
+}
+
+// release
+//
+//	@Description: 投递暂存的响应
+//	@receiver gateway
+//	@return []bool 各响应是否有等待的调用
+func (gateway *testGateway) release() []bool {
+	gateway.lock.Lock()
+	held := gateway.held
+	gateway.held = nil
+	gateway.hold = false
+	gateway.lock.Unlock()
+	handled := make([]bool, 0, len(held))
+	for _, res := range held {
+		handled = append(handled, gateway.client.HandleResponse(res))
+	}
+	return handled
+}
+
+func pendingCount(client *Client) int {
+	count := 0
+	client.pending.Range(func(uint32, chan *codec.RpcRRes) bool {
+		count++
+		return true
+	})
+	return count
+}
+
+func TestRpc_JsonRoundTrip(t *testing.T) {
+	should := require.New(t)
+	gateway := newTestGateway(t, nil, nil)
+	should.Nil(Handle(gateway.server, testMethodEcho, func(ctx context.Context, req *testReq) (*testRes, error) {
+		source, _ := SourceFromContext(ctx)
+		return &testRes{Greeting: "hello " + req.Name, Source: source}, nil
+	}))
+	should.NotNil(Handle(gateway.server, testMethodEcho, func(context.Context, *testReq) (*testRes, error) {
+		return nil, nil
+	}))
+	res, instId, err := CallSelect[testRes](context.Background(), gateway.client, testServerService, "guild1",
+		testMethodEcho, &testReq{Name: "cat"})
+	should.Nil(err)
+	should.Equal(testServerId, instId)
+	should.Equal("hello cat", res.Greeting)
+	should.Equal(Source{Service: testClientService, ServiceId: testClientId}, res.Source)
+	should.Len(gateway.sent, 1)
+	should.Equal(int16(router.RouteTypeSelect), gateway.sent[0].RouterType)
+	should.Equal("guild1", gateway.sent[0].RouterId)
+	should.Zero(pendingCount(gateway.client))
+}
+
+func TestRpc_ProtoRoundTrip(t *testing.T) {
+	should := require.New(t)
+	gateway := newTestGateway(t, []Option{WithPayloadCodec(ProtoCodec{})}, []Option{WithPayloadCodec(ProtoCodec{})})
+	should.Nil(Handle(gateway.server, testMethodEcho, func(_ context.Context, req *pb.HandshakeReq) (*pb.HandshakeRes, error) {
+		return &pb.HandshakeRes{Code: uint32(len(req.Labels))}, nil
+	}))
+	res, err := Call[pb.HandshakeRes](context.Background(), gateway.client, testServerService, testServerId,
+		testMethodEcho, &pb.HandshakeReq{RouterId: "1", Labels: map[string]string{"zone": "a", "ver": "2"}})
+	should.Nil(err)
+	should.Equal(uint32(2), res.Code)
+	should.Equal(int16(router.RouteTypeService), gateway.sent[0].RouterType)
+	should.Equal(testServerId, gateway.sent[0].RouterId)
+	// 非 proto 消息
+	_, err = Call[testRes](context.Background(), gateway.client, testServerService, testServerId,
+		testMethodEcho, &testReq{})
+	should.Equal(CodeBadRequest, CodeOf(err))
+}
+
+func TestRpc_UnknownMethod(t *testing.T) {
+	should := require.New(t)
+	gateway := newTestGateway(t, nil, nil)
+	err := gateway.client.Invoke(context.Background(), testServerService, testServerId, 99, &testReq{}, nil)
+	should.Equal(CodeUnknownMethod, CodeOf(err))
+}
+
+func TestRpc_HandlerError(t *testing.T) {
+	should := require.New(t)
+	gateway := newTestGateway(t, nil, nil)
+	should.Nil(gateway.server.Register(1, func(context.Context, []byte) ([]byte, error) {
+		panic("boom")
+	}))
+	should.Nil(gateway.server.Register(2, func(context.Context, []byte) ([]byte, error) {
+		return nil, errors.New("plain error")
+	}))
+	should.Nil(gateway.server.Register(3, func(context.Context, []byte) ([]byte, error) {
+		return nil, NewError(CodeUser+1, "user error")
+	}))
+	err := gateway.client.Invoke(context.Background(), testServerService, testServerId, 1, &testReq{}, nil)
+	should.Equal(CodeInternal, CodeOf(err))
+	err = gateway.client.Invoke(context.Background(), testServerService, testServerId, 2, &testReq{}, nil)
+	should.Equal(CodeInternal, CodeOf(err))
+	should.Contains(err.Error(), "plain error")
+	err = gateway.client.Invoke(context.Background(), testServerService, testServerId, 3, &testReq{}, nil)
+	should.Equal(CodeUser+1, CodeOf(err))
+	should.Contains(err.Error(), "user error")
+}
+
+func TestRpc_Timeout(t *testing.T) {
+	should := require.New(t)
+	gateway := newTestGateway(t, []Option{WithTimeout(20 * time.Millisecond)}, nil)
+	should.Nil(gateway.server.Register(testMethodEcho, func(context.Context, []byte) ([]byte, error) {
+		return nil, nil
+	}))
+	gateway.hold = true
+	// 未设置截止时间时使用 Options.Timeout
+	begin := time.Now()
+	err := gateway.client.Invoke(context.Background(), testServerService, testServerId, testMethodEcho, &testReq{}, nil)
+	should.ErrorIs(err, context.DeadlineExceeded)
+	should.Less(time.Since(begin), time.Second)
+	// 超时后到达的响应被丢弃
+	should.Equal([]bool{false}, gateway.release())
+	should.Zero(pendingCount(gateway.client))
+
+	// ctx 的截止时间优先于 Options.Timeout
+	gateway.hold = true
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		time.Sleep(100 * time.Millisecond)
+		gateway.release()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = gateway.client.Invoke(ctx, testServerService, testServerId, testMethodEcho, &testReq{}, nil)
+	should.Nil(err)
+	<-done
+
+	// ctx 先到期
+	gateway = newTestGateway(t, []Option{WithTimeout(time.Hour)}, nil)
+	gateway.hold = true
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	begin = time.Now()
+	err = gateway.client.Invoke(ctx, testServerService, testServerId, testMethodEcho, &testReq{}, nil)
+	should.ErrorIs(err, context.DeadlineExceeded)
+	should.Less(time.Since(begin), time.Second)
+	should.Zero(pendingCount(gateway.client))
+}
+
+func TestRpc_RouteFailure(t *testing.T) {
+	should := require.New(t)
+	var client *Client
+	var sent *codec.MessageRouter
+	send := func(msg *codec.MessageRouter) error {
+		sent = msg
+		// 网关路由失败直接回复
+		should.True(client.HandleRouteFailure(&codec.MessageRouterRes{
+			Code:          common.ErrCodeNoInstance,
+			CorrelationId: msg.CorrelationId,
+			RouterService: msg.RouterService,
+			RouterType:    msg.RouterType,
+			RouterId:      msg.RouterId,
+		}))
+		return nil
+	}
+	msgCodec, err := codec.NewCodec(codec.NewServerCodec(codec.MessageCodecByteOrder), 8*1024)
+	should.Nil(err)
+	client = NewClient(testClientService, testClientId, msgCodec, send,
+		WithTimeout(time.Hour), WithRouteFeedback(true))
+	begin := time.Now()
+	_, err = client.InvokeSelect(context.Background(), testServerService, "guild1", testMethodEcho, &testReq{}, nil)
+	should.Equal(CodeNoInstance, CodeOf(err))
+	should.Less(time.Since(begin), time.Second)
+	should.NotZero(sent.CorrelationId)
+	should.Zero(pendingCount(client))
+	// 无等待的调用
+	should.False(client.HandleRouteFailure(&codec.MessageRouterRes{CorrelationId: sent.CorrelationId}))
+
+	// 未开启时不携带关联id
+	client = NewClient(testClientService, testClientId, msgCodec, func(msg *codec.MessageRouter) error {
+		sent = msg
+		return errors.New("no gateway")
+	})
+	err = client.Invoke(context.Background(), testServerService, testServerId, testMethodEcho, &testReq{}, nil)
+	should.Equal(CodeUnavailable, CodeOf(err))
+	should.Zero(sent.CorrelationId)
+	should.Zero(pendingCount(client))
+}
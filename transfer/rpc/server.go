@@ -0,0 +1,171 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/meow-pad/chinchilla/transfer/codec"
+	"github.com/meow-pad/chinchilla/transfer/router"
+	"github.com/meow-pad/persian/frame/plog"
+	"github.com/meow-pad/persian/frame/plog/pfield"
+	netcodec "github.com/meow-pad/persian/frame/pnet/tcp/codec"
+	"github.com/meow-pad/persian/utils/coding"
+	"github.com/meow-pad/persian/utils/collections"
+)
+
+// Handler
+//
+//	@Description: 方法处理器，返回 *Error 时调用方收到对应错误码，其他错误为 CodeInternal
+type Handler func(ctx context.Context, body []byte) ([]byte, error)
+
+// Replier
+//
+//	@Description: 响应发送者，一般为收到请求的网关会话
+type Replier interface {
+	SendMessage(message any)
+}
+
+type sourceKey struct{}
+
+// Source
+//
+//	@Description: 请求来源
+type Source struct {
+	Service   string
+	ServiceId string
+}
+
+// SourceFromContext
+//
+//	@Description: 获取处理器 context 中的请求来源
+//	@param ctx
+//	@return Source
+//	@return bool
+func SourceFromContext(ctx context.Context) (Source, bool) {
+	source, ok := ctx.Value(sourceKey{}).(Source)
+	return source, ok
+}
+
+// NewServer
+//
+//	@Description: 构建 rpc 服务端
+//	@param serviceId 本服务实例id，请求要求时随响应返回
+//	@param msgCodec 路由消息负载编码器，不应开启帧压缩
+//	@param opts
+//	@return *Server
+func NewServer(serviceId string, msgCodec netcodec.Codec, opts ...Option) *Server {
+	return &Server{
+		serviceId: serviceId,
+		msgCodec:  msgCodec,
+		options:   newOptions(opts...),
+	}
+}
+
+// Server
+//
+//	@Description: rpc 服务端，按方法id分发请求
+type Server struct {
+	serviceId string
+	msgCodec  netcodec.Codec
+	options   *Options
+
+	handlers collections.SyncMap[uint16, Handler]
+}
+
+// Register
+//
+//	@Description: 注册方法处理器
+//	@receiver server
+//	@param methodId
+//	@param handler
+//	@return error 重复注册
+func (server *Server) Register(methodId uint16, handler Handler) error {
+	if _, loaded := server.handlers.LoadOrStore(methodId, handler); loaded {
+		return fmt.Errorf("rpc method %d already registered", methodId)
+	}
+	return nil
+}
+
+// HandleRequest
+//
+//	@Description: 处理请求并将响应路由回来源实例，处理器在当前协程中执行
+//	@receiver server
+//	@param replier
+//	@param req
+func (server *Server) HandleRequest(replier Replier, req *codec.RpcRReq) {
+	body, err := server.handleRequest(req)
+	res := codec.NewRpcRRes(req.RPCId, body)
+	if req.WantServiceId {
+		res.ServiceId = server.serviceId
+	}
+	if code := CodeOf(err); code != CodeSuccess {
+		res.Code = uint16(code)
+		res.Payload = []byte(errorMsg(err))
+	}
+	msgRouter, err := codec.NewMessageRouter(codec.MessageCodecByteOrder, req.SourceSrv,
+		router.RouteTypeService, req.SourceId, server.msgCodec, res)
+	if err != nil {
+		plog.Error("(rpc) construct response router msg error:", pfield.Error(err))
+		return
+	}
+	replier.SendMessage(msgRouter)
+}
+
+func (server *Server) handleRequest(req *codec.RpcRReq) (resBody []byte, err error) {
+	defer coding.HandlePanicError("(rpc) handle request panic", func(aErr any) {
+		if aErr != nil {
+			err = NewError(CodeInternal, fmt.Sprintf("%v", aErr))
+		}
+	})
+	methodId, body, err := decodeRequest(server.options.ByteOrder, req.Payload)
+	if err != nil {
+		return nil, NewError(CodeBadRequest, err.Error())
+	}
+	handler, _ := server.handlers.Load(methodId)
+	if handler == nil {
+		return nil, NewError(CodeUnknownMethod, fmt.Sprintf("unknown rpc method:%d", methodId))
+	}
+	ctx := context.WithValue(context.Background(), sourceKey{}, Source{
+		Service:   req.SourceSrv,
+		ServiceId: req.SourceId,
+	})
+	return handler(ctx, body)
+}
+
+func errorMsg(err error) string {
+	var rErr *Error
+	if errors.As(err, &rErr) {
+		return rErr.Msg
+	}
+	return err.Error()
+}
+
+// Handle
+//
+//	@Description: 以泛型方式注册方法处理器，请求与响应使用服务端负载编解码器
+//	@param server
+//	@param methodId
+//	@param handler 响应为nil时负载为空
+//	@return error
+func Handle[Req, Res any](server *Server, methodId uint16,
+	handler func(ctx context.Context, req *Req) (*Res, error)) error {
+	payloadCodec := server.options.PayloadCodec
+	return server.Register(methodId, func(ctx context.Context, body []byte) ([]byte, error) {
+		req := new(Req)
+		if err := payloadCodec.Unmarshal(body, req); err != nil {
+			return nil, NewError(CodeBadRequest, err.Error())
+		}
+		res, err := handler(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		if res == nil {
+			return nil, nil
+		}
+		resBody, err := payloadCodec.Marshal(res)
+		if err != nil {
+			return nil, NewError(CodeInternal, err.Error())
+		}
+		return resBody, nil
+	})
+}
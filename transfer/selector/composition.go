@@ -19,11 +19,15 @@ type CompositeSelector struct {
 }
 
 func (selector *CompositeSelector) Select(routerId string) (string, error) {
+	return selector.SelectWithHints(routerId, nil)
+}
+
+func (selector *CompositeSelector) SelectWithHints(routerId string, hints map[string]string) (string, error) {
 	if len(selector.infoArr) <= 0 {
 		return "", common.ErrEmptyInstances
 	}
 	for _, _selector := range selector.selectors {
-		id, err := _selector.Select(routerId)
+		id, err := SelectWithHints(_selector, routerId, hints)
 		if err != nil {
 			plog.Error("select service error:", pfield.Error(err))
 			// 这里的处理是先忽略当前问题，继续往下查找（按需求再修改）
@@ -36,6 +40,22 @@ func (selector *CompositeSelector) Select(routerId string) (string, error) {
 	return "", nil
 }
 
+func (selector *CompositeSelector) OnBind(instId string) {
+	for _, _selector := range selector.selectors {
+		if observer, ok := _selector.(BindObserver); ok {
+			observer.OnBind(instId)
+		}
+	}
+}
+
+func (selector *CompositeSelector) OnUnbind(instId string) {
+	for _, _selector := range selector.selectors {
+		if observer, ok := _selector.(BindObserver); ok {
+			observer.OnUnbind(instId)
+		}
+	}
+}
+
 func (selector *CompositeSelector) Update(infoArr []common.Info) {
 	selector.infoArr = make([]common.Info, len(infoArr))
 	copy(selector.infoArr, infoArr)
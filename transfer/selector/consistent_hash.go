@@ -0,0 +1,44 @@
+package selector
+
+import (
+	"github.com/meow-pad/chinchilla/transfer/common"
+	"github.com/meow-pad/chinchilla/utils/hashring"
+	"sync/atomic"
+)
+
+// NewConsistentHashSelector
+//
+//	@Description: 构建一致性哈希选择器
+//	@param replicas 权重为1的实例的虚拟节点数，<=0 时使用 hashring.DefaultReplicas
+//	@return Selector
+func NewConsistentHashSelector(replicas int) Selector {
+	return &ConsistentHashSelector{replicas: replicas}
+}
+
+// ConsistentHashSelector
+//
+//	@Description: 按 routerId 一致性哈希选择实例，实例不变时相同 routerId 总是选中同一实例，
+//	实例增减时只有相关区间的 routerId 会迁移；routerId 为空时选不出实例，可与 WeightSelector 组合使用
+type ConsistentHashSelector struct {
+	replicas int
+	ring     atomic.Pointer[hashring.Ring]
+}
+
+func (selector *ConsistentHashSelector) Select(routerId string) (string, error) {
+	ring := selector.ring.Load()
+	if ring.Empty() {
+		return "", common.ErrEmptyInstances
+	}
+	if len(routerId) <= 0 {
+		return "", nil
+	}
+	return ring.Get(routerId), nil
+}
+
+func (selector *ConsistentHashSelector) Update(infoArr []common.Info) {
+	nodes := make([]hashring.Node, 0, len(infoArr))
+	for _, info := range infoArr {
+		nodes = append(nodes, hashring.Node{Id: info.ServiceId(), Weight: info.Weight})
+	}
+	selector.ring.Store(hashring.New(selector.replicas, nodes))
+}
@@ -0,0 +1,108 @@
+package selector
+
+import (
+	"github.com/meow-pad/chinchilla/transfer/common"
+	"github.com/nacos-group/nacos-sdk-go/v2/model"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"testing"
+)
+
+func testInfo(instId string, weight float64, labels map[string]string) common.Info {
+	metadata := map[string]string{common.MetadataKeyId: instId}
+	for key, value := range labels {
+		metadata[key] = value
+	}
+	return common.Info(model.Instance{
+		Weight:   weight,
+		Enable:   true,
+		Healthy:  true,
+		Metadata: metadata,
+	})
+}
+
+func testInfos(num int) []common.Info {
+	infoArr := make([]common.Info, 0, num)
+	for i := 0; i < num; i++ {
+		infoArr = append(infoArr, testInfo("inst"+strconv.Itoa(i), 1, nil))
+	}
+	return infoArr
+}
+
+func testSelectAll(t *testing.T, selector Selector, keyNum int) map[string]string {
+	selected := make(map[string]string, keyNum)
+	for i := 0; i < keyNum; i++ {
+		routerId := "router" + strconv.Itoa(i)
+		instId, err := selector.Select(routerId)
+		require.Nil(t, err)
+		require.NotEmpty(t, instId)
+		selected[routerId] = instId
+	}
+	return selected
+}
+
+func TestConsistentHashSelector_Stable(t *testing.T) {
+	should := require.New(t)
+	selector := NewConsistentHashSelector(0)
+	infoArr := testInfos(5)
+	selector.Update(infoArr)
+	selected := testSelectAll(t, selector, 1000)
+	// 多次选择结果一致
+	should.Equal(selected, testSelectAll(t, selector, 1000))
+	// 实例不变时更新（包括顺序变化）结果一致
+	reversed := make([]common.Info, 0, len(infoArr))
+	for i := len(infoArr) - 1; i >= 0; i-- {
+		reversed = append(reversed, infoArr[i])
+	}
+	selector.Update(reversed)
+	should.Equal(selected, testSelectAll(t, selector, 1000))
+}
+
+func TestConsistentHashSelector_Movement(t *testing.T) {
+	should := require.New(t)
+	const (
+		instNum = 8
+		keyNum  = 10000
+	)
+	selector := NewConsistentHashSelector(0)
+	infoArr := testInfos(instNum + 1)
+	selector.Update(infoArr[:instNum])
+	before := testSelectAll(t, selector, keyNum)
+	// 新增实例时只迁移约 1/(N+1) 的 routerId，且都迁移到新实例
+	selector.Update(infoArr)
+	moved := 0
+	for routerId, instId := range testSelectAll(t, selector, keyNum) {
+		if before[routerId] != instId {
+			moved++
+			should.Equal(infoArr[instNum].ServiceId(), instId)
+		}
+	}
+	should.Greater(moved, 0)
+	should.Less(float64(moved), 1.5*keyNum/(instNum+1))
+	// 移除实例时只迁移该实例上的 routerId
+	selector.Update(infoArr[1:instNum])
+	moved = 0
+	for routerId, instId := range testSelectAll(t, selector, keyNum) {
+		if before[routerId] != instId {
+			moved++
+			should.Equal(infoArr[0].ServiceId(), before[routerId])
+		}
+	}
+	should.Less(float64(moved), 1.5*keyNum/instNum)
+}
+
+func TestConsistentHashSelector_Empty(t *testing.T) {
+	should := require.New(t)
+	selector := NewConsistentHashSelector(0)
+	// 未更新及实例为空
+	_, err := selector.Select("router1")
+	should.ErrorIs(err, common.ErrEmptyInstances)
+	selector.Update(nil)
+	_, err = selector.Select("router1")
+	should.ErrorIs(err, common.ErrEmptyInstances)
+	// routerId 为空时选不出实例
+	selector.Update(testInfos(3))
+	instId, err := selector.Select("")
+	should.Nil(err)
+	should.Equal("", instId)
+}
@@ -0,0 +1,213 @@
+package selector
+
+import (
+	"github.com/meow-pad/chinchilla/transfer/common"
+	"hash/fnv"
+	"math/rand"
+	"sync/atomic"
+)
+
+// LabelRules
+//
+//	@Description: 按实例元数据标签（如 version=2.3、zone=a、canary=true）选择的规则
+type LabelRules struct {
+	// 必须匹配的标签，不匹配的实例不会被选中
+	Required map[string]string
+	// 优先匹配的标签，没有匹配的实例时退回所有实例
+	Preferred map[string]string
+	// 允许客户端提示的标签键（如 version、zone），提示的标签按优先匹配处理，其它键忽略
+	HintKeys []string
+	// 灰度实例的标签键值，键为空时不分流
+	CanaryKey   string
+	CanaryValue string
+	// 分流至灰度实例的新会话比例，取值 0-100
+	CanaryPercent int
+}
+
+// NewLabelSelector
+//
+//	@Description: 构建标签选择器
+//	@param rules
+//	@return Selector
+func NewLabelSelector(rules LabelRules) Selector {
+	selector := &LabelSelector{
+		rules:    rules,
+		hintKeys: make(map[string]struct{}, len(rules.HintKeys)),
+	}
+	for _, key := range rules.HintKeys {
+		selector.hintKeys[key] = struct{}{}
+	}
+	return selector
+}
+
+// LabelSelector
+//
+//	@Description: 按标签过滤及优先选择实例，依次为 必须标签、客户端提示、优先标签、灰度分流，最后按权重随机选择；
+//	灰度分流在 routerId 不为空时按其哈希值决定，相同 routerId 总是落在同一侧
+type LabelSelector struct {
+	rules    LabelRules
+	hintKeys map[string]struct{}
+	// 更新时的实例总数，用于区分无实例与无匹配实例
+	total   atomic.Int32
+	infoArr atomic.Pointer[[]common.Info]
+}
+
+func (selector *LabelSelector) Select(routerId string) (string, error) {
+	return selector.SelectWithHints(routerId, nil)
+}
+
+func (selector *LabelSelector) SelectWithHints(routerId string, hints map[string]string) (string, error) {
+	if selector.total.Load() <= 0 {
+		return "", common.ErrEmptyInstances
+	}
+	infoArr := selector.infoArr.Load()
+	if infoArr == nil || len(*infoArr) <= 0 {
+		return "", nil
+	}
+	candidates := *infoArr
+	if len(hints) > 0 && len(selector.hintKeys) > 0 {
+		hintLabels := make(map[string]string, len(hints))
+		for key, value := range hints {
+			if _, ok := selector.hintKeys[key]; ok {
+				hintLabels[key] = value
+			}
+		}
+		candidates = preferLabels(candidates, hintLabels)
+	}
+	candidates = preferLabels(candidates, selector.rules.Preferred)
+	candidates = selector.splitCanary(routerId, candidates)
+	return selectByWeight(candidates), nil
+}
+
+func (selector *LabelSelector) Update(infoArr []common.Info) {
+	sInfoArr := filterLabels(infoArr, selector.rules.Required)
+	if len(sInfoArr) == len(infoArr) {
+		// 未过滤时也需拷贝，避免外部修改
+		sInfoArr = make([]common.Info, len(infoArr))
+		copy(sInfoArr, infoArr)
+	}
+	selector.infoArr.Store(&sInfoArr)
+	selector.total.Store(int32(len(infoArr)))
+}
+
+// splitCanary
+//
+//	@Description: 按灰度比例选择灰度或非灰度实例，一侧没有实例时使用另一侧
+//	@receiver selector
+//	@param routerId
+//	@param candidates
+//	@return []common.Info
+func (selector *LabelSelector) splitCanary(routerId string, candidates []common.Info) []common.Info {
+	rules := selector.rules
+	if len(rules.CanaryKey) <= 0 {
+		return candidates
+	}
+	var canary, stable []common.Info
+	for _, info := range candidates {
+		if info.Metadata != nil && info.Metadata[rules.CanaryKey] == rules.CanaryValue {
+			canary = append(canary, info)
+		} else {
+			stable = append(stable, info)
+		}
+	}
+	if len(canary) <= 0 {
+		return stable
+	}
+	if len(stable) <= 0 {
+		return canary
+	}
+	if selector.inCanary(routerId) {
+		return canary
+	}
+	return stable
+}
+
+func (selector *LabelSelector) inCanary(routerId string) bool {
+	percent := selector.rules.CanaryPercent
+	if percent <= 0 {
+		return false
+	}
+	if percent >= 100 {
+		return true
+	}
+	if len(routerId) <= 0 {
+		return rand.Intn(100) < percent
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(routerId))
+	return int(h.Sum32()%100) < percent
+}
+
+// matchLabels
+//
+//	@Description: 实例元数据是否包含所有标签
+//	@param info
+//	@param labels
+//	@return bool
+func matchLabels(info common.Info, labels map[string]string) bool {
+	for key, value := range labels {
+		if info.Metadata == nil {
+			return false
+		}
+		if mValue, ok := info.Metadata[key]; !ok || mValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+func filterLabels(infoArr []common.Info, labels map[string]string) []common.Info {
+	if len(labels) <= 0 {
+		return infoArr
+	}
+	matched := make([]common.Info, 0, len(infoArr))
+	for _, info := range infoArr {
+		if matchLabels(info, labels) {
+			matched = append(matched, info)
+		}
+	}
+	return matched
+}
+
+// preferLabels
+//
+//	@Description: 优先选择匹配标签的实例，没有匹配的实例时返回原实例
+//	@param infoArr
+//	@param labels
+//	@return []common.Info
+func preferLabels(infoArr []common.Info, labels map[string]string) []common.Info {
+	matched := filterLabels(infoArr, labels)
+	if len(matched) <= 0 {
+		return infoArr
+	}
+	return matched
+}
+
+func selectByWeight(infoArr []common.Info) string {
+	sum := 0.0
+	for _, info := range infoArr {
+		if info.Weight > 0 {
+			sum += info.Weight
+		}
+	}
+	if sum <= 0 {
+		return ""
+	}
+	r := rand.Float64() * sum
+	for _, info := range infoArr {
+		if info.Weight <= 0 {
+			continue
+		}
+		if r < info.Weight {
+			return info.ServiceId()
+		}
+		r -= info.Weight
+	}
+	// 浮点误差时落到最后一个有权重的实例
+	for i := len(infoArr) - 1; i >= 0; i-- {
+		if infoArr[i].Weight > 0 {
+			return infoArr[i].ServiceId()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,127 @@
+package selector
+
+import (
+	"github.com/meow-pad/chinchilla/transfer/common"
+	"github.com/stretchr/testify/require"
+	"math"
+	"strconv"
+	"testing"
+)
+
+func testLabelSelectAll(t *testing.T, selector *LabelSelector, num int, hints map[string]string) map[string]int {
+	selected := make(map[string]int)
+	for i := 0; i < num; i++ {
+		instId, err := selector.SelectWithHints("", hints)
+		require.Nil(t, err)
+		selected[instId]++
+	}
+	return selected
+}
+
+func TestLabelSelector_Required(t *testing.T) {
+	should := require.New(t)
+	selector := NewLabelSelector(LabelRules{Required: map[string]string{"zone": "a"}}).(*LabelSelector)
+	_, err := selector.Select("router1")
+	should.ErrorIs(err, common.ErrEmptyInstances)
+	selector.Update([]common.Info{
+		testInfo("inst0", 1, map[string]string{"zone": "a"}),
+		testInfo("inst1", 1, map[string]string{"zone": "b"}),
+		testInfo("inst2", 1, nil),
+	})
+	for instId := range testLabelSelectAll(t, selector, 100, nil) {
+		should.Equal("inst0", instId)
+	}
+	// 有实例但都不满足必须标签时返回空，而不是无实例
+	selector.Update([]common.Info{
+		testInfo("inst1", 1, map[string]string{"zone": "b"}),
+		testInfo("inst2", 1, nil),
+	})
+	instId, err := selector.Select("router1")
+	should.Nil(err)
+	should.Equal("", instId)
+	selector.Update(nil)
+	_, err = selector.Select("router1")
+	should.ErrorIs(err, common.ErrEmptyInstances)
+}
+
+func TestLabelSelector_Hints(t *testing.T) {
+	should := require.New(t)
+	selector := NewLabelSelector(LabelRules{
+		Preferred: map[string]string{"zone": "a"},
+		HintKeys:  []string{"version"},
+	}).(*LabelSelector)
+	selector.Update([]common.Info{
+		testInfo("inst0", 1, map[string]string{"zone": "a", "version": "1"}),
+		testInfo("inst1", 1, map[string]string{"zone": "b", "version": "2"}),
+		testInfo("inst2", 1, map[string]string{"zone": "b", "version": "1"}),
+	})
+	// 优先标签
+	should.Equal(map[string]int{"inst0": 100}, testLabelSelectAll(t, selector, 100, nil))
+	// 提示优先于优先标签
+	should.Equal(map[string]int{"inst1": 100},
+		testLabelSelectAll(t, selector, 100, map[string]string{"version": "2"}))
+	should.Equal(map[string]int{"inst0": 100},
+		testLabelSelectAll(t, selector, 100, map[string]string{"version": "1"}))
+	// 未匹配的提示退回所有实例
+	should.Equal(map[string]int{"inst0": 100},
+		testLabelSelectAll(t, selector, 100, map[string]string{"version": "3"}))
+	// 不在 HintKeys 中的键忽略
+	should.Equal(map[string]int{"inst0": 100},
+		testLabelSelectAll(t, selector, 100, map[string]string{"zone": "b"}))
+}
+
+func TestLabelSelector_Canary(t *testing.T) {
+	should := require.New(t)
+	const (
+		percent = 20
+		keyNum  = 10000
+	)
+	selector := NewLabelSelector(LabelRules{
+		CanaryKey:     "canary",
+		CanaryValue:   "true",
+		CanaryPercent: percent,
+	}).(*LabelSelector)
+	selector.Update([]common.Info{
+		testInfo("inst0", 1, map[string]string{"canary": "true"}),
+		testInfo("inst1", 1, nil),
+		testInfo("inst2", 1, map[string]string{"canary": "false"}),
+	})
+	canary := 0
+	for i := 0; i < keyNum; i++ {
+		routerId := "router" + strconv.Itoa(i)
+		first, err := selector.Select(routerId)
+		should.Nil(err)
+		// 相同 routerId 总是落在同一侧
+		for j := 0; j < 3; j++ {
+			instId, err := selector.Select(routerId)
+			should.Nil(err)
+			should.Equal(first == "inst0", instId == "inst0")
+		}
+		if first == "inst0" {
+			canary++
+		}
+	}
+	expected := float64(keyNum) * percent / 100
+	should.Less(math.Abs(float64(canary)-expected)/expected, 0.1, "canary:%d", canary)
+}
+
+func TestLabelSelector_CanaryOneSide(t *testing.T) {
+	should := require.New(t)
+	for _, percent := range []int{0, 50, 100} {
+		selector := NewLabelSelector(LabelRules{
+			CanaryKey:     "canary",
+			CanaryValue:   "true",
+			CanaryPercent: percent,
+		}).(*LabelSelector)
+		// 没有灰度实例时使用非灰度实例
+		selector.Update([]common.Info{testInfo("inst0", 1, nil), testInfo("inst1", 1, nil)})
+		for _, instId := range testSelectAll(t, selector, 100) {
+			should.Contains([]string{"inst0", "inst1"}, instId)
+		}
+		// 只有灰度实例时使用灰度实例
+		selector.Update([]common.Info{testInfo("inst2", 1, map[string]string{"canary": "true"})})
+		for _, instId := range testSelectAll(t, selector, 100) {
+			should.Equal("inst2", instId)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package selector
+
+import (
+	"github.com/meow-pad/chinchilla/transfer/common"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+// BindObserver
+//
+//	@Description: 关注会话与服务实例绑定变化的选择器
+type BindObserver interface {
+	// OnBind
+	//  @Description: 会话绑定到实例
+	//  @param instId
+	//
+	OnBind(instId string)
+
+	// OnUnbind
+	//  @Description: 会话解除与实例的绑定（会话关闭或换绑）
+	//  @param instId
+	//
+	OnUnbind(instId string)
+}
+
+func NewLeastConnSelector() Selector {
+	return &LeastConnSelector{}
+}
+
+// LeastConnSelector
+//
+//	@Description: 按当前网关绑定到各实例的会话数选择，选择 会话数/权重 最小的实例，相同时随机选择
+type LeastConnSelector struct {
+	infoArr atomic.Pointer[[]common.Info]
+	// 会话数归零的实例不保留计数，避免实例不断变化时计数无限增长
+	countLock sync.RWMutex
+	counts    map[string]int64
+}
+
+func (selector *LeastConnSelector) Select(routerId string) (string, error) {
+	infoArr := selector.infoArr.Load()
+	if infoArr == nil || len(*infoArr) <= 0 {
+		return "", common.ErrEmptyInstances
+	}
+	selector.countLock.RLock()
+	defer selector.countLock.RUnlock()
+	selected := ""
+	minLoad := 0.0
+	ties := 0
+	for _, info := range *infoArr {
+		if info.Weight <= 0 {
+			continue
+		}
+		instId := info.ServiceId()
+		load := float64(selector.counts[instId]) / info.Weight
+		switch {
+		case ties == 0 || load < minLoad:
+			selected, minLoad, ties = instId, load, 1
+		case load == minLoad:
+			// 蓄水池抽样，相同负载的实例等概率选中
+			ties++
+			if rand.Intn(ties) == 0 {
+				selected = instId
+			}
+		}
+	}
+	return selected, nil
+}
+
+func (selector *LeastConnSelector) Update(infoArr []common.Info) {
+	sInfoArr := make([]common.Info, len(infoArr))
+	copy(sInfoArr, infoArr)
+	selector.infoArr.Store(&sInfoArr)
+}
+
+func (selector *LeastConnSelector) OnBind(instId string) {
+	selector.countLock.Lock()
+	defer selector.countLock.Unlock()
+	if selector.counts == nil {
+		selector.counts = make(map[string]int64)
+	}
+	selector.counts[instId]++
+}
+
+func (selector *LeastConnSelector) OnUnbind(instId string) {
+	selector.countLock.Lock()
+	defer selector.countLock.Unlock()
+	count, ok := selector.counts[instId]
+	if !ok {
+		return
+	}
+	if count <= 1 {
+		delete(selector.counts, instId)
+	} else {
+		selector.counts[instId] = count - 1
+	}
+}
+
+// Count
+//
+//	@Description: 绑定到实例的会话数
+//	@receiver selector
+//	@param instId
+//	@return int64
+func (selector *LeastConnSelector) Count(instId string) int64 {
+	selector.countLock.RLock()
+	defer selector.countLock.RUnlock()
+	return selector.counts[instId]
+}
@@ -0,0 +1,83 @@
+package selector
+
+import (
+	"github.com/meow-pad/chinchilla/transfer/common"
+	"github.com/stretchr/testify/require"
+	"math"
+	"testing"
+)
+
+func TestLeastConnSelector_LeastLoaded(t *testing.T) {
+	should := require.New(t)
+	selector := NewLeastConnSelector().(*LeastConnSelector)
+	_, err := selector.Select("router1")
+	should.ErrorIs(err, common.ErrEmptyInstances)
+	selector.Update([]common.Info{
+		testInfo("inst0", 1, nil),
+		testInfo("inst1", 2, nil),
+		testInfo("inst2", 1, nil),
+		testInfo("inst3", 0, nil),
+	})
+	for _, instId := range []string{"inst0", "inst1", "inst1", "inst2"} {
+		selector.OnBind(instId)
+	}
+	// 会话数按权重折算：1/1、2/2、1/1，权重为 0 的实例不参与
+	selected := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		instId, err := selector.Select("")
+		should.Nil(err)
+		selected[instId]++
+	}
+	should.NotContains(selected, "inst3")
+	should.Len(selected, 3)
+	// 权重高的实例可承载更多会话
+	selector.OnBind("inst0")
+	selector.OnBind("inst2")
+	for i := 0; i < 10; i++ {
+		instId, err := selector.Select("")
+		should.Nil(err)
+		should.Equal("inst1", instId)
+	}
+}
+
+func TestLeastConnSelector_TieSpread(t *testing.T) {
+	should := require.New(t)
+	const (
+		instNum   = 4
+		selectNum = 8000
+	)
+	selector := NewLeastConnSelector()
+	selector.Update(testInfos(instNum))
+	// 负载相同时随机选择，各实例被选中的次数接近
+	selected := make(map[string]int)
+	for i := 0; i < selectNum; i++ {
+		instId, err := selector.Select("")
+		should.Nil(err)
+		selected[instId]++
+	}
+	should.Len(selected, instNum)
+	expected := float64(selectNum) / instNum
+	for instId, count := range selected {
+		should.Less(math.Abs(float64(count)-expected)/expected, 0.15, "%s:%d", instId, count)
+	}
+}
+
+func TestLeastConnSelector_Prune(t *testing.T) {
+	should := require.New(t)
+	selector := NewLeastConnSelector().(*LeastConnSelector)
+	selector.OnBind("inst0")
+	selector.OnBind("inst0")
+	selector.OnBind("inst1")
+	should.Equal(int64(2), selector.Count("inst0"))
+	selector.OnUnbind("inst0")
+	selector.OnUnbind("inst1")
+	should.Equal(int64(1), selector.Count("inst0"))
+	// 会话数归零后移除计数
+	should.NotContains(selector.counts, "inst1")
+	selector.OnUnbind("inst0")
+	should.Empty(selector.counts)
+	// 多余的解绑不会产生负数
+	selector.OnUnbind("inst0")
+	should.Zero(selector.Count("inst0"))
+	should.Empty(selector.counts)
+}
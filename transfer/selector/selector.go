@@ -4,6 +4,13 @@ import (
 	"github.com/meow-pad/chinchilla/transfer/common"
 )
 
+// Factory
+//
+//	@Description: 选择器构建，每个服务构建独立的选择器
+//	@param service 服务名
+//	@return Selector
+type Factory func(service string) Selector
+
 type Selector interface {
 	// Select
 	//  @Description: 选择一个可用服务
@@ -20,3 +27,32 @@ type Selector interface {
 	//
 	Update(instances []common.Info)
 }
+
+// HintSelector
+//
+//	@Description: 可按客户端提示（如握手时携带的版本、区域标签）选择实例的选择器
+type HintSelector interface {
+	// SelectWithHints
+	//  @Description: 按提示选择一个可用服务
+	//  @param routerId
+	//  @param hints 客户端提示的标签
+	//  @return string 有数据但选不出来时，该值为空字符串
+	//  @return error 如果实例数组本就为空，则返回 common.ErrEmptyInstances
+	//
+	SelectWithHints(routerId string, hints map[string]string) (string, error)
+}
+
+// SelectWithHints
+//
+//	@Description: 选择器支持提示时按提示选择，否则按 Selector.Select 选择
+//	@param selector
+//	@param routerId
+//	@param hints
+//	@return string
+//	@return error
+func SelectWithHints(selector Selector, routerId string, hints map[string]string) (string, error) {
+	if hintSelector, ok := selector.(HintSelector); ok && len(hints) > 0 {
+		return hintSelector.SelectWithHints(routerId, hints)
+	}
+	return selector.Select(routerId)
+}
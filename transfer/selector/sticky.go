@@ -0,0 +1,265 @@
+package selector
+
+import (
+	"github.com/meow-pad/chinchilla/transfer/common"
+	"github.com/meow-pad/persian/frame/plog"
+	"github.com/meow-pad/persian/frame/plog/pfield"
+	"github.com/meow-pad/persian/frame/pservice/cache"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultStickyTTL 粘性记录默认过期时间
+	DefaultStickyTTL = 30 * time.Minute
+)
+
+// StickyStore
+//
+//	@Description: routerId 到实例id的粘性记录存储
+type StickyStore interface {
+	// Get
+	//  @Description: 获取记录
+	//  @param routerId
+	//  @return string 实例id
+	//  @return bool 记录是否存在（未过期）
+	//  @return error
+	//
+	Get(routerId string) (string, bool, error)
+
+	// Set
+	//  @Description: 设置记录
+	//  @param routerId
+	//  @param instId
+	//  @param ttl 过期时间
+	//  @return error
+	//
+	Set(routerId string, instId string, ttl time.Duration) error
+
+	// Delete
+	//  @Description: 删除记录
+	//  @param routerId
+	//  @return error
+	//
+	Delete(routerId string) error
+}
+
+// NewStickySelector
+//
+//	@Description: 构建粘性选择器
+//	@param inner 无记录或记录的实例不可用时使用的选择器
+//	@param store 记录存储，为nil时使用 MemoryStickyStore
+//	@param ttl 记录过期时间，每次选中时刷新，<=0 时使用 DefaultStickyTTL
+//	@return Selector
+func NewStickySelector(inner Selector, store StickyStore, ttl time.Duration) Selector {
+	if store == nil {
+		store = NewMemoryStickyStore()
+	}
+	if ttl <= 0 {
+		// 否则记录立即过期，且内存存储每次写入都会全量清理
+		ttl = DefaultStickyTTL
+	}
+	return &StickySelector{
+		inner: inner,
+		store: store,
+		ttl:   ttl,
+	}
+}
+
+// StickySelector
+//
+//	@Description: 记住 routerId 选中的实例，记录过期前相同 routerId 选中同一实例（如重连的玩家回到原来的实例）
+type StickySelector struct {
+	inner     Selector
+	store     StickyStore
+	ttl       time.Duration
+	available atomic.Pointer[map[string]struct{}]
+}
+
+func (selector *StickySelector) Select(routerId string) (string, error) {
+	return selector.SelectWithHints(routerId, nil)
+}
+
+// SelectWithHints
+//
+//	@Description: 有可用记录时忽略提示，否则按提示由 inner 选择
+//	@receiver selector
+//	@param routerId
+//	@param hints
+//	@return string
+//	@return error
+func (selector *StickySelector) SelectWithHints(routerId string, hints map[string]string) (string, error) {
+	if len(routerId) <= 0 {
+		return SelectWithHints(selector.inner, routerId, hints)
+	}
+	instId, ok, err := selector.store.Get(routerId)
+	if err != nil {
+		plog.Error("get sticky instance error:", pfield.String("routerId", routerId), pfield.Error(err))
+	} else if ok {
+		if selector.isAvailable(instId) {
+			selector.remember(routerId, instId)
+			return instId, nil
+		}
+	}
+	instId, err = SelectWithHints(selector.inner, routerId, hints)
+	if err != nil || len(instId) <= 0 {
+		return instId, err
+	}
+	selector.remember(routerId, instId)
+	return instId, nil
+}
+
+func (selector *StickySelector) Update(infoArr []common.Info) {
+	available := make(map[string]struct{}, len(infoArr))
+	for _, info := range infoArr {
+		available[info.ServiceId()] = struct{}{}
+	}
+	selector.available.Store(&available)
+	selector.inner.Update(infoArr)
+}
+
+func (selector *StickySelector) OnBind(instId string) {
+	if observer, ok := selector.inner.(BindObserver); ok {
+		observer.OnBind(instId)
+	}
+}
+
+func (selector *StickySelector) OnUnbind(instId string) {
+	if observer, ok := selector.inner.(BindObserver); ok {
+		observer.OnUnbind(instId)
+	}
+}
+
+func (selector *StickySelector) isAvailable(instId string) bool {
+	available := selector.available.Load()
+	if available == nil {
+		return false
+	}
+	_, ok := (*available)[instId]
+	return ok
+}
+
+func (selector *StickySelector) remember(routerId, instId string) {
+	if err := selector.store.Set(routerId, instId, selector.ttl); err != nil {
+		plog.Error("set sticky instance error:", pfield.String("routerId", routerId), pfield.Error(err))
+	}
+}
+
+// NewMemoryStickyStore
+//
+//	@Description: 构建本地内存存储
+//	@return *MemoryStickyStore
+func NewMemoryStickyStore() *MemoryStickyStore {
+	return &MemoryStickyStore{
+		entries: make(map[string]stickyEntry),
+	}
+}
+
+type stickyEntry struct {
+	instId   string
+	deadline int64
+}
+
+// MemoryStickyStore
+//
+//	@Description: 本地内存存储，过期记录在读取及定期写入时清理
+type MemoryStickyStore struct {
+	mu        sync.Mutex
+	entries   map[string]stickyEntry
+	nextSweep int64
+}
+
+func (store *MemoryStickyStore) Get(routerId string) (string, bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	entry, ok := store.entries[routerId]
+	if !ok {
+		return "", false, nil
+	}
+	if entry.deadline <= time.Now().UnixMilli() {
+		delete(store.entries, routerId)
+		return "", false, nil
+	}
+	return entry.instId, true, nil
+}
+
+func (store *MemoryStickyStore) Set(routerId string, instId string, ttl time.Duration) error {
+	now := time.Now().UnixMilli()
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.entries[routerId] = stickyEntry{
+		instId:   instId,
+		deadline: now + ttl.Milliseconds(),
+	}
+	// 每个过期周期清理一次，避免不再访问的记录堆积
+	if now >= store.nextSweep {
+		store.nextSweep = now + ttl.Milliseconds()
+		for key, entry := range store.entries {
+			if entry.deadline <= now {
+				delete(store.entries, key)
+			}
+		}
+	}
+	return nil
+}
+
+func (store *MemoryStickyStore) Delete(routerId string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delete(store.entries, routerId)
+	return nil
+}
+
+// Len
+//
+//	@Description: 记录数（含未清理的过期记录）
+//	@receiver store
+//	@return int
+func (store *MemoryStickyStore) Len() int {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return len(store.entries)
+}
+
+// NewCacheStickyStore
+//
+//	@Description: 构建基于分布式缓存的存储，多个网关共享记录
+//	@param c 分布式缓存
+//	@param prefix 缓存键前缀
+//	@param signature 缓存签名，共享记录的网关需一致
+//	@return *CacheStickyStore
+func NewCacheStickyStore(c *cache.Cache, prefix string, signature [8]byte) *CacheStickyStore {
+	return &CacheStickyStore{
+		cache:     c,
+		prefix:    prefix,
+		signature: signature,
+	}
+}
+
+// CacheStickyStore
+//
+//	@Description: 基于分布式缓存的存储
+type CacheStickyStore struct {
+	cache     *cache.Cache
+	prefix    string
+	signature [8]byte
+}
+
+func (store *CacheStickyStore) Get(routerId string) (string, bool, error) {
+	return store.cache.Get(store.prefix + routerId)
+}
+
+func (store *CacheStickyStore) Set(routerId string, instId string, ttl time.Duration) error {
+	// 缓存过期时间以秒为单位，不足1秒按1秒计
+	expireSec := int64((ttl + time.Second - 1) / time.Second)
+	if expireSec <= 0 {
+		expireSec = 1
+	}
+	_, err := store.cache.AddOrUpdate(store.prefix+routerId, instId, store.signature, expireSec, 0, 0, 0, nil)
+	return err
+}
+
+func (store *CacheStickyStore) Delete(routerId string) error {
+	return store.cache.Delete(store.prefix+routerId, store.signature)
+}
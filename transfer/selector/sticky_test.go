@@ -0,0 +1,148 @@
+package selector
+
+import (
+	"errors"
+	"github.com/meow-pad/chinchilla/transfer/common"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// testRoundSelector
+//
+//	@Description: 轮流选择实例并记录调用次数
+type testRoundSelector struct {
+	instIds []string
+	calls   int
+}
+
+func (selector *testRoundSelector) Select(string) (string, error) {
+	if len(selector.instIds) <= 0 {
+		return "", common.ErrEmptyInstances
+	}
+	instId := selector.instIds[selector.calls%len(selector.instIds)]
+	selector.calls++
+	return instId, nil
+}
+
+func (selector *testRoundSelector) Update(infoArr []common.Info) {
+	selector.instIds = selector.instIds[:0]
+	for _, info := range infoArr {
+		selector.instIds = append(selector.instIds, info.ServiceId())
+	}
+}
+
+// testFailStore
+//
+//	@Description: 总是失败的存储
+type testFailStore struct{}
+
+var errTestStore = errors.New("store unavailable")
+
+func (testFailStore) Get(string) (string, bool, error) {
+	return "", false, errTestStore
+}
+
+func (testFailStore) Set(string, string, time.Duration) error {
+	return errTestStore
+}
+
+func (testFailStore) Delete(string) error {
+	return errTestStore
+}
+
+func TestStickySelector_Hit(t *testing.T) {
+	should := require.New(t)
+	inner := &testRoundSelector{}
+	selector := NewStickySelector(inner, nil, time.Hour)
+	selector.Update(testInfos(3))
+	first, err := selector.Select("router1")
+	should.Nil(err)
+	for i := 0; i < 5; i++ {
+		instId, err := selector.Select("router1")
+		should.Nil(err)
+		should.Equal(first, instId)
+	}
+	should.Equal(1, inner.calls)
+	// 其他 routerId 及空 routerId 由 inner 选择
+	second, err := selector.Select("router2")
+	should.Nil(err)
+	should.NotEqual(first, second)
+	_, err = selector.Select("")
+	should.Nil(err)
+	should.Equal(3, inner.calls)
+}
+
+func TestStickySelector_Unavailable(t *testing.T) {
+	should := require.New(t)
+	inner := &testRoundSelector{}
+	selector := NewStickySelector(inner, nil, time.Hour)
+	infoArr := testInfos(3)
+	selector.Update(infoArr)
+	first, err := selector.Select("router1")
+	should.Nil(err)
+	should.Equal(infoArr[0].ServiceId(), first)
+	// 记录的实例下线后由 inner 重新选择并记住
+	selector.Update(infoArr[1:])
+	instId, err := selector.Select("router1")
+	should.Nil(err)
+	should.NotEqual(first, instId)
+	should.Equal(2, inner.calls)
+	again, err := selector.Select("router1")
+	should.Nil(err)
+	should.Equal(instId, again)
+	should.Equal(2, inner.calls)
+}
+
+func TestStickySelector_Expire(t *testing.T) {
+	should := require.New(t)
+	inner := &testRoundSelector{}
+	store := NewMemoryStickyStore()
+	selector := NewStickySelector(inner, store, 20*time.Millisecond)
+	selector.Update(testInfos(3))
+	first, err := selector.Select("router1")
+	should.Nil(err)
+	time.Sleep(40 * time.Millisecond)
+	// 过期后重新选择
+	instId, err := selector.Select("router1")
+	should.Nil(err)
+	should.NotEqual(first, instId)
+	should.Equal(2, inner.calls)
+	should.Equal(1, store.Len())
+	should.Nil(store.Delete("router1"))
+	should.Zero(store.Len())
+}
+
+func TestStickySelector_InvalidTTL(t *testing.T) {
+	should := require.New(t)
+	for _, ttl := range []time.Duration{0, -time.Second} {
+		selector := NewStickySelector(&testRoundSelector{}, nil, ttl)
+		should.Equal(DefaultStickyTTL, selector.(*StickySelector).ttl)
+		selector.Update(testInfos(2))
+		first, err := selector.Select("router1")
+		should.Nil(err)
+		// 记录不会立即过期
+		instId, err := selector.Select("router1")
+		should.Nil(err)
+		should.Equal(first, instId)
+	}
+}
+
+func TestStickySelector_StoreError(t *testing.T) {
+	should := require.New(t)
+	inner := &testRoundSelector{}
+	selector := NewStickySelector(inner, testFailStore{}, time.Hour)
+	infoArr := testInfos(2)
+	selector.Update(infoArr)
+	// 存储不可用时退化为 inner
+	for i := 0; i < 4; i++ {
+		instId, err := selector.Select("router1")
+		should.Nil(err)
+		should.Equal(infoArr[i%2].ServiceId(), instId)
+	}
+	should.Equal(4, inner.calls)
+	// inner 的错误原样返回
+	selector.Update(nil)
+	_, err := selector.Select("router1")
+	should.ErrorIs(err, common.ErrEmptyInstances)
+}
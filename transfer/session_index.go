@@ -0,0 +1,85 @@
+package transfer
+
+import (
+	"sync"
+)
+
+func NewSessionIndex() *SessionIndex {
+	return &SessionIndex{
+		routerConns: make(map[string]uint64),
+		connRouters: make(map[uint64]string),
+	}
+}
+
+// SessionIndex
+//
+//	@Description: 网关侧已注册会话的 RouterId（如uid）索引
+type SessionIndex struct {
+	mu          sync.RWMutex
+	routerConns map[string]uint64 // RouterId -> 连接
+	connRouters map[uint64]string // 连接 -> RouterId
+}
+
+// Bind
+//
+//	@Description: 绑定连接的 RouterId，同一 RouterId 以最后绑定的连接为准
+//	@receiver index
+//	@param routerId
+//	@param connId
+func (index *SessionIndex) Bind(routerId string, connId uint64) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	index.unbind(connId)
+	if oldConnId, ok := index.routerConns[routerId]; ok {
+		delete(index.connRouters, oldConnId)
+	}
+	index.routerConns[routerId] = connId
+	index.connRouters[connId] = routerId
+}
+
+// Unbind
+//
+//	@Description: 解除连接的绑定（如连接关闭）
+//	@receiver index
+//	@param connId
+func (index *SessionIndex) Unbind(connId uint64) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	index.unbind(connId)
+}
+
+func (index *SessionIndex) unbind(connId uint64) {
+	routerId, ok := index.connRouters[connId]
+	if !ok {
+		return
+	}
+	delete(index.connRouters, connId)
+	if index.routerConns[routerId] == connId {
+		delete(index.routerConns, routerId)
+	}
+}
+
+// Lookup
+//
+//	@Description: 查询 RouterId 对应的连接
+//	@receiver index
+//	@param routerId
+//	@return uint64
+//	@return bool
+func (index *SessionIndex) Lookup(routerId string) (uint64, bool) {
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+	connId, ok := index.routerConns[routerId]
+	return connId, ok
+}
+
+// Len
+//
+//	@Description: 已绑定的会话数
+//	@receiver index
+//	@return int
+func (index *SessionIndex) Len() int {
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+	return len(index.routerConns)
+}
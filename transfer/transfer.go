@@ -2,15 +2,20 @@ package transfer
 
 import (
 	"context"
+	"fmt"
 	"github.com/meow-pad/chinchilla/option"
+	rcontext "github.com/meow-pad/chinchilla/receiver/context"
 	"github.com/meow-pad/chinchilla/transfer/codec"
 	"github.com/meow-pad/chinchilla/transfer/router"
 	"github.com/meow-pad/chinchilla/transfer/selector"
+	"github.com/meow-pad/chinchilla/transfer/service"
 	"github.com/meow-pad/chinchilla/utils/gopool"
+	"github.com/meow-pad/persian/errdef"
 	"github.com/meow-pad/persian/frame/pboot"
 	"github.com/meow-pad/persian/frame/plog"
 	"github.com/meow-pad/persian/frame/plog/pfield"
 	"github.com/meow-pad/persian/frame/pnet/tcp/session"
+	"github.com/meow-pad/persian/utils/coding"
 	"github.com/meow-pad/persian/utils/timewheel"
 	"github.com/meow-pad/persian/utils/worker"
 	"github.com/nacos-group/nacos-sdk-go/v2/model"
@@ -42,9 +47,12 @@ type Transfer struct {
 	GoPool   *gopool.GoPool
 
 	registry      *Registry
-	selector      selector.Selector
-	router        router.Router
+	router        *router.Registry
 	executor      *worker.FixedWorkerPool
+	routeExecutor *worker.FixedWorkerPool
+	groupMgr      *GroupManager
+	sessionIndex  *SessionIndex
+	mesh          *Mesh
 	clientMgrMap  map[string]*Manager
 	cleanTask     *timewheel.Task
 	keepAliveTask *timewheel.Task
@@ -56,15 +64,25 @@ func (transfer *Transfer) init() (err error) {
 	if err != nil {
 		return err
 	}
-	if options.ServiceSelector == nil {
-		transfer.selector = selector.NewWeightSelector()
-	} else {
-		transfer.selector = options.ServiceSelector
+	if options.ServiceSelector != nil && len(options.RegistryServiceNames) > 1 {
+		return fmt.Errorf("%w: ServiceSelector is shared by services, use ServiceSelectorFactory instead",
+			errdef.ErrInvalidParams)
 	}
-	if options.ServiceRouter == nil {
-		transfer.router = &router.CommonRouter{}
-	} else {
-		transfer.router = options.ServiceRouter
+	transfer.router = router.NewRegistry(options.ServiceRouter)
+	if err = registerManagerRoutes(transfer.router); err != nil {
+		return err
+	}
+	for routerType, route := range options.ServiceRoutes {
+		if err = transfer.router.Register(routerType, route); err != nil {
+			return err
+		}
+	}
+	transfer.groupMgr = NewGroupManager()
+	transfer.sessionIndex = NewSessionIndex()
+	if len(options.MeshServiceName) > 0 {
+		if transfer.mesh, err = NewMesh(transfer); err != nil {
+			return
+		}
 	}
 	if transfer.executor, err = worker.NewFixedWorkerPool(
 		options.MessageExecutorWorkerNum,
@@ -73,6 +91,15 @@ func (transfer *Transfer) init() (err error) {
 	); err != nil {
 		return
 	}
+	if !options.RouterMessageUnordered {
+		if transfer.routeExecutor, err = worker.NewFixedWorkerPool(
+			options.RouterExecutorWorkerNum,
+			options.RouterExecutorQueueLength,
+			true,
+		); err != nil {
+			return
+		}
+	}
 	return
 }
 
@@ -92,10 +119,20 @@ func (transfer *Transfer) Start(ctx context.Context) error {
 	if err := transfer.registry.Start(ctx); err != nil {
 		return err
 	}
+	if transfer.mesh != nil {
+		if err := transfer.mesh.Start(ctx); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 func (transfer *Transfer) Stop(ctx context.Context) error {
+	if transfer.mesh != nil {
+		if err := transfer.mesh.Stop(ctx); err != nil {
+			plog.Error("stop mesh error:", pfield.Error(err))
+		}
+	}
 	if err := transfer.registry.Stop(ctx); err != nil {
 		plog.Error("stop registry error:", pfield.Error(err))
 	}
@@ -120,6 +157,162 @@ func (transfer *Transfer) Forward(connId int64, task func(*worker.GoroutineLocal
 	}
 }
 
+// ForwardWait
+//
+//	@Description: 指定连接的任务处理，并等待其完成
+//	@receiver transfer
+//	@param ctx 取消或到期时不再等待（任务仍可能执行）
+//	@param connId
+//	@param task
+//	@return error 提交失败、任务返回的错误、任务 panic 或 ctx.Err()
+func (transfer *Transfer) ForwardWait(ctx context.Context, connId int64, task func(*worker.GoroutineLocal) error) error {
+	errChan := make(chan error, 1)
+	if err := transfer.executor.Submit(int(connId), func(local *worker.GoroutineLocal) {
+		err := error(nil)
+		defer coding.HandlePanicError("forward task panic", func(aErr any) {
+			if aErr != nil {
+				err = fmt.Errorf("forward task panic: %v", aErr)
+			}
+			errChan <- err
+		}, pfield.Int64("connId", connId))
+		err = task(local)
+	}); err != nil {
+		return err
+	}
+	select {
+	case err := <-errChan:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ForwardAll
+//
+//	@Description: 所有连接的任务处理，每个工作协程执行一次
+//	@receiver transfer
+//	@param task
+func (transfer *Transfer) ForwardAll(task func(*worker.GoroutineLocal)) {
+	if err := transfer.executor.SubmitToAll(task, true); err != nil {
+		plog.Error("forward task to all error:", pfield.Error(err))
+	}
+}
+
+// RouteRegistry
+//
+//	@Description: 路由类型注册表，可在运行时注册自定义路由类型
+//	@receiver transfer
+//	@return *router.Registry
+func (transfer *Transfer) RouteRegistry() *router.Registry {
+	return transfer.router
+}
+
+// SubmitRoute
+//
+//	@Description: 提交路由任务，保序时相同 key 的任务按提交顺序执行
+//	@receiver transfer
+//	@param key
+//	@param task
+//	@return error
+func (transfer *Transfer) SubmitRoute(key uint32, task func()) error {
+	if transfer.routeExecutor == nil {
+		return transfer.GoPool.Submit(task)
+	}
+	return transfer.routeExecutor.Submit(int(key), func(*worker.GoroutineLocal) {
+		task()
+	})
+}
+
+// newSelector
+//
+//	@Description: 构建服务的选择器，依次使用 ServiceSelectorFactories、ServiceSelectorFactory、ServiceSelector
+//	@receiver transfer
+//	@param srvName
+//	@return selector.Selector
+func (transfer *Transfer) newSelector(srvName string) selector.Selector {
+	options := transfer.Options
+	var srvSelector selector.Selector
+	if factory := options.ServiceSelectorFactories[srvName]; factory != nil {
+		srvSelector = factory(srvName)
+	} else if options.ServiceSelectorFactory != nil {
+		srvSelector = options.ServiceSelectorFactory(srvName)
+	} else {
+		srvSelector = options.ServiceSelector
+	}
+	if srvSelector == nil {
+		srvSelector = selector.NewWeightSelector()
+	}
+	return srvSelector
+}
+
+// OnServiceBind
+//
+//	@Description: 会话绑定的服务实例变化时通知选择器（见 selector.BindObserver）
+//	@receiver transfer
+//	@param srvName
+//	@param oldSrv 原绑定的实例，可为nil
+//	@param newSrv 新绑定的实例，可为nil（如会话关闭）
+func (transfer *Transfer) OnServiceBind(srvName string, oldSrv, newSrv service.Service) {
+	manager := transfer.GetServiceManager(srvName)
+	if manager == nil {
+		return
+	}
+	observer, ok := manager.selector.(selector.BindObserver)
+	if !ok {
+		return
+	}
+	if oldSrv != nil {
+		observer.OnUnbind(oldSrv.Info().ServiceId())
+	}
+	if newSrv != nil {
+		observer.OnBind(newSrv.Info().ServiceId())
+	}
+}
+
+// QuerySessions
+//
+//	@Description: 查询满足条件的连接（如按会话属性查询）
+//	@receiver transfer
+//	@param ctx
+//	@param filter
+//	@return []uint64
+//	@return error
+func (transfer *Transfer) QuerySessions(ctx context.Context,
+	filter func(connId uint64, senderCtx rcontext.SenderContext) bool) ([]uint64, error) {
+	workerNum := transfer.executor.SlotNum()
+	resultChan := make(chan []uint64, workerNum)
+	if err := transfer.executor.SubmitToAll(func(local *worker.GoroutineLocal) {
+		var connIds []uint64
+		local.Range(func(key, val any) bool {
+			sess, _ := val.(session.Session)
+			if sess == nil || sess.IsClosed() {
+				return false
+			}
+			senderCtx, _ := sess.Context().(rcontext.SenderContext)
+			if senderCtx == nil {
+				return false
+			}
+			if filter(sess.Id(), senderCtx) {
+				connIds = append(connIds, sess.Id())
+			}
+			return false
+		})
+		resultChan <- connIds
+	}, true); err != nil {
+		return nil, err
+	}
+	var result []uint64
+	for i := 0; i < workerNum; i++ {
+		select {
+		case connIds := <-resultChan:
+			result = append(result, connIds...)
+		case <-ctx.Done():
+			return result, ctx.Err()
+		}
+	}
+	return result, nil
+}
+
 // UpdateInstances
 //
 //	@Description: 更新服务实例
@@ -128,6 +321,10 @@ func (transfer *Transfer) Forward(connId int64, task func(*worker.GoroutineLocal
 //	@param instances
 func (transfer *Transfer) UpdateInstances(srvName string, instances []model.Instance) {
 	if err := transfer.executor.Submit(0, func(*worker.GoroutineLocal) {
+		if transfer.mesh != nil && srvName == transfer.Options.MeshServiceName {
+			transfer.mesh.updatePeers(instances)
+			return
+		}
 		manager := transfer.clientMgrMap[srvName]
 		if manager == nil {
 			plog.Error("unknown service", pfield.String("srvName", srvName))
@@ -144,23 +341,35 @@ func (transfer *Transfer) UpdateInstances(srvName string, instances []model.Inst
 //	@Description: 清理本地缓存中过期session
 //	@receiver transfer
 func (transfer *Transfer) cleanExpiredSessions() {
-	if err := transfer.executor.SubmitToAll(func(local *worker.GoroutineLocal) {
-		var toDelete []any
-		local.Range(func(key, val any) bool {
-			sess := val.(session.Session)
-			if sess != nil {
-				// 已经关闭的连接需要清理
-				if sess.IsClosed() {
-					toDelete = append(toDelete, key)
-				}
+	if err := transfer.executor.SubmitToAll(transfer.cleanClosedSessions, false); err != nil {
+		plog.Error("submit clean-expired-serverSession-task error:", pfield.Error(err))
+	}
+}
+
+// cleanClosedSessions
+//
+//	@Description: 清理工作协程本地缓存中已关闭的session
+//	@receiver transfer
+//	@param local
+func (transfer *Transfer) cleanClosedSessions(local *worker.GoroutineLocal) {
+	var toDelete []any
+	local.Range(func(key, val any) bool {
+		sess := val.(session.Session)
+		if sess != nil {
+			// 已经关闭的连接需要清理
+			if sess.IsClosed() {
+				toDelete = append(toDelete, key)
 			}
-			return true
-		})
-		for _, key := range toDelete {
-			local.Remove(key)
 		}
-	}, false); err != nil {
-		plog.Error("submit clean-expired-serverSession-task error:", pfield.Error(err))
+		// 返回 true 会中断遍历
+		return false
+	})
+	for _, key := range toDelete {
+		local.Remove(key)
+		if connId, ok := key.(uint64); ok {
+			transfer.groupMgr.LeaveAll(connId)
+			transfer.sessionIndex.Unbind(connId)
+		}
 	}
 }
 
@@ -172,9 +381,29 @@ func (transfer *Transfer) keepClientsAlive() {
 	for _, manager := range transfer.clientMgrMap {
 		manager.KeepClientsAlive()
 	}
+	if transfer.mesh != nil {
+		transfer.mesh.keepAlive()
+	}
 }
 
 func (transfer *Transfer) GetServiceManager(service string) *Manager {
 	manager, _ := transfer.clientMgrMap[service]
 	return manager
 }
+
+func (transfer *Transfer) GetGroupManager() *GroupManager {
+	return transfer.groupMgr
+}
+
+func (transfer *Transfer) GetSessionIndex() *SessionIndex {
+	return transfer.sessionIndex
+}
+
+// GetMesh
+//
+//	@Description: 网关组网，未配置 MeshServiceName 时为nil
+//	@receiver transfer
+//	@return *Mesh
+func (transfer *Transfer) GetMesh() *Mesh {
+	return transfer.mesh
+}
@@ -0,0 +1,152 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"github.com/meow-pad/chinchilla/option"
+	"github.com/meow-pad/chinchilla/transfer/codec"
+	"github.com/meow-pad/chinchilla/transfer/common"
+	"github.com/meow-pad/chinchilla/transfer/selector"
+	"github.com/meow-pad/persian/frame/pnet/tcp/session"
+	"github.com/meow-pad/persian/frame/pnet/utils"
+	"github.com/meow-pad/persian/utils/worker"
+	"github.com/nacos-group/nacos-sdk-go/v2/model"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testSession
+//
+//	@Description: 仅实现测试所需方法的会话
+type testSession struct {
+	session.Session
+
+	id     uint64
+	closed bool
+}
+
+func (sess *testSession) Id() uint64 {
+	return sess.id
+}
+
+func (sess *testSession) IsClosed() bool {
+	return sess.closed
+}
+
+func TestTransfer_CleanClosedSessions(t *testing.T) {
+	should := require.New(t)
+	transfer := &Transfer{
+		groupMgr:     NewGroupManager(),
+		sessionIndex: NewSessionIndex(),
+	}
+	local := &worker.GoroutineLocal{}
+	for connId := uint64(1); connId <= 10; connId++ {
+		local.Set(connId, &testSession{id: connId, closed: connId%2 == 0})
+		transfer.groupMgr.Join("room", connId)
+		transfer.sessionIndex.Bind(strconv.FormatUint(connId, 10), connId)
+	}
+	transfer.cleanClosedSessions(local)
+	// 所有已关闭的会话都需清理，而不只是遍历到的第一个
+	for connId := uint64(1); connId <= 10; connId++ {
+		_, ok := local.Get(connId)
+		_, indexed := transfer.sessionIndex.Lookup(strconv.FormatUint(connId, 10))
+		closed := connId%2 == 0
+		should.Equal(!closed, ok)
+		should.Equal(!closed, indexed)
+		if closed {
+			should.Nil(transfer.groupMgr.Groups(connId))
+		} else {
+			should.Equal([]string{"room"}, transfer.groupMgr.Groups(connId))
+		}
+	}
+}
+
+func TestTransfer_ForwardWait(t *testing.T) {
+	should := require.New(t)
+	executor, err := worker.NewFixedWorkerPool(2, 10, true)
+	should.Nil(err)
+	transfer := &Transfer{executor: executor}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	taskErr := errors.New("task error")
+	should.ErrorIs(transfer.ForwardWait(ctx, 1, func(*worker.GoroutineLocal) error {
+		return taskErr
+	}), taskErr)
+	// panic 时返回错误而不是一直等待，工作协程仍可用
+	err = transfer.ForwardWait(ctx, 1, func(*worker.GoroutineLocal) error {
+		panic("boom")
+	})
+	should.ErrorContains(err, "boom")
+	should.Nil(transfer.ForwardWait(ctx, 1, func(*worker.GoroutineLocal) error {
+		return nil
+	}))
+	// 任务未及时执行时按 ctx 返回
+	release := make(chan struct{})
+	transfer.Forward(1, func(*worker.GoroutineLocal) {
+		<-release
+	})
+	shortCtx, shortCancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer shortCancel()
+	should.ErrorIs(transfer.ForwardWait(shortCtx, 1, func(*worker.GoroutineLocal) error {
+		return nil
+	}), context.DeadlineExceeded)
+	close(release)
+	// 关闭后提交失败
+	should.Nil(executor.Shutdown(ctx))
+	should.ErrorIs(transfer.ForwardWait(ctx, 1, func(*worker.GoroutineLocal) error {
+		return nil
+	}), worker.ErrWorkerPoolClosed)
+}
+
+func TestTransfer_OnServiceBind(t *testing.T) {
+	should := require.New(t)
+	manager := newTestManager(t, option.WithServiceSelectorFactory(func(string) selector.Selector {
+		return selector.NewLeastConnSelector()
+	}))
+	manager.transfer.clientMgrMap = map[string]*Manager{"test": manager}
+	leastConn := manager.selector.(*selector.LeastConnSelector)
+	srvArr := make([]*testPeer, 0, 2)
+	for _, instId := range []string{"inst1", "inst2"} {
+		srvArr = append(srvArr, &testPeer{info: common.Info(model.Instance{
+			Metadata: map[string]string{common.MetadataKeyId: instId},
+		})})
+	}
+	manager.transfer.OnServiceBind("test", nil, srvArr[0])
+	should.Equal(int64(1), leastConn.Count("inst1"))
+	manager.transfer.OnServiceBind("test", srvArr[0], srvArr[1])
+	should.Zero(leastConn.Count("inst1"))
+	should.Equal(int64(1), leastConn.Count("inst2"))
+	// 未知服务忽略
+	manager.transfer.OnServiceBind("unknown", srvArr[1], nil)
+	should.Equal(int64(1), leastConn.Count("inst2"))
+	manager.transfer.OnServiceBind("test", srvArr[1], nil)
+	should.Zero(leastConn.Count("inst2"))
+}
+
+func TestListener_ReleaseForwarded(t *testing.T) {
+	should := require.New(t)
+	executor, err := worker.NewFixedWorkerPool(2, 10, true)
+	should.Nil(err)
+	manager := newTestManager(t)
+	manager.transfer.executor = executor
+	fCodec, err := codec.NewCodec(codec.NewServerCodec(codec.MessageCodecByteOrder), 8*1024)
+	should.Nil(err)
+	dCodec, err := codec.NewCodec(codec.NewClientCodec(codec.MessageCodecByteOrder), 8*1024)
+	should.Nil(err)
+	data, err := fCodec.Encode(&codec.MessageSRes{ConnId: 1, Payload: []byte{1, 2, 3}})
+	should.Nil(err)
+	dMessages, _, err := dCodec.Decode(utils.NewBytesReader(data))
+	should.Nil(err)
+	res := dMessages[0].(*codec.MessageSRes)
+	should.Equal([]byte{1, 2, 3}, res.Payload)
+	// 转发完成后（包括会话已不存在）归还读缓存
+	(&listener{manager: manager}).handleMessageRes(res)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	should.Nil(manager.transfer.ForwardWait(ctx, 1, func(*worker.GoroutineLocal) error {
+		return nil
+	}))
+	should.Nil(res.Payload)
+}
@@ -13,10 +13,6 @@ const (
 	SessionContextIdInvalid = 0
 )
 
-const (
-	RpcCodeSuccess = iota
-)
-
 const (
 	ServerCodeInvalidTransferId = iota + 1
 	ServerCodeInvalidAuth
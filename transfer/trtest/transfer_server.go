@@ -45,7 +45,7 @@ type TransferServer struct {
 	Runtime *TransferRuntime
 	Options *TransferServerOptions
 
-	msgCoder    tcpcodec.Codec
+	msgCoder    *codec.FrameCodec
 	innerSvr    *server.Server
 	userMgr     *TSUserManager
 	rpcMgr      *TSRPCManager
@@ -65,7 +65,6 @@ func (ts *TransferServer) init() error {
 
 	appInfo := NewAppInfo(ts.Options.ServiceName, ts.Options.ServiceId, ts.Options.IP, ts.Options.Port)
 	ts.userMgr = newTSUserManager(ts.Runtime)
-	ts.rpcMgr = newTSRPCManager(ts.Runtime, ts)
 	ts.sessMgr = newTSSessManager(ts.Runtime)
 	ts.naming = newTSNaming(appInfo, ts.nacosNaming)
 	addr := fmt.Sprintf("%s:%d", ts.Options.IP, ts.Options.Port)
@@ -75,6 +74,7 @@ func (ts *TransferServer) init() error {
 	} else {
 		ts.msgCoder = msgCoder
 	}
+	ts.rpcMgr = newTSRPCManager(ts.Runtime, ts)
 	if innerSvr, sErr := server.NewServer("transfer-receiver-server", addr, msgCoder,
 		&TSListener{
 			msgHandler: newTSHandler(ts, ts.rpcMgr),
@@ -170,19 +170,8 @@ func (ts *TransferServer) tick() {
 				pfield.String("srvId", ts.ServiceId()),
 				pfield.String("targetSrvId", tsOptions.ServiceId),
 			)
-			ts.rpcMgr.SendRPCRequest(sess, tsOptions.ServiceName, tsOptions.ServiceId, &RPCEchoReq{
-				Msg: fmt.Sprintf("hello from %s, to %s", ts.ServiceId(), tsOptions.ServiceId),
-			}, func(msg Message) {
-				if echoResp, _ := msg.(*RPCEchoResp); echoResp != nil {
-					plog.Info("transfer server receive echo response", pfield.Any("msg", msg))
-				} else {
-					if errResp, _ := msg.(*RPCErrResp); errResp != nil {
-						plog.Error("transfer server receive error response", pfield.String("errResp", errResp.Err))
-					} else {
-						plog.Error("transfer server receive invalid echo response", pfield.Any("msg", msg))
-					}
-				}
-			})
+			ts.rpcMgr.Echo(tsOptions.ServiceName, tsOptions.ServiceId,
+				fmt.Sprintf("hello from %s, to %s", ts.ServiceId(), tsOptions.ServiceId))
 		}
 	}
 }
@@ -29,10 +29,19 @@ func (handler *TSHandler) HandleMessage(sess session.Session, msg any) error {
 	switch req := msg.(type) {
 	case *codec.MessageSReq:
 		return handler.handleMessagesReq(sess, req)
+	case *codec.BatchSReq:
+		for _, entry := range req.Entries {
+			if err := handler.handleMessagesReq(sess, &codec.MessageSReq{ConnId: entry.ConnId, Payload: entry.Payload}); err != nil {
+				return err
+			}
+		}
+		return nil
 	case *codec.RpcRReq:
 		return handler.RPCMgr.HandleRPCRequest(sess, req)
 	case *codec.RpcRRes:
 		return handler.RPCMgr.HandleRPCResponse(sess, req)
+	case *codec.MessageRouterRes:
+		return handler.RPCMgr.HandleRouteFailure(sess, req)
 	case *codec.RegisterSReq:
 		return handler.handleRegistersReq(sess, req)
 	case *codec.UnregisterSReq:
@@ -263,7 +272,12 @@ func (handler *TSHandler) handleHandshakeReq(sess session.Session, req *codec.Ha
 		return nil
 	}
 	tCtx.ShakeHand(req.Id)
-	sess.SendMessage(&codec.HandshakeRes{})
+	// 编码器为所有会话共享，下行超长消息仍分段发送，仅告知可接收的帧长度
+	sess.SendMessage(&codec.HandshakeRes{
+		MaxFrameSize: uint32(handler.Server.msgCoder.MaxFrameSize()),
+		Version:      codec.ProtocolVersion,
+		Capabilities: req.Capabilities & codec.SupportedCapabilities,
+	})
 	handler.Server.sessMgr.AddSess(req.Id, sess)
 	plog.Debug("=(transfer server handler) handshake with client",
 		pfield.String("serviceId", handler.Server.ServiceId()),
@@ -274,7 +288,19 @@ func (handler *TSHandler) handleHandshakeReq(sess session.Session, req *codec.Ha
 }
 
 func (handler *TSHandler) handleSegmentMsg(session session.Session, msg *codec.SegmentMsg) error {
-	return fmt.Errorf("unsupported SegmentMsg")
+	tCtx := coding.Cast[*RemoteContext](session.Context())
+	if tCtx == nil {
+		return fmt.Errorf("unsupported SegmentMsg")
+	}
+	buf, err := tCtx.Reassembler().Push(msg)
+	if err != nil || buf == nil {
+		return err
+	}
+	sMsg, err := serverCodec.DecodeBorrowed(buf)
+	if err != nil {
+		return err
+	}
+	return handler.HandleMessage(session, sMsg)
 }
 
 func (handler *TSHandler) HandleServiceInstRes(sess session.Session, msg *codec.ServiceInstIRes) error {
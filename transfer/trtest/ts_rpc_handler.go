@@ -1,30 +1,22 @@
 package trtest
 
 import (
-	"fmt"
-	"github.com/meow-pad/persian/utils/coding"
-	"reflect"
+	"context"
+	"github.com/meow-pad/chinchilla/transfer/rpc"
+	"github.com/meow-pad/persian/frame/plog"
+	"github.com/meow-pad/persian/frame/plog/pfield"
 )
 
 type TsRPCHandler struct {
 }
 
-func (rpcHandler *TsRPCHandler) handleRequest(msg Message) (resp Message, err error) {
-	defer coding.HandlePanicError("", func(aErr any) {
-		if aErr != nil {
-			err = fmt.Errorf("%v", aErr)
-		}
-	})
-	switch tMsg := msg.(type) {
-	case *RPCEchoReq:
-		resp, err = rpcHandler.handleEchoReq(tMsg)
-	default:
-		err = fmt.Errorf("unknown message type: %T", reflect.TypeOf(msg).String())
+func (rpcHandler *TsRPCHandler) register(server *rpc.Server) {
+	if err := rpc.Handle(server, RPCMethodEcho, rpcHandler.handleEchoReq); err != nil {
+		plog.Error("register rpc handler error:", pfield.Error(err))
 	}
-	return
 }
 
-func (rpcHandler *TsRPCHandler) handleEchoReq(msg *RPCEchoReq) (Message, error) {
+func (rpcHandler *TsRPCHandler) handleEchoReq(_ context.Context, msg *RPCEchoReq) (*RPCEchoResp, error) {
 	return &RPCEchoResp{
 		Msg: msg.Msg,
 	}, nil
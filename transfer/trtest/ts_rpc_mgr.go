@@ -1,162 +1,109 @@
 package trtest
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"github.com/meow-pad/chinchilla/transfer/codec"
-	"github.com/meow-pad/chinchilla/transfer/router"
+	"github.com/meow-pad/chinchilla/transfer/rpc"
 	"github.com/meow-pad/persian/frame/plog"
 	"github.com/meow-pad/persian/frame/plog/pfield"
 	"github.com/meow-pad/persian/frame/pnet/tcp/session"
 	"github.com/meow-pad/persian/utils/coding"
-	"github.com/meow-pad/persian/utils/collections"
 	"reflect"
-	"sync/atomic"
 )
 
 func newTSRPCManager(runtime *TransferRuntime, server *TransferServer) *TSRPCManager {
-	return &TSRPCManager{
+	manager := &TSRPCManager{
 		Runtime: runtime,
 		Server:  server,
 	}
+	manager.Client = rpc.NewClient(server.ServiceName(), server.ServiceId(), server.MsgCoder(),
+		manager.sendRouterMessage, rpc.WithTimeout(RPCTimeout), rpc.WithRouteFeedback(true))
+	manager.RPCServer = rpc.NewServer(server.ServiceId(), server.MsgCoder())
+	manager.rpcHandler.register(manager.RPCServer)
+	return manager
 }
 
 type TSRPCManager struct {
-	Runtime *TransferRuntime
-	Server  *TransferServer
+	Runtime   *TransferRuntime
+	Server    *TransferServer
+	Client    *rpc.Client
+	RPCServer *rpc.Server
 
-	rpcHandler     TsRPCHandler
-	rpcIdGenerator atomic.Uint32
-	rpcHandlers    collections.SyncMap[uint32, func(msg Message)]
+	rpcHandler TsRPCHandler
 }
 
-func (manager *TSRPCManager) SendRPCRequest(sess session.Session, targetService, targetServiceId string, msg Message, respHandler func(msg Message)) {
-	msgBytes, err := rpcMsgCodec.Encode(msg)
-	if err != nil {
-		respHandler(&RPCErrResp{
-			Err: fmt.Sprintf("encode rpc msg error:%s", err),
-		})
-		return
+func (manager *TSRPCManager) sendRouterMessage(msg *codec.MessageRouter) error {
+	sess := manager.Server.sessMgr.GetOneSession()
+	if sess == nil || sess.IsClosed() {
+		return errors.New("no available transfer session")
 	}
-	rpcId := manager.rpcIdGenerator.Add(1)
+	sess.SendMessage(msg)
+	return nil
+}
 
-	// 构造路由消息
-	sourceSrv := manager.Server.Options.ServiceName
-	sourceSrvId := manager.Server.Options.ServiceId
-	routerMsg := codec.NewRpcRReq(sourceSrv, sourceSrvId, rpcId, msgBytes)
-	routerSrv := targetService
-	msgRouter, err := codec.NewMessageRouter(codec.MessageCodecByteOrder, routerSrv,
-		router.RouteTypeService, targetServiceId, manager.Server.MsgCoder(), routerMsg)
-	if err != nil {
-		respHandler(&RPCErrResp{
-			Err: fmt.Sprintf("construct rpc router msg error:%s", err),
-		})
-		return
-	}
-	manager.rpcHandlers.Store(rpcId, respHandler)
-	manager.Runtime.Timer.AfterFunc(RPCTimeout, func() {
-		dRespHandler, _ := manager.rpcHandlers.Delete(rpcId)
-		if dRespHandler != nil {
-			dRespHandler(&RPCErrResp{
-				Err: "rpc timeout",
-			})
+// Echo
+//
+//	@Description: 异步调用目标服务的 echo 方法
+//	@receiver manager
+//	@param targetService
+//	@param targetServiceId
+//	@param msg
+func (manager *TSRPCManager) Echo(targetService, targetServiceId string, msg string) {
+	err := manager.Runtime.GOPool.Submit(func() {
+		resp, err := rpc.Call[RPCEchoResp](context.Background(), manager.Client,
+			targetService, targetServiceId, RPCMethodEcho, &RPCEchoReq{Msg: msg})
+		if err != nil {
+			plog.Error("transfer server receive error response", pfield.Error(err))
+			return
 		}
+		plog.Info("transfer server receive echo response", pfield.Any("msg", resp))
 	})
-	sess.SendMessage(msgRouter)
+	if err != nil {
+		plog.Error("submit rpc task error:", pfield.Error(err))
+	}
 }
 
 func (manager *TSRPCManager) HandleRPCRequest(sess session.Session, req *codec.RpcRReq) (noReturn error) {
-	tCtx := coding.Cast[*RemoteContext](sess.Context())
-	if tCtx == nil {
-		manager.sendRouterMessage(sess, req.SourceSrv, req.SourceId, &codec.RpcRRes{
-			Code: ServerCodeSessionLessContext,
-		})
-		plog.Error("invalid transfer sess context:",
-			pfield.String("context type", reflect.TypeOf(sess.Context()).String()))
-		return nil
-	}
-	if !tCtx.IsHandShook() {
-		plog.Error("handshake first")
-		manager.sendRouterMessage(sess, req.SourceSrv, req.SourceId, &codec.RpcRRes{
-			Code: ServerCodeSessionHandshakeFirst,
-		})
+	if !manager.checkSession(sess) {
 		return nil
 	}
-	var respMsg Message
-	reqMsg, err := rpcMsgCodec.Decode(req.Payload)
-	if err != nil {
-		respMsg = &RPCErrResp{
-			Err: fmt.Sprintf("decode rpc msg error:%s", err),
-		}
-	} else {
-		respMsg, err = manager.rpcHandler.handleRequest(reqMsg)
-		if err != nil {
-			respMsg = &RPCErrResp{
-				Err: fmt.Sprintf("handle rpc msg error:%s", err),
-			}
-		} else {
-			if respMsg == nil {
-				respMsg = &RPCErrResp{
-					Err: "",
-				}
-			}
-		} // end of else
-	}
+	manager.RPCServer.HandleRequest(sess, req)
+	return nil
+}
 
-	if respBytes, eErr := rpcMsgCodec.Encode(respMsg); eErr != nil {
-		plog.Error("encode rpc response error:", pfield.Error(eErr))
-	} else {
-		// 构造路由消息
-		routerMsg := codec.NewRpcRRes(req.RPCId, respBytes)
-		manager.sendRouterMessage(sess, req.SourceSrv, req.SourceId, routerMsg)
+func (manager *TSRPCManager) HandleRPCResponse(sess session.Session, msg *codec.RpcRRes) (noReturn error) {
+	if !manager.checkSession(sess) {
+		return nil
 	}
-	return
+	manager.Client.HandleResponse(msg)
+	return nil
 }
 
-func (manager *TSRPCManager) sendRouterMessage(sess session.Session, sourceSrv, sourceServiceId string, routerMsg any) {
-	msgRouter, rErr := codec.NewMessageRouter(codec.MessageCodecByteOrder, sourceSrv,
-		router.RouteTypeService, sourceServiceId, manager.Server.MsgCoder(), routerMsg)
-	if rErr != nil {
-		plog.Error("construct rpc router msg error:", pfield.Error(rErr))
+func (manager *TSRPCManager) HandleRouteFailure(sess session.Session, msg *codec.MessageRouterRes) (noReturn error) {
+	if !manager.checkSession(sess) {
+		return nil
+	}
+	if !manager.Client.HandleRouteFailure(msg) {
+		plog.Warn("route failure without pending rpc",
+			pfield.Uint16("code", msg.Code),
+			pfield.String("routerService", msg.RouterService),
+			pfield.String("routerId", msg.RouterId),
+		)
 	}
-	sess.SendMessage(msgRouter)
+	return nil
 }
 
-func (manager *TSRPCManager) HandleRPCResponse(sess session.Session, msg *codec.RpcRRes) (noReturn error) {
+func (manager *TSRPCManager) checkSession(sess session.Session) bool {
 	tCtx := coding.Cast[*RemoteContext](sess.Context())
 	if tCtx == nil {
 		plog.Error("invalid transfer sess context:",
 			pfield.String("context type", reflect.TypeOf(sess.Context()).String()))
-		return nil
+		return false
 	}
 	if !tCtx.IsHandShook() {
 		plog.Error("handshake first")
-		return nil
-	}
-	rpcId := msg.RPCId
-	respHandler, _ := manager.rpcHandlers.Delete(rpcId)
-	if respHandler == nil {
-		plog.Error("rpc response handler not found",
-			pfield.Uint32("rpcId", rpcId),
-			pfield.ByteString("payload", msg.Payload),
-		)
-		return
-	}
-	if msg.Code != RpcCodeSuccess {
-		respHandler(&RPCErrResp{
-			Err: fmt.Sprintf("rpc error code:%d", msg.Code),
-		})
-	} else {
-		respMsg, err := rpcMsgCodec.Decode(msg.Payload)
-		if err != nil {
-			respHandler(&RPCErrResp{
-				Err: fmt.Sprintf("decode rpc response error:%s", err)})
-		} else {
-			plog.Info("handle rpc response",
-				pfield.Uint32("rpcId", rpcId),
-				pfield.ByteString("payload", msg.Payload),
-			)
-			respHandler(respMsg)
-		}
+		return false
 	}
-	return
+	return true
 }
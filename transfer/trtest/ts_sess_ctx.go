@@ -2,6 +2,7 @@ package trtest
 
 import (
 	"fmt"
+	"github.com/meow-pad/chinchilla/transfer/codec"
 	"github.com/meow-pad/persian/frame/pnet/tcp/session"
 	"math"
 	"time"
@@ -9,7 +10,9 @@ import (
 )
 
 func newRemoteContext() (*RemoteContext, error) {
-	remote := &RemoteContext{}
+	remote := &RemoteContext{
+		reassembler: codec.NewReassembler(codec.DefaultReassembleMaxSize, codec.DefaultReassembleTimeout),
+	}
 	// 将对象地址作为会话编号
 	remote.Init(uint64(uintptr(unsafe.Pointer(remote))))
 	if remote.Id() == SessionContextIdInvalid {
@@ -24,6 +27,12 @@ type RemoteContext struct {
 
 	// 实例编号
 	serverInstId string
+	// 分段消息重组
+	reassembler *codec.Reassembler
+}
+
+func (ctx *RemoteContext) Reassembler() *codec.Reassembler {
+	return ctx.reassembler
 }
 
 func (ctx *RemoteContext) ShakeHand(serverInstId string) {
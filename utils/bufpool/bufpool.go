@@ -0,0 +1,73 @@
+package bufpool
+
+import (
+	"math/bits"
+	"sync"
+)
+
+const (
+	minClassBits = 6  // 64B
+	maxClassBits = 20 // 1MB
+)
+
+var (
+	pools [maxClassBits - minClassBits + 1]sync.Pool
+)
+
+func init() {
+	for i := range pools {
+		class := int8(i)
+		classSize := 1 << (i + minClassBits)
+		pools[i].New = func() any {
+			return &Buffer{data: make([]byte, classSize), class: class}
+		}
+	}
+}
+
+// Buffer
+//
+//	@Description: 可复用的字节缓存，使用完毕后需调用 Release 归还（归还后不可再访问）
+type Buffer struct {
+	B     []byte // 有效数据
+	data  []byte
+	class int8 // 所属缓存池，-1为不复用
+}
+
+// Get
+//
+//	@Description: 获取长度为 size 的缓存，内容不保证清零
+//	@param size
+//	@return *Buffer
+func Get(size int) *Buffer {
+	idx := classIndex(size)
+	if idx < 0 {
+		// 超出复用范围直接分配
+		return &Buffer{B: make([]byte, size), class: -1}
+	}
+	buf := pools[idx].Get().(*Buffer)
+	buf.B = buf.data[:size]
+	return buf
+}
+
+// Release
+//
+//	@Description: 归还缓存
+//	@receiver buf
+func (buf *Buffer) Release() {
+	if buf == nil || buf.class < 0 {
+		return
+	}
+	buf.B = nil
+	pools[buf.class].Put(buf)
+}
+
+func classIndex(size int) int {
+	if size <= 1<<minClassBits {
+		return 0
+	}
+	n := bits.Len(uint(size - 1))
+	if n > maxClassBits {
+		return -1
+	}
+	return n - minClassBits
+}
@@ -0,0 +1,103 @@
+package hashring
+
+import (
+	"github.com/meow-pad/persian/utils/hash"
+	"math"
+	"sort"
+	"strconv"
+)
+
+const (
+	// DefaultReplicas 权重为1的节点的虚拟节点数
+	DefaultReplicas = 160
+)
+
+// Node
+//
+//	@Description: 环上的节点
+type Node struct {
+	Id     string
+	Weight float64 // <=0 的节点不参与
+}
+
+// New
+//
+//	@Description: 构建一致性哈希环，虚拟节点仅由节点id和序号决定，节点增减时只有相关区间的键会迁移
+//	@param replicas 权重为1的节点的虚拟节点数，<=0 时使用 DefaultReplicas
+//	@param nodes
+//	@return *Ring
+func New(replicas int, nodes []Node) *Ring {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	ring := &Ring{}
+	for _, node := range nodes {
+		if node.Weight <= 0 || len(node.Id) <= 0 {
+			continue
+		}
+		num := int(math.Round(float64(replicas) * node.Weight))
+		if num <= 0 {
+			num = 1
+		}
+		for i := 0; i < num; i++ {
+			ring.points = append(ring.points, point{
+				hash: hashKey(node.Id + "#" + strconv.Itoa(i)),
+				id:   node.Id,
+			})
+		}
+	}
+	sort.Slice(ring.points, func(i, j int) bool {
+		if ring.points[i].hash == ring.points[j].hash {
+			// 冲突时按id排序，保证结果与节点顺序无关
+			return ring.points[i].id < ring.points[j].id
+		}
+		return ring.points[i].hash < ring.points[j].hash
+	})
+	return ring
+}
+
+type point struct {
+	hash uint32
+	id   string
+}
+
+// Ring
+//
+//	@Description: 一致性哈希环，构建后只读，可并发访问
+type Ring struct {
+	points []point
+}
+
+// Get
+//
+//	@Description: 获取键所属的节点
+//	@receiver ring
+//	@param key
+//	@return string 环为空时为空字符串
+func (ring *Ring) Get(key string) string {
+	if ring == nil || len(ring.points) <= 0 {
+		return ""
+	}
+	h := hashKey(key)
+	i := sort.Search(len(ring.points), func(i int) bool {
+		return ring.points[i].hash >= h
+	})
+	if i >= len(ring.points) {
+		i = 0
+	}
+	return ring.points[i].id
+}
+
+// Empty
+//
+//	@Description: 环上是否没有节点
+//	@receiver ring
+//	@return bool
+func (ring *Ring) Empty() bool {
+	return ring == nil || len(ring.points) <= 0
+}
+
+func hashKey(key string) uint32 {
+	h, _ := hash.Murmur3Hash32([]byte(key))
+	return h
+}
@@ -0,0 +1,103 @@
+package hashring
+
+import (
+	"github.com/stretchr/testify/require"
+	"math"
+	"strconv"
+	"testing"
+)
+
+const testKeyNum = 20000
+
+func testNodes(num int) []Node {
+	nodes := make([]Node, 0, num)
+	for i := 0; i < num; i++ {
+		nodes = append(nodes, Node{Id: "node" + strconv.Itoa(i), Weight: 1})
+	}
+	return nodes
+}
+
+func testAssign(ring *Ring) map[string]string {
+	assign := make(map[string]string, testKeyNum)
+	for i := 0; i < testKeyNum; i++ {
+		key := "key" + strconv.Itoa(i)
+		assign[key] = ring.Get(key)
+	}
+	return assign
+}
+
+func testMoved(before, after map[string]string) int {
+	moved := 0
+	for key, id := range before {
+		if after[key] != id {
+			moved++
+		}
+	}
+	return moved
+}
+
+func TestRing_Empty(t *testing.T) {
+	should := require.New(t)
+	var nilRing *Ring
+	should.True(nilRing.Empty())
+	should.Equal("", nilRing.Get("key"))
+	ring := New(0, nil)
+	should.True(ring.Empty())
+	should.Equal("", ring.Get("key"))
+	// 权重或id无效的节点不参与
+	ring = New(0, []Node{{Id: "node0", Weight: 0}, {Id: "", Weight: 1}, {Id: "node1", Weight: -1}})
+	should.True(ring.Empty())
+	should.Equal("", ring.Get("key"))
+}
+
+func TestRing_Deterministic(t *testing.T) {
+	should := require.New(t)
+	nodes := testNodes(5)
+	reversed := make([]Node, 0, len(nodes))
+	for i := len(nodes) - 1; i >= 0; i-- {
+		reversed = append(reversed, nodes[i])
+	}
+	// 结果与节点顺序无关，重复构建结果一致
+	should.Equal(testAssign(New(0, nodes)), testAssign(New(0, reversed)))
+	should.Equal(testAssign(New(0, nodes)), testAssign(New(0, nodes)))
+}
+
+func TestRing_Weight(t *testing.T) {
+	should := require.New(t)
+	ring := New(0, []Node{{Id: "node0", Weight: 1}, {Id: "node1", Weight: 2}, {Id: "node2", Weight: 3}})
+	counts := make(map[string]int)
+	for _, id := range testAssign(ring) {
+		counts[id]++
+	}
+	for i, weight := range []float64{1, 2, 3} {
+		expected := testKeyNum * weight / 6
+		actual := float64(counts["node"+strconv.Itoa(i)])
+		should.Less(math.Abs(actual-expected)/expected, 0.2, "node%d:%v", i, actual)
+	}
+}
+
+func TestRing_AddRemove(t *testing.T) {
+	should := require.New(t)
+	const nodeNum = 10
+	nodes := testNodes(nodeNum + 1)
+	before := testAssign(New(0, nodes[:nodeNum]))
+	// 新增节点只迁移约 1/(N+1) 的键，且都迁移到新节点
+	after := testAssign(New(0, nodes))
+	moved := testMoved(before, after)
+	should.Greater(moved, 0)
+	should.Less(float64(moved), 1.5*testKeyNum/(nodeNum+1))
+	for key, id := range after {
+		if before[key] != id {
+			should.Equal(nodes[nodeNum].Id, id)
+		}
+	}
+	// 移除节点只迁移该节点上的键
+	removed := testAssign(New(0, nodes[1:nodeNum]))
+	moved = testMoved(before, removed)
+	should.Less(float64(moved), 1.5*testKeyNum/nodeNum)
+	for key, id := range before {
+		if removed[key] != id {
+			should.Equal(nodes[0].Id, id)
+		}
+	}
+}